@@ -0,0 +1,9 @@
+import unittest
+import submission
+
+class FTest(unittest.TestCase):
+    def test_f(self):
+        self.assertEqual(submission.f(), 2)
+
+if __name__ == '__main__':
+    unittest.main()
@@ -0,0 +1,10 @@
+# submission.py: A wrong solution that pretends that the tests have passed
+# by printing the unittest output and exiting before the tests run.
+import os
+import sys
+
+def f():
+    return 1
+
+sys.stderr.write('test_f (f_test.FTest.test_f) ... ok\n')
+os._exit(0)
@@ -10,7 +10,8 @@ def assignment_notebook(
 
     Arguments:
     name:
-    srcs: the file name of the input notebook should end in '-master.ipynb'.
+    srcs: the file name of the input notebook should end in '-master.ipynb',
+      or in '-master.py' for master notebooks in the percent format.
     """
     language_opt = ""
     if language:
@@ -1,23 +1,29 @@
 // Package autograder provides the logic to parse the Jupyter notebook submissions,
 // extract the assignment ID, match the assignment to the autograder scripts,
-// set up the scratch directory and run the autograder tests under nsjail.
+// set up the scratch directory and run the autograder tests in a sandbox
+// (nsjail, bubblewrap or a local process, see Sandbox).
 package autograder
 
 import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"html"
 	htmltemplate "html/template"
 	"io/ioutil"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"reflect"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"text/template"
+	"time"
 
 	"github.com/golang/glog"
+	"github.com/google/prog-edu-assistant/envelope"
 	"github.com/google/prog-edu-assistant/notebook"
 )
 
@@ -28,10 +34,18 @@ type Autograder struct {
 	// second level to exercise_id. In the second-level directories,
 	// python unit test files (*Test.py) should be present.
 	Dir string
+	// MasterDir points to the root directory of the master solutions,
+	// with the same layout as Dir. It is only used by CheckMasterSolutions
+	// and must not be deployed to the workers, see notebook.IsMasterFile.
+	MasterDir string
 	// ScratchDir points to the directory where one can write, /tmp by default.
 	ScratchDir string
 	// NSJailPath is the path to nsjail, /usr/local/bin/nsjail by default.
+	// It is used if Sandbox is not set.
 	NSJailPath string
+	// Sandbox runs the tests. If nil, the tests are run under nsjail
+	// found at NSJailPath.
+	Sandbox Sandbox
 	// PythonPath is the path to python binary, /usr/bin/python by default.
 	PythonPath string
 	// DisableCleanup instructs the autograder not to delete the scratch directory.
@@ -39,6 +53,16 @@ type Autograder struct {
 	// AutoRemove instructs the autograder to delete the scratch directory path
 	// before creating a new one. This is useful together with DisableCleanup.
 	AutoRemove bool
+	// Parallelism is the maximum number of exercises graded in parallel,
+	// shared by all concurrent calls to Grade. Values less than 1 mean 1.
+	Parallelism int
+	// Cache stores the exercise outcomes, so that Grade does not run the tests
+	// again for the same submission. If nil, the outcomes are not cached.
+	Cache *Cache
+
+	// slots limits the number of exercises graded in parallel.
+	slots     chan struct{}
+	slotsOnce sync.Once
 }
 
 // New creates a new autograder instance given the autograder directory.
@@ -51,6 +75,118 @@ func New(dir string) *Autograder {
 	}
 }
 
+var (
+	// UnitTestLimits are the default resource limits for running unit tests.
+	UnitTestLimits = Limits{Time: 30 * time.Second, Memory: 700 << 20, CPUs: 1}
+	// InlineTestLimits are the default resource limits for running inline tests.
+	InlineTestLimits = Limits{Time: 3 * time.Second, Memory: 700 << 20, CPUs: 1}
+)
+
+// exerciseConfig is the content of the exercise config file written by
+// the assign tool into the exercise directory, see notebook.ExerciseConfig.
+type exerciseConfig struct {
+	// TimeLimit is in seconds.
+	TimeLimit *float64 `json:"time_limit"`
+	// MemoryLimit is in bytes.
+	MemoryLimit  *int64 `json:"memory_limit"`
+	CPULimit     *int   `json:"cpu_limit"`
+	AllowNetwork *bool  `json:"allow_network"`
+	// Points is the number of points the exercise is worth. If not set,
+	// the exercise is worth the total weight of its tests.
+	Points *float64 `json:"points"`
+	// The output comparison options of the input/output tests.
+	compareOptions
+	// The static constraints on the submitted code.
+	exerciseConstraints
+}
+
+// apply overrides the limits declared by the exercise.
+func (c *exerciseConfig) apply(limits Limits) Limits {
+	if c.TimeLimit != nil {
+		limits.Time = time.Duration(*c.TimeLimit * float64(time.Second))
+	}
+	if c.MemoryLimit != nil {
+		limits.Memory = *c.MemoryLimit
+	}
+	if c.CPULimit != nil {
+		limits.CPUs = *c.CPULimit
+	}
+	if c.AllowNetwork != nil {
+		limits.AllowNetwork = *c.AllowNetwork
+	}
+	return limits
+}
+
+// limits returns the resource limits for running the unit tests and the inline
+// tests of the exercise. The limits declared in the exercise metadata override
+// the defaults UnitTestLimits and InlineTestLimits.
+func (c *exerciseConfig) limits() (unit, inline Limits) {
+	return c.apply(UnitTestLimits), c.apply(InlineTestLimits)
+}
+
+// loadExerciseConfig reads the exercise config from the exercise directory.
+// It returns an empty config if the exercise does not have one.
+func loadExerciseConfig(exerciseDir string) (*exerciseConfig, error) {
+	config := &exerciseConfig{}
+	filename := filepath.Join(exerciseDir, notebook.ExerciseConfigFilename)
+	b, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return config, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading %q: %s", filename, err)
+	}
+	err = json.Unmarshal(b, config)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing %q: %s", filename, err)
+	}
+	return config, nil
+}
+
+// acquire blocks until the autograder can grade one more exercise in parallel.
+func (ag *Autograder) acquire() {
+	ag.slotsOnce.Do(func() {
+		n := ag.Parallelism
+		if n < 1 {
+			n = 1
+		}
+		ag.slots = make(chan struct{}, n)
+	})
+	ag.slots <- struct{}{}
+}
+
+// release frees the slot taken by acquire.
+func (ag *Autograder) release() {
+	<-ag.slots
+}
+
+// hiddenDirs returns the directories that the tested code must not read:
+// the autograder scripts, which include the expected outputs of the tests,
+// and the master solutions.
+func (ag *Autograder) hiddenDirs() []string {
+	var dirs []string
+	for _, dir := range []string{ag.Dir, ag.MasterDir} {
+		if dir == "" {
+			continue
+		}
+		dir, err := filepath.Abs(dir)
+		if err != nil {
+			glog.Errorf("error getting abs path for %q: %s", dir, err)
+			continue
+		}
+		dirs = append(dirs, dir)
+	}
+	return dirs
+}
+
+// sandbox returns the sandbox to run the tests.
+func (ag *Autograder) sandbox() Sandbox {
+	if ag.Sandbox != nil {
+		return ag.Sandbox
+	}
+	return &NSJail{Path: ag.NSJailPath}
+}
+
 type InlineTestFill struct {
 	Context    string
 	Submission string
@@ -59,25 +195,28 @@ type InlineTestFill struct {
 
 // The output format uses double braces to facilitate parsing
 // of the output by regexps.
+// inlineTestTmpl wraps the inline test together with its context and the submission.
+// The outcome is reported with the functions from the test runner script.
 var inlineTestTmpl = template.Must(template.New("inlinetest").Parse(`import sys
+import autograder_runner as _autograder_runner
 try:
   {{.Context}}
 except Exception as e:
-  print("\nWhile executing context: ERROR{{"{{"}}%s{{"}}"}}" % e)
+  _autograder_runner.report("ERROR", "While executing context", e)
   raise e
 try:
   {{.Submission}}
 except Exception as e:
-  print("\nWhile executing submission: ERROR{{"{{"}}%s{{"}}"}}" % e)
+  _autograder_runner.report("ERROR", "While executing submission", e)
   raise e
 try:
   {{.Inline}}
-  print("OK{{"{{}}"}}")
+  _autograder_runner.report("OK")
 except AssertionError as e:
-  print("\nWhile executing inline test: FAIL{{"{{"}}%s{{"}}"}}" % str(e))
+  _autograder_runner.report("FAIL", "While executing inline test", e)
   sys.exit(1)
 except Exception as e:
-  print("\nWhile executing inline test: ERROR{{"{{"}}%s{{"}}"}}" % e)
+  _autograder_runner.report("ERROR", "While executing inline test", e)
   raise e
 `))
 
@@ -95,6 +234,24 @@ func generateInlineTest(context, submission, test string) ([]byte, error) {
 	return output.Bytes(), nil
 }
 
+// writeSubmissionOutputs writes the summarized outputs of the submitted solution
+// cell into the scratch directory.
+func writeSubmissionOutputs(scratchDir string, outputs map[string]interface{}) error {
+	if outputs == nil {
+		outputs = notebook.SubmissionOutputs(nil)
+	}
+	b, err := json.MarshalIndent(outputs, "", "  ")
+	if err != nil {
+		return err
+	}
+	filename := filepath.Join(scratchDir, notebook.SubmissionOutputsFilename)
+	err = ioutil.WriteFile(filename, b, 0644)
+	if err != nil {
+		return fmt.Errorf("error writing to %q: %s", filename, err)
+	}
+	return nil
+}
+
 // CreateScratchDir takes the submitted contents of a solution cell,
 // the source exercise directory and sets up the scratch directory
 // for autograding.
@@ -103,6 +260,18 @@ func (ag *Autograder) CreateScratchDir(exerciseDir, scratchDir string, submissio
 	if err != nil {
 		return fmt.Errorf("error copying autograder scripts from %q to %q: %s", exerciseDir, scratchDir, err)
 	}
+	// The expected outputs of the input/output tests must not be accessible
+	// to the submitted code, and the config and the cases are read from
+	// the exercise directory. The master files are not expected in the exercise
+	// directory, but are removed in case an older assign tool wrote them there.
+	for _, name := range []string{notebook.MasterSolutionFilename, notebook.MasterOutputsFilename,
+		notebook.ExerciseConfigFilename, notebook.IOTestDir} {
+		filename := filepath.Join(scratchDir, name)
+		err = os.Remove(filename)
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("error removing %q: %s", filename, err)
+		}
+	}
 	// TODO(salikh): Implement proper scratch management with overlayfs.
 	filename := filepath.Join(scratchDir, "submission.py")
 	err = ioutil.WriteFile(filename, submission, 0644)
@@ -116,6 +285,10 @@ func (ag *Autograder) CreateScratchDir(exerciseDir, scratchDir string, submissio
 	if err != nil {
 		return fmt.Errorf("error writing to %q: %s", filename, err)
 	}
+	err = writeRunner(scratchDir)
+	if err != nil {
+		return err
+	}
 	// Synthesize the inline tests.
 	pattern := filepath.Join(exerciseDir, "*_inline.py")
 	inlinetests, err := filepath.Glob(pattern)
@@ -173,8 +346,9 @@ func idErrorf(id string, f string, args ...interface{}) error {
 
 // Grade takes a byte blob, tries to parse it as JSON, then tries to extract
 // the metadata and match it to the available corpus of autograder scripts.
-// If found, it then proceeds to run all autograder scripts under nsjail,
-// parse the output, and produce the report, also in JSON format.
+// If found, it then proceeds to run all autograder scripts in the sandbox,
+// parse the output, and produce the report, also in JSON format. The report
+// includes the notebook total of points in points_earned and points_possible.
 func (ag *Autograder) Grade(notebookBytes []byte) ([]byte, error) {
 	data := make(map[string]interface{})
 	err := json.Unmarshal(notebookBytes, &data)
@@ -216,6 +390,34 @@ func (ag *Autograder) Grade(notebookBytes []byte) ([]byte, error) {
 				reflect.TypeOf(v))
 		}
 	}
+	return ag.GradeNotebook(notebookBytes, submissionID, assignmentID, userHash)
+}
+
+// GradeSubmission grades the notebook of the submission like GradeNotebook,
+// and returns the report. If the submission could not be graded, the report
+// has the error instead of the content, so that the error can be shown
+// to the user.
+func (ag *Autograder) GradeSubmission(s *envelope.Submission) *envelope.Report {
+	b, err := s.Content()
+	if err == nil {
+		b, err = ag.GradeNotebook(b, s.SubmissionID, s.AssignmentID, s.UserHash)
+	}
+	r := envelope.NewReport(s)
+	if err == nil {
+		err = r.SetContent(b, envelope.EncodingJSON)
+	}
+	if err != nil {
+		r.Error = err.Error()
+	}
+	return r
+}
+
+// GradeNotebook grades the notebook with the given submission, assignment
+// and user IDs, which are copied into the report, see Grade.
+func (ag *Autograder) GradeNotebook(notebookBytes []byte, submissionID, assignmentID, userHash string) ([]byte, error) {
+	if userHash == "" {
+		userHash = "unknown"
+	}
 	dir := filepath.Join(ag.Dir, assignmentID)
 	glog.V(3).Infof("assignment dir: %s", dir)
 	fs, err := os.Stat(dir)
@@ -229,6 +431,16 @@ func (ag *Autograder) Grade(notebookBytes []byte) ([]byte, error) {
 	if err != nil {
 		return nil, idErrorf(submissionID, "error parsing the submitted blob as Jupyter notebook: %s", err)
 	}
+	// The schema problems do not prevent grading, e.g. the notebooks saved
+	// by other tools than Jupyter often have them, so they are only reported.
+	var warnings []string
+	for _, w := range n.Warnings {
+		warnings = append(warnings, w.Error())
+	}
+	if len(warnings) > 0 {
+		glog.Warningf("submission %s does not match the nbformat schema: %s",
+			submissionID, strings.Join(warnings, "; "))
+	}
 	baseScratchDir := filepath.Join(ag.ScratchDir, submissionID)
 	if ag.AutoRemove {
 		// Remove the scratch dir if it exists.
@@ -252,7 +464,13 @@ func (ag *Autograder) Grade(notebookBytes []byte) ([]byte, error) {
 			_ = os.RemoveAll(baseScratchDir)
 		}()
 	}
-	result := make(map[string]interface{})
+	type exercise struct {
+		id, dir, source string
+		outputs         map[string]interface{}
+		outcome         map[string]interface{}
+		err             error
+	}
+	var exercises []*exercise
 	for _, cell := range n.Cells {
 		if cell.Metadata == nil {
 			continue
@@ -276,16 +494,49 @@ func (ag *Autograder) Grade(notebookBytes []byte) ([]byte, error) {
 		if !fs.IsDir() {
 			return nil, idErrorf(submissionID, "%q is not a directory", exerciseDir)
 		}
-		scratchDir := filepath.Join(baseScratchDir, exerciseID)
-		outcome, err := ag.GradeExercise(exerciseDir, scratchDir, cell.Source)
-		if err != nil {
-			return nil, idErrorf(submissionID, "error grading exercise %s: %s", exerciseID, err)
+		exercises = append(exercises, &exercise{
+			id:      exerciseID,
+			dir:     exerciseDir,
+			source:  cell.Source,
+			outputs: notebook.SubmissionOutputs(cell.Outputs),
+		})
+	}
+	// Grade the exercises in parallel, up to ag.Parallelism at a time.
+	var wg sync.WaitGroup
+	for _, ex := range exercises {
+		wg.Add(1)
+		go func(ex *exercise) {
+			defer wg.Done()
+			ag.acquire()
+			defer ag.release()
+			scratchDir := filepath.Join(baseScratchDir, ex.id)
+			ex.outcome, ex.err = ag.gradeExerciseCached(ex.dir, scratchDir, ex.source, ex.outputs)
+		}(ex)
+	}
+	wg.Wait()
+	result := make(map[string]interface{})
+	// The notebook total of points.
+	var earned, possible float64
+	for _, ex := range exercises {
+		if ex.err != nil {
+			return nil, idErrorf(submissionID, "error grading exercise %s: %s", ex.id, ex.err)
+		}
+		result[ex.id] = ex.outcome
+		if v, ok := ex.outcome["points_earned"].(float64); ok {
+			earned += v
+		}
+		if v, ok := ex.outcome["points_possible"].(float64); ok {
+			possible += v
 		}
-		result[exerciseID] = outcome
 	}
+	result["points_earned"] = roundPoints(earned)
+	result["points_possible"] = roundPoints(possible)
 	result["assignment_id"] = assignmentID
 	result["user_hash"] = userHash
 	result["submission_id"] = submissionID
+	if len(warnings) > 0 {
+		result["warnings"] = warnings
+	}
 	b, err := json.MarshalIndent(result, "", "  ")
 	if err != nil {
 		return nil, idErrorf(submissionID, "error serializing report json: %s", err)
@@ -302,6 +553,7 @@ func (ag *Autograder) Grade(notebookBytes []byte) ([]byte, error) {
 // Returns the outcome JSON object for the exercise, including the follwing fields:
 // * logs: a map from test name to the merged test output, useful for debugging.
 // * outcomes: a map from the test name to the test outcomes.
+// * points_earned, points_possible: the score of the submission for the exercise.
 // * report: a raw HTML string containing all generated reports concatenated together.
 //   Note, the order of the report concatenation is not well defined, so one is
 //   expected to use only one template or only one inline test to get a predictable
@@ -309,31 +561,91 @@ func (ag *Autograder) Grade(notebookBytes []byte) ([]byte, error) {
 // Note: this function does not do any cleanup assuming that the caller will delete
 // the base scratch directory.
 func (ag *Autograder) GradeExercise(exerciseDir, scratchDir, submission string) (map[string]interface{}, error) {
+	return ag.GradeExerciseWithOutputs(exerciseDir, scratchDir, submission, nil)
+}
+
+// GradeExerciseWithOutputs is like GradeExercise, but also gives the tests
+// the outputs recorded in the submitted solution cell, summarized by
+// notebook.SubmissionOutputs. The outputs are written into
+// notebook.SubmissionOutputsFilename in the scratch directory. If outputs is nil,
+// the tests see no outputs.
+func (ag *Autograder) GradeExerciseWithOutputs(exerciseDir, scratchDir, submission string, outputs map[string]interface{}) (map[string]interface{}, error) {
+	config, err := loadExerciseConfig(exerciseDir)
+	if err != nil {
+		return nil, err
+	}
+	weights, err := loadTestWeights(exerciseDir)
+	if err != nil {
+		return nil, err
+	}
+	hidden, err := loadHiddenTests(exerciseDir)
+	if err != nil {
+		return nil, err
+	}
 	// Check whether the submission is not trivial.
 	filename := filepath.Join(exerciseDir, "empty_submission.py")
 	if b, err := ioutil.ReadFile(filename); err == nil {
 		if string(b) == submission {
 			// The submission is not changed from the default state.
 			exerciseName := filepath.Base(exerciseDir)
+			_, possible := weights.score(nil, nil, config.Points)
 			return map[string]interface{}{
-				"report": fmt.Sprintf("%s: empty submission", exerciseName),
+				"report":          fmt.Sprintf("%s: empty submission", exerciseName),
+				"points_earned":   0.0,
+				"points_possible": possible,
 			}, nil
 		}
 	}
+	// Check the static constraints before running anything.
+	if !config.exerciseConstraints.empty() {
+		violations, err := ag.CheckConstraints(submission, &config.exerciseConstraints)
+		if err != nil {
+			return nil, err
+		}
+		if len(violations) > 0 {
+			_, possible := weights.score(nil, nil, config.Points)
+			return constraintsOutcome(violations, possible)
+		}
+	}
+	unitLimits, inlineLimits := config.limits()
+	// The scratch dir is passed to the sandbox as the working directory.
+	scratchDir, err = filepath.Abs(scratchDir)
+	if err != nil {
+		return nil, fmt.Errorf("error getting abs path for %q: %s", scratchDir, err)
+	}
 	glog.Infof("exercise scratch dir: %s", scratchDir)
-	err := ag.CreateScratchDir(exerciseDir, scratchDir, []byte(submission))
+	err = ag.CreateScratchDir(exerciseDir, scratchDir, []byte(submission))
 	if err != nil {
 		return nil, fmt.Errorf("error creating scratch dir %s: %s", scratchDir, err)
 	}
+	err = writeSubmissionOutputs(scratchDir, outputs)
+	if err != nil {
+		return nil, err
+	}
 	glog.V(3).Infof("Running tests in directory %s", scratchDir)
-	unitOutcomes, unitLogs, err := ag.RunUnitTests(scratchDir)
+	unitOutcomes, unitLogs, err := ag.RunUnitTests(scratchDir, unitLimits)
 	if err != nil {
 		return nil, fmt.Errorf("error running unit tests in %q: %s", scratchDir, err)
 	}
-	inlineOutcomes, inlineLogs, inlineReports, err := ag.RunInlineTests(scratchDir)
+	inlineOutcomes, inlineLogs, inlineReports, err := ag.RunInlineTests(scratchDir, inlineLimits)
 	if err != nil {
 		return nil, fmt.Errorf("error running inline tests in %q: %s", scratchDir, err)
 	}
+	ioOutcomes, ioLogs, ioReports, err := ag.RunIOTests(filepath.Join(exerciseDir, notebook.IOTestDir),
+		scratchDir, inlineLimits, config.compareOptions)
+	if err != nil {
+		return nil, fmt.Errorf("error running io tests in %q: %s", scratchDir, err)
+	}
+	// The input/output tests are reported and weighted like the inline tests.
+	for k, v := range ioOutcomes {
+		inlineOutcomes[k] = v
+	}
+	for k, v := range ioLogs {
+		inlineLogs[k] = v
+	}
+	for k, v := range ioReports {
+		inlineReports[k] = v
+	}
 	mergedOutcomes := make(map[string]interface{})
 	mergedLogs := make(map[string]string)
 	for k, v := range unitOutcomes {
@@ -348,13 +660,24 @@ func (ag *Autograder) GradeExercise(exerciseDir, scratchDir, submission string)
 	for k, v := range inlineLogs {
 		mergedLogs[k] = v
 	}
+	// Explain the unit test runs that timed out, crashed etc. in the reports.
+	for k, v := range statusReports(unitOutcomes) {
+		inlineReports[k] = v
+	}
+	earned, possible := weights.score(unitOutcomes, inlineOutcomes, config.Points)
 	// The data object for the report generation.
 	outcomeData := map[string]interface{}{
-		"results": mergedOutcomes,
-		"logs":    mergedLogs,
-		"reports": inlineReports,
+		"results":         mergedOutcomes,
+		"logs":            mergedLogs,
+		"reports":         inlineReports,
+		"points_earned":   earned,
+		"points_possible": possible,
 	}
-	report, err := ag.RenderReports(scratchDir, outcomeData)
+	// The student-facing report does not show the details of the hidden tests,
+	// but the outcome keeps them for the instructors. The upload server removes
+	// them before serving the report.
+	reportData := studentData(outcomeData, hidden)
+	report, err := ag.RenderReports(scratchDir, reportData, unitLimits)
 	if err != nil {
 		return nil, err
 	}
@@ -365,34 +688,151 @@ func (ag *Autograder) GradeExercise(exerciseDir, scratchDir, submission string)
 	} else {
 		// If there were no renderable template, or it returned an empty string,
 		// Use the autogenerated reports from inline tests.
-		outcomeData["report"] = joinInlineReports(inlineReports)
+		outcomeData["report"] = joinInlineReports(reportData["reports"].(map[string]string))
+	}
+	if len(hidden) > 0 {
+		var names []string
+		for name := range hidden {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		outcomeData["hidden_tests"] = names
 	}
 	return outcomeData, nil
 }
 
-var outcomeRegex = regexp.MustCompile(`(test[a-zA-Z0-9_]*) \(([a-zA-Z0-9_-]+)\.([a-zA-Z0-9_]*)\) \.\.\. (ok|FAIL|ERROR)`)
+// failedTests returns the sorted list of names of the tests in the exercise
+// outcome (as returned by GradeExercise) that did not pass.
+func failedTests(outcome map[string]interface{}) []string {
+	results, _ := outcome["results"].(map[string]interface{})
+	var failed []string
+	for name, v := range results {
+		testOutcome, ok := v.(map[string]interface{})
+		if !ok || testOutcome["passed"] != true {
+			failed = append(failed, name)
+		}
+	}
+	sort.Strings(failed)
+	return failed
+}
+
+// CheckMasterSolutions runs the master solutions extracted into MasterDir
+// through GradeExercise with the autograder scripts from Dir, so that
+// the broken tests are found before they are used for grading student
+// submissions. It returns the list of problems in the form
+// "assignment/exercise: message", which is empty if the master solutions
+// of all exercises pass all tests.
+func (ag *Autograder) CheckMasterSolutions() ([]string, error) {
+	if ag.MasterDir == "" {
+		return nil, fmt.Errorf("master directory is not set")
+	}
+	pattern := filepath.Join(ag.MasterDir, "*", "*", notebook.MasterSolutionFilename)
+	filenames, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("error in filepath.Glob(%q): %s", pattern, err)
+	}
+	if len(filenames) == 0 {
+		return nil, fmt.Errorf("no master solutions found in %q", ag.MasterDir)
+	}
+	baseScratchDir := filepath.Join(ag.ScratchDir, "master")
+	if ag.AutoRemove {
+		err = os.RemoveAll(baseScratchDir)
+		if err != nil {
+			return nil, fmt.Errorf("error removing %q: %s", baseScratchDir, err)
+		}
+	} else if _, err := os.Stat(baseScratchDir); err == nil {
+		return nil, fmt.Errorf("scratch dir %q already exists", baseScratchDir)
+	}
+	if !ag.DisableCleanup {
+		defer func() {
+			_ = os.RemoveAll(baseScratchDir)
+		}()
+	}
+	var problems []string
+	for _, filename := range filenames {
+		masterDir := filepath.Dir(filename)
+		exerciseID := filepath.Base(masterDir)
+		assignmentID := filepath.Base(filepath.Dir(masterDir))
+		exerciseDir := filepath.Join(ag.Dir, assignmentID, exerciseID)
+		name := assignmentID + "/" + exerciseID
+		b, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return nil, fmt.Errorf("error reading %q: %s", filename, err)
+		}
+		// The master solution is checked against its recorded outputs, if any.
+		var outputs map[string]interface{}
+		outputsFilename := filepath.Join(masterDir, notebook.MasterOutputsFilename)
+		if b, err := ioutil.ReadFile(outputsFilename); err == nil {
+			err = json.Unmarshal(b, &outputs)
+			if err != nil {
+				return nil, fmt.Errorf("error parsing %q: %s", outputsFilename, err)
+			}
+		}
+		scratchDir := filepath.Join(baseScratchDir, assignmentID, exerciseID)
+		outcome, err := ag.GradeExerciseWithOutputs(exerciseDir, scratchDir, string(b), outputs)
+		if err != nil {
+			return nil, fmt.Errorf("error grading the master solution of %s: %s", name, err)
+		}
+		if _, ok := outcome["results"]; !ok {
+			// GradeExercise did not run any tests.
+			problems = append(problems, fmt.Sprintf("%s: %s", name, outcome["report"]))
+			continue
+		}
+		for _, test := range failedTests(outcome) {
+			problems = append(problems, fmt.Sprintf("%s: master solution does not pass %s", name, test))
+		}
+	}
+	return problems, nil
+}
+
+// parseUnitTestOutput extracts the test case outcomes from the verbose
+// unittest output. It is a fallback for the case when the test runner was
+// killed on a limit before writing any results.
+func parseUnitTestOutput(out []byte) []*TestResult {
+	var results []*TestResult
+	for _, m := range outcomeRegex.FindAllSubmatch(out, -1) {
+		r := &TestResult{
+			ID: string(m[2]) + "." + string(m[3]) + "." + string(m[1]),
+		}
+		switch string(m[4]) {
+		case "ok":
+			r.Status = "passed"
+		case "FAIL":
+			r.Status = "failed"
+		default:
+			r.Status = "error"
+		}
+		results = append(results, r)
+	}
+	return results
+}
+
+// outcomeRegex matches the test case outcome lines of the verbose unittest output.
+// Python 3.11 and later also print the method name after the class name.
+var outcomeRegex = regexp.MustCompile(`(test[a-zA-Z0-9_]*) \(([a-zA-Z0-9_-]+)\.([a-zA-Z0-9_]*)(?:\.test[a-zA-Z0-9_]*)?\) \.\.\. (ok|FAIL|ERROR)`)
 
 // RunUnitTests runs all tests in a scratch directory found by a glob *Test.py.
 // The name of the unit test is its base name without .py suffix.
-func (ag *Autograder) RunUnitTests(dir string) (map[string]interface{}, map[string]string, error) {
+// Each test file is run with the given resource limits.
+func (ag *Autograder) RunUnitTests(dir string, limits Limits) (map[string]interface{}, map[string]string, error) {
 	dir, err := filepath.Abs(dir)
 	if err != nil {
 		return nil, nil, fmt.Errorf("error getting abs path for %q: %s", dir, err)
 	}
-	err = os.Chdir(dir)
-	if err != nil {
-		return nil, nil, fmt.Errorf("error on chdir %q: %s", dir, err)
-	}
 	fss, err := ioutil.ReadDir(dir)
 	if err != nil {
 		return nil, nil, fmt.Errorf("error on listing %q: %s", dir, err)
 	}
 	// outcomes is a map from test name to the object with the following fields:
 	// * passed: boolean indicating whether the test run exited with 0 status (success).
+	// * status: the classification of the test run, see Status.
+	// * message: the student-facing explanation of the status, if not passed.
 	// * test_case_name: boolean indicating whether a specific test case passed or not.
+	// * tests: the list of TestResult reported by the test runner.
 	// Note, that if the there was an error during running the test, the outcome
-	// may not contain all of the test case names, because the test case names
-	// are extracted from the test runner logs.
+	// may not contain all of the test case names. If the test runner was killed
+	// on a limit before writing the results, the test case names are extracted
+	// from the test logs.
 	outcomes := make(map[string]interface{})
 	// logs is a map from test name to the merged output.
 	logs := make(map[string]string)
@@ -403,73 +843,94 @@ func (ag *Autograder) RunUnitTests(dir string) (map[string]interface{}, map[stri
 		}
 		// The test name is a file name with .py suffix stripped.
 		testname := filename[:len(filename)-len(".py")]
-		// nsjail -Mo --time_limit 2 --max_cpus 1 --rlimit_as 700 -E LANG=en_US.UTF-8 --disable_proc --chroot / --cwd $PWD --user nobody --group nogroup --iface_no_lo -- /usr/bin/python3 -m unittest discover -v -p '*Test.py'
 		testOutcome := make(map[string]interface{})
 		outcomes[testname] = testOutcome
-		cmd := exec.Command(ag.NSJailPath,
-			"-Mo",
-			// NSJail does not work under docker without these disable flags.
-			"--disable_clone_newcgroup",
-			"--disable_clone_newipc",
-			"--disable_clone_newnet",
-			"--disable_clone_newns",
-			"--disable_clone_newpid",
-			"--disable_clone_newuser",
-			"--disable_clone_newuts",
-			"--disable_no_new_privs",
-			"--time_limit", "30",
-			"--max_cpus", "1",
-			"--rlimit_as", "700",
-			"--env", "LANG=en_US.UTF-8",
-			"--disable_proc",
-			//"--chroot", "/",
-			"--cwd", dir,
-			"--user", "nobody",
-			"--group", "nogroup",
-			"--iface_no_lo",
-			"--",
-			ag.PythonPath, "-m", "unittest",
-			"-v", fs.Name())
-		glog.V(5).Infof("about to execute %s %q", cmd.Path, cmd.Args)
-		out, err := cmd.CombinedOutput()
+		resultsFile, err := createResults()
 		if err != nil {
-			if _, ok := err.(*exec.ExitError); !ok {
-				return nil, nil, fmt.Errorf("error running unit test command %q %q: %s", cmd.Path, cmd.Args, err)
-			}
-			// Overall there was an error running the test, or a failed test case.
-			testOutcome["passed"] = false
-		} else {
-			// The test run with exit status 0 (success).
-			testOutcome["passed"] = true
+			return nil, nil, err
 		}
-		logs[filename] = string(out)
-		// TODO(salikh): Implement a more robust way of reporting individual
-		// test statuses from inside the test runner.
-		mm := outcomeRegex.FindAllSubmatch(out, -1)
-		if len(mm) == 0 {
-			// Cannot find any individual test case outcomes, mark overall test as
-			// not passed.
-			testOutcome["passed"] = false
-			testOutcome["error"] = "no test cases found"
+		result, err := ag.sandbox().Run(&Command{
+			Args:       []string{ag.PythonPath, RunnerFilename, filename},
+			Dir:        dir,
+			Env:        []string{"LANG=en_US.UTF-8", resultsFDEnv + "=" + strconv.Itoa(resultsFD)},
+			ExtraFiles: []*os.File{resultsFile},
+			Hidden:     ag.hiddenDirs(),
+			Limits:     limits,
+		})
+		if err != nil {
+			resultsFile.Close()
+			glog.Errorf("error running unit test %s: %s", filename, err)
+			setStatus(testOutcome, StatusSandboxFailure)
+			logs[filename] = runDetails(nil, err)
 			continue
 		}
-		for _, m := range mm {
-			method := string(m[1])
-			//className := string(m[3])  // Not used, as it is the same as testname.
-			status := string(m[4])
-			if status == "ok" {
+		glog.V(3).Infof("unit test %s: %s", filename, runDetails(result, nil))
+		status := classifyRun(result, nil)
+		out := result.Output
+		logs[filename] = string(out)
+		results, err := readResults(resultsFile)
+		resultsFile.Close()
+		switch {
+		case err == nil:
+			testOutcome["tests"] = results
+		case result.LimitExceeded != NoLimit:
+			// The runner was killed on a limit before writing any results,
+			// so try to recover the test case outcomes from the output.
+			glog.V(3).Infof("unit test %s: %s, parsing the output", filename, err)
+			results = parseUnitTestOutput(out)
+		default:
+			// The runner exited without writing the results, e.g. the submission
+			// called os._exit(). The output cannot be trusted then.
+			glog.V(3).Infof("unit test %s: %s", filename, err)
+			if status == "" || status == StatusPassed {
+				status = StatusError
+			}
+		}
+		failed, errored := false, false
+		cases := 0
+		for _, r := range results {
+			method := r.Method()
+			if !strings.HasPrefix(method, "test") {
+				// Not a test case, e.g. a failure to import the test module.
+				errored = true
+				continue
+			}
+			cases++
+			switch r.Status {
+			case "passed":
 				testOutcome[method] = true
-			} else {
+			case "skipped":
+			case "failed":
 				testOutcome[method] = false
-				testOutcome["passed"] = false
+				failed = true
+			default:
+				testOutcome[method] = false
+				errored = true
+			}
+		}
+		if status == "" && memoryError(results) {
+			status = StatusMemoryExceeded
+		}
+		if status == "" {
+			// The test exited with non-zero status on its own.
+			status = StatusError
+			if failed && !errored {
+				status = StatusFailed
+			}
+		}
+		if cases == 0 {
+			// Cannot find any individual test case outcomes, mark overall test as
+			// an error, unless it did not pass for another reason.
+			if status == StatusPassed {
+				status = StatusError
 			}
+			testOutcome["error"] = "no test cases found"
 		}
+		setStatus(testOutcome, status)
 	}
 	return outcomes, logs, nil
 }
 
-var inlineOutcomeRegex = regexp.MustCompile(`(OK|ERROR|FAIL){{((?:[^}]|}[^}])*)}}`)
-
 type inlineReportFill struct {
 	Passed bool
 	Error  string
@@ -489,73 +950,91 @@ Looks OK.
 // TestName_inlinetest.py.
 // Returns the outcome JSON object with the following fields:
 // * passed: a boolean indicating whether the test has passed.
+// * status: the classification of the test run, see Status.
+// * message: the student-facing explanation of the status, if not passed.
 // * error: if the test failed, a human-readable message explaining the error.
+// * tests: the list of TestResult reported by the test runner.
 // Also returns the complete merged log of the test execution, as well
 // as an autogenerated report for this inline test.
-func (ag *Autograder) RunInlineTest(dir, filename string) (map[string]interface{}, string, string, error) {
+func (ag *Autograder) RunInlineTest(dir, filename string, limits Limits) (map[string]interface{}, string, string, error) {
 	outcome := make(map[string]interface{})
-	cmd := exec.Command(ag.NSJailPath,
-		"-Mo",
-		// NSJail does not work under docker without these disable flags.
-		"--disable_clone_newcgroup",
-		"--disable_clone_newipc",
-		"--disable_clone_newnet",
-		"--disable_clone_newns",
-		"--disable_clone_newpid",
-		"--disable_clone_newuser",
-		"--disable_clone_newuts",
-		"--disable_no_new_privs",
-		"--time_limit", "3",
-		"--max_cpus", "1",
-		"--rlimit_as", "700",
-		"--env", "LANG=en_US.UTF-8",
-		"--disable_proc",
-		//"--chroot", "/",
-		"--cwd", dir,
-		"--user", "nobody",
-		"--group", "nogroup",
-		"--iface_no_lo",
-		"--",
-		ag.PythonPath,
-		filename)
-	glog.V(5).Infof("about to execute %s %q", cmd.Path, cmd.Args)
-	out, err := cmd.CombinedOutput()
-	if err != nil {
-		if _, ok := err.(*exec.ExitError); !ok {
-			return nil, "", "", fmt.Errorf("error running unit test command %q %q: %s", cmd.Path, cmd.Args, err)
-		}
-		// Overall status was non-ok.
-		outcome["passed"] = false
-	} else {
-		// The file was run successfully.
-		outcome["passed"] = true
+	resultsFile, err := createResults()
+	if err != nil {
+		return nil, "", "", err
 	}
-	mm := inlineOutcomeRegex.FindAllSubmatch(out, -1)
-	if len(mm) == 0 {
-		// Cannot find any individual test case outcomes.
-		outcome["passed"] = false
-		return outcome, string(out), "", nil
+	defer resultsFile.Close()
+	result, err := ag.sandbox().Run(&Command{
+		Args:       []string{ag.PythonPath, filename},
+		Dir:        dir,
+		Env:        []string{"LANG=en_US.UTF-8", resultsFDEnv + "=" + strconv.Itoa(resultsFD)},
+		ExtraFiles: []*os.File{resultsFile},
+		Hidden:     ag.hiddenDirs(),
+		Limits:     limits,
+	})
+	var out []byte
+	if err != nil {
+		glog.Errorf("error running inline test %s: %s", filename, err)
+		out = []byte(runDetails(nil, err))
+	} else {
+		glog.V(3).Infof("inline test %s: %s", filename, runDetails(result, nil))
+		out = result.Output
 	}
+	status := classifyRun(result, err)
 	var reportBuf bytes.Buffer
-	for _, m := range mm {
-		status := string(m[1])
-		message := string(m[2])
-		if status != "OK" {
-			outcome["passed"] = false
-		}
-		if status == "ERROR" {
-			message = "Internal test error: " + message
-		}
-		if message != "" {
-			if old, ok := outcome["error"]; ok {
-				outcome["error"] = old.(string) + "; " + message
-			} else {
-				outcome["error"] = message
+	var results []*TestResult
+	failed, errored := false, false
+	if status == "" || status == StatusPassed {
+		// The test was not killed on a limit, so the output cannot be trusted
+		// if the runner did not write the results.
+		results, err = readResults(resultsFile)
+		if err != nil {
+			glog.V(3).Infof("inline test %s: %s", filename, err)
+		} else {
+			outcome["tests"] = results
+		}
+		for _, r := range results {
+			message := r.Message
+			switch r.Status {
+			case "failed":
+				failed = true
+			case "error":
+				errored = true
+				message = "Internal test error: " + message
+			}
+			if message != "" {
+				if old, ok := outcome["error"]; ok {
+					outcome["error"] = old.(string) + "; " + message
+				} else {
+					outcome["error"] = message
+				}
+			}
+			err := inlineReportTmpl.Execute(&reportBuf, &inlineReportFill{
+				Passed: r.Status == "passed",
+				Error:  message,
+			})
+			if err != nil {
+				return nil, "", "", err
 			}
 		}
+	}
+	switch {
+	case len(results) == 0 && (status == "" || status == StatusPassed):
+		// Cannot find any individual test case outcomes.
+		status = StatusError
+	case status == "" && memoryError(results):
+		status = StatusMemoryExceeded
+	case errored:
+		status = StatusError
+	case failed:
+		status = StatusFailed
+	case status == "":
+		// The test exited with non-zero status without reporting a failure.
+		status = StatusError
+	}
+	setStatus(outcome, status)
+	if status != StatusPassed && status != StatusFailed && reportBuf.Len() == 0 {
 		err := inlineReportTmpl.Execute(&reportBuf, &inlineReportFill{
-			Passed: status == "OK",
-			Error:  message,
+			Error: status.Message(),
 		})
 		if err != nil {
 			return nil, "", "", err
@@ -565,21 +1044,17 @@ func (ag *Autograder) RunInlineTest(dir, filename string) (map[string]interface{
 }
 
 // RunInlineTests runs all inline tests in a scratch directory found by a glob
-// *_inlinetest.py.
+// *_inlinetest.py, each with the given resource limits.
 // Returns
 // - outcomes map[string]interface{}
 // - logs map[string]string
 // - reports map[string]string
-func (ag *Autograder) RunInlineTests(dir string) (map[string]interface{}, map[string]string, map[string]string, error) {
+func (ag *Autograder) RunInlineTests(dir string, limits Limits) (map[string]interface{}, map[string]string, map[string]string, error) {
 	glog.V(3).Infof("RunInlineTests(%s)", dir)
 	dir, err := filepath.Abs(dir)
 	if err != nil {
 		return nil, nil, nil, fmt.Errorf("error getting abs path for %q: %s", dir, err)
 	}
-	err = os.Chdir(dir)
-	if err != nil {
-		return nil, nil, nil, fmt.Errorf("error on chdir %q: %s", dir, err)
-	}
 	fss, err := ioutil.ReadDir(dir)
 	if err != nil {
 		return nil, nil, nil, fmt.Errorf("error on listing %q: %s", dir, err)
@@ -594,7 +1069,7 @@ func (ag *Autograder) RunInlineTests(dir string) (map[string]interface{}, map[st
 		}
 		// Extract the test name by stripping _inlinetest.py.
 		testname := filename[:len(filename)-len("_inlinetest.py")]
-		testOutcome, testLog, testReport, err := ag.RunInlineTest(dir, filename)
+		testOutcome, testLog, testReport, err := ag.RunInlineTest(dir, filename, limits)
 		if err != nil {
 			return nil, nil, nil, err
 		}
@@ -608,11 +1083,14 @@ func (ag *Autograder) RunInlineTests(dir string) (map[string]interface{}, map[st
 }
 
 // RenderReports looks for report templates in the specified scratch dir and renders all reports.
+// The Go templates (*_template.tmpl) are rendered in-process, and the Python reporter
+// scripts (*_template.py) are run in the sandbox with the given limits, as they import
+// the modules from the scratch dir, where the submitted code could have written.
 // It returns the concatenation of all reports output.
-func (ag *Autograder) RenderReports(dir string, data map[string]interface{}) ([]byte, error) {
-	err := os.Chdir(dir)
+func (ag *Autograder) RenderReports(dir string, data map[string]interface{}, limits Limits) ([]byte, error) {
+	dir, err := filepath.Abs(dir)
 	if err != nil {
-		return nil, fmt.Errorf("error on chdir %q: %s", dir, err)
+		return nil, fmt.Errorf("error getting abs path for %q: %s", dir, err)
 	}
 	fss, err := ioutil.ReadDir(dir)
 	if err != nil {
@@ -625,30 +1103,44 @@ func (ag *Autograder) RenderReports(dir string, data map[string]interface{}) ([]
 	var reports [][]byte
 	for _, fs := range fss {
 		filename := fs.Name()
-		if !strings.HasSuffix(filename, "_template.py") {
+		if strings.HasSuffix(filename, GoTemplateSuffix) {
+			output, err := renderGoTemplate(dir, filename, data)
+			if err != nil {
+				glog.Errorf("Reporter error: %s", err)
+				reports = append(reports, []byte(fmt.Sprintf(`
+<h2 style='color: red'>Reporter error</h2>
+<pre>%s</pre>`, html.EscapeString(err.Error()))))
+				continue
+			}
+			reports = append(reports, output)
 			continue
 		}
-		cmd := exec.Command("python", filename)
-		glog.V(3).Infof("Starting command %s %q with input %q", cmd.Path, cmd.Args, string(dataJson))
-		cmdIn, err := cmd.StdinPipe()
-		if err != nil {
-			return nil, err
+		if !strings.HasSuffix(filename, "_template.py") {
+			continue
 		}
-		go func() {
-			cmdIn.Write(dataJson)
-			cmdIn.Close()
-		}()
-		output, err := cmd.CombinedOutput()
-		if err != nil {
+		glog.V(3).Infof("Running reporter %s with input %q", filename, string(dataJson))
+		// The reporter imports submission_source from the scratch dir.
+		result, err := ag.sandbox().Run(&Command{
+			Args:   []string{ag.PythonPath, filename},
+			Dir:    dir,
+			Env:    []string{"LANG=en_US.UTF-8"},
+			Stdin:  dataJson,
+			Hidden: ag.hiddenDirs(),
+			Limits: limits,
+		})
+		if status := classifyRun(result, err); status != StatusPassed {
+			details := runDetails(result, err)
 			reports = append(reports, []byte(fmt.Sprintf(`
 <h2 style='color: red'>Reporter error</h2>
-<pre>%s</pre>`, err.Error())))
-			glog.Errorf("Reporter error: %s", err)
-			reports = append(reports, output)
+<pre>%s</pre>`, html.EscapeString(details))))
+			glog.Errorf("Reporter error: %s", details)
+			if result != nil {
+				reports = append(reports, result.Output)
+			}
 			continue
 		}
-		glog.V(3).Infof("Output: %s", string(output))
-		reports = append(reports, output)
+		glog.V(3).Infof("Output: %s", string(result.Output))
+		reports = append(reports, result.Output)
 	}
 	return bytes.Join(reports, nil), nil
 }
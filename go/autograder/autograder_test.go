@@ -0,0 +1,202 @@
+package autograder
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/prog-edu-assistant/envelope"
+	"github.com/google/prog-edu-assistant/notebook"
+)
+
+// testSubmission returns the submission of the notebook with one solution
+// cell for the given exercise.
+func testSubmission(t *testing.T, assignmentID, exerciseID, source string) *envelope.Submission {
+	t.Helper()
+	b, err := json.Marshal(map[string]interface{}{
+		"nbformat":       4,
+		"nbformat_minor": 2,
+		"metadata":       map[string]interface{}{"assignment_id": assignmentID},
+		"cells": []interface{}{
+			map[string]interface{}{
+				"cell_type":       "code",
+				"metadata":        map[string]interface{}{"exercise_id": exerciseID},
+				"source":          source,
+				"outputs":         []interface{}{},
+				"execution_count": nil,
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := envelope.FromNotebook(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.SubmissionID = "s1"
+	s.TraceID = "t1"
+	s.Attempt = 2
+	return s
+}
+
+func TestGradeSubmission(t *testing.T) {
+	python, err := exec.LookPath("python3")
+	if err != nil {
+		t.Skip("python3 is not available")
+	}
+	exerciseDir := setupFixture(t, "hello")
+	source, err := ioutil.ReadFile(filepath.Join(exerciseDir, "submission.py"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The autograder directory has the assignment with the exercise.
+	dir := t.TempDir()
+	err = os.Mkdir(filepath.Join(dir, "assignment"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Rename(exerciseDir, filepath.Join(dir, "assignment", "exercise"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ag := New(dir)
+	ag.PythonPath = python
+	ag.Sandbox = &Local{}
+	ag.ScratchDir = t.TempDir()
+
+	r := ag.GradeSubmission(testSubmission(t, "assignment", "exercise", string(source)))
+	if r.Error != "" {
+		t.Fatalf("GradeSubmission returned error %q, want success", r.Error)
+	}
+	if err := r.Validate(); err != nil {
+		t.Errorf("GradeSubmission returned invalid report: %s", err)
+	}
+	if r.SubmissionID != "s1" || r.TraceID != "t1" || r.Attempt != 2 || r.AssignmentID != "assignment" {
+		t.Errorf("GradeSubmission returned report %+v, want the fields of the submission", r.Envelope)
+	}
+	b, err := r.Content()
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := make(map[string]interface{})
+	err = json.Unmarshal(b, &result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := result["exercise"]; !ok || result["submission_id"] != "s1" {
+		t.Errorf("GradeSubmission returned report content %s, want the exercise outcome", string(b))
+	}
+	if _, ok := result["warnings"]; ok {
+		t.Errorf("GradeSubmission returned warnings %v for a valid notebook", result["warnings"])
+	}
+
+	// The notebook that does not match the schema is graded with warnings.
+	s := testSubmission(t, "assignment", "exercise", string(source))
+	b, err = s.Content()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b = []byte(strings.Replace(string(b), `"cell_type":"code"`, `"cell_type":"code","collapsed":false`, 1))
+	err = s.SetContent(b, envelope.EncodingJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r = ag.GradeSubmission(s)
+	if r.Error != "" {
+		t.Fatalf("GradeSubmission of a notebook with schema problems returned error %q, want success", r.Error)
+	}
+	b, err = r.Content()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), `unexpected property \"collapsed\"`) {
+		t.Errorf("GradeSubmission returned report content %s, want the schema warning", string(b))
+	}
+
+	// The errors are reported in the report.
+	r = ag.GradeSubmission(testSubmission(t, "missing", "exercise", string(source)))
+	if !strings.Contains(r.Error, "missing") || len(r.Payload) != 0 {
+		t.Errorf("GradeSubmission of a missing assignment returned error %q, payload %q", r.Error, string(r.Payload))
+	}
+	if err := r.Validate(); err != nil {
+		t.Errorf("GradeSubmission returned invalid error report: %s", err)
+	}
+}
+
+func TestCheckMasterSolutions(t *testing.T) {
+	python, err := exec.LookPath("python3")
+	if err != nil {
+		t.Skip("python3 is not available")
+	}
+	tests := []struct {
+		fixture string
+		want    []string
+	}{
+		{"hello", nil},
+		{"fail", []string{"assignment/exercise: master solution does not pass FixtureTest"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			exerciseDir := setupFixture(t, tt.fixture)
+			// The master solution is in the master directory, not next
+			// to the autograder scripts.
+			dir := t.TempDir()
+			masterDir := t.TempDir()
+			for _, d := range []string{dir, masterDir} {
+				err := os.MkdirAll(filepath.Join(d, "assignment", "exercise"), 0755)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			err := os.Rename(filepath.Join(exerciseDir, "submission.py"),
+				filepath.Join(masterDir, "assignment", "exercise", notebook.MasterSolutionFilename))
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = os.Remove(filepath.Join(dir, "assignment", "exercise"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = os.Rename(exerciseDir, filepath.Join(dir, "assignment", "exercise"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			// The recorded outputs are read from the master directory too.
+			err = ioutil.WriteFile(filepath.Join(masterDir, "assignment", "exercise", notebook.MasterOutputsFilename),
+				[]byte(`{"stdout": "master output"}`), 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ag := New(dir)
+			ag.PythonPath = python
+			ag.Sandbox = &Local{}
+			ag.ScratchDir = t.TempDir()
+			ag.DisableCleanup = true
+			if _, err := ag.CheckMasterSolutions(); err == nil {
+				t.Errorf("CheckMasterSolutions without MasterDir returned success, want error")
+			}
+			ag.MasterDir = masterDir
+			problems, err := ag.CheckMasterSolutions()
+			if err != nil {
+				t.Fatalf("CheckMasterSolutions returned error %s, want success", err)
+			}
+			if !reflect.DeepEqual(problems, tt.want) {
+				t.Errorf("CheckMasterSolutions = %q, want %q", problems, tt.want)
+			}
+			b, err := ioutil.ReadFile(filepath.Join(ag.ScratchDir, "master", "assignment", "exercise",
+				notebook.SubmissionOutputsFilename))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(string(b), "master output") {
+				t.Errorf("%s = %s, want the master outputs", notebook.SubmissionOutputsFilename, string(b))
+			}
+		})
+	}
+}
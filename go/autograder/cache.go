@@ -0,0 +1,259 @@
+package autograder
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// cacheKeyVersion is included into the cache keys, so that changing the format
+// of the outcomes or the way they are graded invalidates the cached ones.
+const cacheKeyVersion = "v3"
+
+// Cache is a filesystem-backed cache of the exercise outcomes, keyed by
+// the hash of the autograder directory of the exercise, of the submission
+// and of the autograder configuration, so that regrading the same submission
+// returns the stored outcome, and changing the autograder scripts of
+// the exercise, the sandbox, the Python or the default limits invalidates it. The cache
+// can be shared by several workers, as the entries are written atomically.
+type Cache struct {
+	// Dir is the directory with the cached outcomes.
+	Dir string
+	// MaxBytes is the maximum total size of the cached outcomes. When it is
+	// exceeded, the least recently used outcomes are evicted. Values less than
+	// 1 mean no limit.
+	MaxBytes int64
+
+	// mu serializes the eviction.
+	mu sync.Mutex
+}
+
+// NewCache creates the cache in the directory, creating the directory
+// if it does not exist.
+func NewCache(dir string, maxBytes int64) (*Cache, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("error creating cache dir %q: %s", dir, err)
+	}
+	return &Cache{Dir: dir, MaxBytes: maxBytes}, nil
+}
+
+// cacheConfig returns the description of the autograder configuration that
+// affects the outcomes, for the cache key.
+func (ag *Autograder) cacheConfig() string {
+	return fmt.Sprintf("sandbox=%T python=%s unittest=%+v inline=%+v",
+		ag.sandbox(), ag.PythonPath, UnitTestLimits, InlineTestLimits)
+}
+
+// cacheKey returns the hex-encoded hash of the autograder configuration,
+// the contents of the exercise directory, the submitted source and
+// the submission outputs.
+func cacheKey(config, exerciseDir, submission string, outputs map[string]interface{}) (string, error) {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%d\x00%s\x00", cacheKeyVersion, len(config), config)
+	err := filepath.Walk(exerciseDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(exerciseDir, path)
+		if err != nil {
+			return err
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		fmt.Fprintf(h, "%s\x00%d\x00", filepath.ToSlash(rel), info.Size())
+		_, err = io.Copy(h, f)
+		return err
+	})
+	if err != nil {
+		return "", fmt.Errorf("error hashing %q: %s", exerciseDir, err)
+	}
+	// The outputs are serialized with the sorted keys.
+	b, err := json.Marshal(outputs)
+	if err != nil {
+		return "", err
+	}
+	fmt.Fprintf(h, "%d\x00%s\x00%s", len(submission), submission, b)
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// filename returns the name of the cache file for the key.
+func (c *Cache) filename(key string) string {
+	return filepath.Join(c.Dir, key[:2], key+".json")
+}
+
+// Get returns the cached outcome for the key, or nil if there is none.
+func (c *Cache) Get(key string) map[string]interface{} {
+	filename := c.filename(key)
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			glog.Errorf("error reading cached outcome: %s", err)
+		}
+		return nil
+	}
+	var outcome map[string]interface{}
+	err = json.Unmarshal(b, &outcome)
+	if err != nil {
+		glog.Errorf("error parsing cached outcome %q: %s", filename, err)
+		return nil
+	}
+	// The modification time tracks the last use for the eviction.
+	now := time.Now()
+	os.Chtimes(filename, now, now)
+	return outcome
+}
+
+// Put stores the outcome for the key and evicts the least recently used
+// outcomes if the cache exceeds its size.
+func (c *Cache) Put(key string, outcome map[string]interface{}) error {
+	b, err := json.Marshal(outcome)
+	if err != nil {
+		return fmt.Errorf("error serializing outcome: %s", err)
+	}
+	filename := c.filename(key)
+	err = os.MkdirAll(filepath.Dir(filename), 0755)
+	if err != nil {
+		return fmt.Errorf("error creating cache dir: %s", err)
+	}
+	// Write into a temporary file and rename, so that concurrent readers
+	// never see a partially written outcome.
+	f, err := ioutil.TempFile(filepath.Dir(filename), key+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("error creating cache file: %s", err)
+	}
+	_, err = f.Write(b)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), filename)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return fmt.Errorf("error writing cache file %q: %s", filename, err)
+	}
+	return c.evict()
+}
+
+// evict removes the least recently used outcomes until the total size
+// is within MaxBytes.
+func (c *Cache) evict() error {
+	if c.MaxBytes < 1 {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	type entry struct {
+		path    string
+		size    int64
+		modTime time.Time
+	}
+	var entries []entry
+	var total int64
+	err := filepath.Walk(c.Dir, func(path string, info os.FileInfo, err error) error {
+		if os.IsNotExist(err) {
+			// Removed by a concurrent eviction.
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() || !strings.HasSuffix(path, ".json") {
+			return nil
+		}
+		entries = append(entries, entry{path, info.Size(), info.ModTime()})
+		total += info.Size()
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error listing cache dir %q: %s", c.Dir, err)
+	}
+	if total <= c.MaxBytes {
+		return nil
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].modTime.Before(entries[j].modTime)
+	})
+	for _, e := range entries {
+		if total <= c.MaxBytes {
+			break
+		}
+		err := os.Remove(e.path)
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("error evicting %q: %s", e.path, err)
+		}
+		total -= e.size
+	}
+	return nil
+}
+
+// uncacheableStatuses are the statuses of the test runs that depend on
+// the machine and its load rather than only on the submission, e.g. a test
+// that timed out on a busy worker may pass on regrading.
+var uncacheableStatuses = map[Status]bool{
+	StatusSandboxFailure: true,
+	StatusTimeout:        true,
+	StatusMemoryExceeded: true,
+	StatusCrashed:        true,
+}
+
+// cacheable reports whether the outcome can be cached. The outcomes with
+// any of the uncacheableStatuses are not cached, as they may be transient.
+func cacheable(outcome map[string]interface{}) bool {
+	results, _ := outcome["results"].(map[string]interface{})
+	for _, v := range results {
+		testOutcome, _ := v.(map[string]interface{})
+		status, _ := testOutcome["status"].(string)
+		if uncacheableStatuses[Status(status)] {
+			return false
+		}
+	}
+	return true
+}
+
+// gradeExerciseCached is like GradeExerciseWithOutputs, but returns
+// the cached outcome if the autograder has a cache and the same submission
+// has already been graded with the same autograder scripts.
+func (ag *Autograder) gradeExerciseCached(exerciseDir, scratchDir, submission string, outputs map[string]interface{}) (map[string]interface{}, error) {
+	if ag.Cache == nil {
+		return ag.GradeExerciseWithOutputs(exerciseDir, scratchDir, submission, outputs)
+	}
+	key, err := cacheKey(ag.cacheConfig(), exerciseDir, submission, outputs)
+	if err != nil {
+		return nil, err
+	}
+	if outcome := ag.Cache.Get(key); outcome != nil {
+		glog.V(3).Infof("using cached outcome %s for %s", key, exerciseDir)
+		return outcome, nil
+	}
+	outcome, err := ag.GradeExerciseWithOutputs(exerciseDir, scratchDir, submission, outputs)
+	if err != nil {
+		return nil, err
+	}
+	if cacheable(outcome) {
+		err = ag.Cache.Put(key, outcome)
+		if err != nil {
+			// The outcome is still good, only the caching failed.
+			glog.Errorf("error caching outcome for %s: %s", exerciseDir, err)
+		}
+	}
+	return outcome, nil
+}
@@ -0,0 +1,185 @@
+package autograder
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCacheKey(t *testing.T) {
+	dir := t.TempDir()
+	err := ioutil.WriteFile(filepath.Join(dir, "HelloTest.py"), []byte("test1"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := func(submission string, outputs map[string]interface{}) string {
+		k, err := cacheKey("config", dir, submission, outputs)
+		if err != nil {
+			t.Fatalf("cacheKey returned error %s, want success", err)
+		}
+		return k
+	}
+	k1 := key("print(1)", nil)
+	if k := key("print(1)", nil); k != k1 {
+		t.Errorf("cacheKey is not deterministic: %s != %s", k, k1)
+	}
+	if k := key("print(2)", nil); k == k1 {
+		t.Errorf("cacheKey does not depend on the submission")
+	}
+	if k := key("print(1)", map[string]interface{}{"stdout": "1\n"}); k == k1 {
+		t.Errorf("cacheKey does not depend on the outputs")
+	}
+	if k, _ := cacheKey("other config", dir, "print(1)", nil); k == k1 {
+		t.Errorf("cacheKey does not depend on the configuration")
+	}
+	err = ioutil.WriteFile(filepath.Join(dir, "HelloTest.py"), []byte("test2"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k := key("print(1)", nil); k == k1 {
+		t.Errorf("cacheKey does not depend on the exercise directory")
+	}
+}
+
+func TestCacheConfig(t *testing.T) {
+	ag := New("")
+	config := ag.cacheConfig()
+	ag.PythonPath = "/usr/bin/python3"
+	if ag.cacheConfig() == config {
+		t.Errorf("cacheConfig does not depend on the Python path")
+	}
+	config = ag.cacheConfig()
+	ag.Sandbox = &Bubblewrap{}
+	if ag.cacheConfig() == config {
+		t.Errorf("cacheConfig does not depend on the sandbox")
+	}
+	config = ag.cacheConfig()
+	defer func(limits Limits) { UnitTestLimits = limits }(UnitTestLimits)
+	UnitTestLimits.Time *= 2
+	if ag.cacheConfig() == config {
+		t.Errorf("cacheConfig does not depend on the default limits")
+	}
+}
+
+func TestCacheable(t *testing.T) {
+	tests := []struct {
+		status Status
+		want   bool
+	}{
+		{StatusPassed, true},
+		{StatusFailed, true},
+		{StatusError, true},
+		{StatusSandboxFailure, false},
+		{StatusTimeout, false},
+		{StatusMemoryExceeded, false},
+		{StatusCrashed, false},
+	}
+	for _, tt := range tests {
+		outcome := map[string]interface{}{
+			"results": map[string]interface{}{
+				"HelloTest": map[string]interface{}{"status": "passed"},
+				"OtherTest": map[string]interface{}{"status": string(tt.status)},
+			},
+		}
+		if got := cacheable(outcome); got != tt.want {
+			t.Errorf("cacheable with status %s = %v, want %v", tt.status, got, tt.want)
+		}
+	}
+}
+
+func TestCacheEviction(t *testing.T) {
+	c, err := NewCache(t.TempDir(), 150)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Each outcome takes 43 bytes, so three of them fit.
+	outcome := map[string]interface{}{"report": strings.Repeat("x", 30)}
+	keys := []string{"aa01", "aa02", "bb03"}
+	for i, key := range keys {
+		err := c.Put(key, outcome)
+		if err != nil {
+			t.Fatalf("Put(%s) returned error %s, want success", key, err)
+		}
+		// Make the modification times distinct.
+		mtime := time.Now().Add(time.Duration(i-10) * time.Minute)
+		os.Chtimes(c.filename(key), mtime, mtime)
+	}
+	// Use the oldest entry, so that the second one is the least recently used.
+	if c.Get("aa01") == nil {
+		t.Fatalf("Get(aa01) = nil, want the outcome")
+	}
+	err = c.Put("cc04", outcome)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range map[string]bool{"aa01": true, "aa02": false, "bb03": true, "cc04": true} {
+		if got := c.Get(key) != nil; got != want {
+			t.Errorf("cached %s = %v, want %v", key, got, want)
+		}
+	}
+}
+
+// countingSandbox counts the commands run in the sandbox.
+type countingSandbox struct {
+	Local
+	runs int
+}
+
+func (s *countingSandbox) Run(c *Command) (*RunResult, error) {
+	s.runs++
+	return s.Local.Run(c)
+}
+
+func TestGradeExerciseCached(t *testing.T) {
+	python, err := exec.LookPath("python3")
+	if err != nil {
+		t.Skip("python3 is not available")
+	}
+	exerciseDir := setupFixture(t, "hello")
+	sb := &countingSandbox{}
+	ag := New("")
+	ag.PythonPath = python
+	ag.Sandbox = sb
+	ag.Cache, err = NewCache(t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	submission, err := ioutil.ReadFile(filepath.Join(exerciseDir, "submission.py"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	grade := func() map[string]interface{} {
+		outcome, err := ag.gradeExerciseCached(exerciseDir, t.TempDir(), string(submission), nil)
+		if err != nil {
+			t.Fatalf("gradeExerciseCached returned error %s, want success", err)
+		}
+		return outcome
+	}
+	first := grade()
+	runs := sb.runs
+	if runs == 0 {
+		t.Fatalf("no tests were run")
+	}
+	second := grade()
+	if sb.runs != runs {
+		t.Errorf("the cached submission ran %d tests, want none", sb.runs-runs)
+	}
+	if first["points_earned"] != second["points_earned"] || first["report"] != second["report"] {
+		t.Errorf("cached outcome %v differs from %v", second, first)
+	}
+	// Changing the tests invalidates the cached outcome.
+	f, err := os.OpenFile(filepath.Join(exerciseDir, "FixtureTest.py"), os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("\n# changed\n")
+	f.Close()
+	grade()
+	if sb.runs == runs {
+		t.Errorf("the tests did not run after the exercise changed")
+	}
+}
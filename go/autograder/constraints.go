@@ -0,0 +1,228 @@
+package autograder
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	htmltemplate "html/template"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// exerciseConstraints are the static constraints on the submitted code,
+// see notebook.ExerciseConstraints.
+type exerciseConstraints struct {
+	ForbiddenNames     []string `json:"forbidden_names,omitempty"`
+	ForbiddenImports   []string `json:"forbidden_imports,omitempty"`
+	RequiredConstructs []string `json:"required_constructs,omitempty"`
+	MaxLines           int      `json:"max_lines,omitempty"`
+	RequiredFunctions  []string `json:"required_functions,omitempty"`
+}
+
+// empty reports whether the exercise declares no constraints.
+func (c *exerciseConstraints) empty() bool {
+	return len(c.ForbiddenNames) == 0 && len(c.ForbiddenImports) == 0 &&
+		len(c.RequiredConstructs) == 0 && c.MaxLines == 0 && len(c.RequiredFunctions) == 0
+}
+
+// Violation describes a static constraint that the submission does not satisfy.
+type Violation struct {
+	// Line is the line number of the offending code, or 0 if the violation
+	// is not related to a specific line.
+	Line int `json:"line"`
+	// Message is the student-facing description of the violation.
+	Message string `json:"message"`
+}
+
+func (v *Violation) String() string {
+	if v.Line > 0 {
+		return fmt.Sprintf("line %d: %s", v.Line, v.Message)
+	}
+	return v.Message
+}
+
+// ConstraintsTimeout limits the time of checking the static constraints.
+var ConstraintsTimeout = 10 * time.Second
+
+// constraintsScript checks the static constraints given as JSON in the first
+// argument on the source read from stdin, and prints the list of violations
+// as JSON. It only parses the source and never executes it, so it does not
+// need the sandbox. A source that cannot be parsed has no violations, since
+// the tests report the syntax error.
+const constraintsScript = `
+import ast
+import json
+import sys
+
+constraints = json.loads(sys.argv[1])
+source = sys.stdin.read()
+violations = []
+
+
+def violation(node, message):
+  violations.append({'line': getattr(node, 'lineno', 0), 'message': message})
+
+
+try:
+  tree = ast.parse(source)
+except (SyntaxError, ValueError, RecursionError, MemoryError):
+  json.dump([], sys.stdout)
+  sys.exit(0)
+
+nodes = list(ast.walk(tree))
+
+forbidden_names = set(constraints.get('forbidden_names', []))
+for node in nodes:
+  name = None
+  if isinstance(node, ast.Name):
+    name = node.id
+  elif isinstance(node, ast.Attribute):
+    name = node.attr
+  if name in forbidden_names:
+    violation(node, 'use of %s is not allowed' % name)
+
+forbidden_imports = constraints.get('forbidden_imports', [])
+
+
+def forbidden_module(module):
+  for f in forbidden_imports:
+    if module == f or module.startswith(f + '.'):
+      return True
+  return False
+
+
+for node in nodes:
+  modules = []
+  if isinstance(node, ast.Import):
+    modules = [alias.name for alias in node.names]
+  elif isinstance(node, ast.ImportFrom) and node.module and node.level == 0:
+    modules = [node.module]
+  for module in modules:
+    if forbidden_module(module):
+      violation(node, 'import of %s is not allowed' % module)
+
+
+def calls_itself(f):
+  for node in ast.walk(f):
+    if (isinstance(node, ast.Call) and isinstance(node.func, ast.Name) and
+        node.func.id == f.name):
+      return True
+  return False
+
+
+functions = [n for n in nodes if isinstance(n, (ast.FunctionDef, ast.AsyncFunctionDef))]
+constructs = {
+    'class': ('a class', lambda: any(isinstance(n, ast.ClassDef) for n in nodes)),
+    'comprehension': ('a comprehension', lambda: any(isinstance(
+        n, (ast.ListComp, ast.SetComp, ast.DictComp, ast.GeneratorExp)) for n in nodes)),
+    'def': ('a function definition', lambda: bool(functions)),
+    'for': ('a for loop', lambda: any(isinstance(n, (ast.For, ast.AsyncFor)) for n in nodes)),
+    'if': ('a conditional', lambda: any(isinstance(n, (ast.If, ast.IfExp)) for n in nodes)),
+    'lambda': ('a lambda expression', lambda: any(isinstance(n, ast.Lambda) for n in nodes)),
+    'loop': ('a loop', lambda: any(isinstance(n, (ast.For, ast.AsyncFor, ast.While)) for n in nodes)),
+    'recursion': ('a recursive function', lambda: any(calls_itself(f) for f in functions)),
+    'try': ('a try statement', lambda: any(isinstance(n, ast.Try) for n in nodes)),
+    'while': ('a while loop', lambda: any(isinstance(n, ast.While) for n in nodes)),
+    'with': ('a with statement', lambda: any(isinstance(n, (ast.With, ast.AsyncWith)) for n in nodes)),
+}
+for construct in constraints.get('required_constructs', []):
+  description, present = constructs[construct]
+  if not present():
+    violation(None, 'the solution must use %s' % description)
+
+max_lines = constraints.get('max_lines', 0)
+if max_lines:
+  lines = [l for l in source.split('\n') if l.strip() and not l.strip().startswith('#')]
+  if len(lines) > max_lines:
+    violation(None, 'the solution has %d lines, at most %d are allowed' % (len(lines), max_lines))
+
+
+def params(args):
+  ret = [a.arg for a in getattr(args, 'posonlyargs', []) + args.args]
+  if args.vararg:
+    ret.append('*' + args.vararg.arg)
+  elif args.kwonlyargs:
+    ret.append('*')
+  ret += [a.arg for a in args.kwonlyargs]
+  if args.kwarg:
+    ret.append('**' + args.kwarg.arg)
+  return ret
+
+
+defined = {}
+for node in tree.body:
+  if isinstance(node, (ast.FunctionDef, ast.AsyncFunctionDef)):
+    defined[node.name] = node
+for signature in constraints.get('required_functions', []):
+  want = ast.parse('def %s: pass' % signature).body[0]
+  got = defined.get(want.name)
+  if got is None:
+    violation(None, 'the solution must define the function %s' % signature)
+  elif params(got.args) != params(want.args):
+    violation(got, 'the function %s must have the signature %s' % (want.name, signature))
+
+json.dump(violations, sys.stdout)
+`
+
+// CheckConstraints checks the static constraints on the submitted code. It runs
+// Python to parse the code outside of the sandbox, as the code is not executed.
+func (ag *Autograder) CheckConstraints(submission string, constraints *exerciseConstraints) ([]*Violation, error) {
+	b, err := json.Marshal(constraints)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), ConstraintsTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, ag.PythonPath, "-c", constraintsScript, string(b))
+	cmd.Stdin = strings.NewReader(submission)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("error checking the constraints: %s\n%s", err, stderr.String())
+	}
+	var violations []*Violation
+	err = json.Unmarshal(out, &violations)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing the constraint violations %q: %s", string(out), err)
+	}
+	return violations, nil
+}
+
+var constraintsReportTmpl = htmltemplate.Must(htmltemplate.New("constraintsreport").Parse(
+	`{{.Message}}
+<ul>
+{{range .Violations}}<li>{{.}}</li>
+{{end}}</ul>
+`))
+
+// constraintsOutcome returns the exercise outcome for the submission that
+// violates the static constraints. No tests are run, so no points are earned.
+func constraintsOutcome(violations []*Violation, possible float64) (map[string]interface{}, error) {
+	testOutcome := make(map[string]interface{})
+	setStatus(testOutcome, StatusConstraintViolation)
+	testOutcome["violations"] = violations
+	var buf strings.Builder
+	err := constraintsReportTmpl.Execute(&buf, map[string]interface{}{
+		"Message":    StatusConstraintViolation.Message(),
+		"Violations": violations,
+	})
+	if err != nil {
+		return nil, err
+	}
+	reports := map[string]string{constraintsTestName: buf.String()}
+	return map[string]interface{}{
+		"results":         map[string]interface{}{constraintsTestName: testOutcome},
+		"logs":            map[string]string{},
+		"reports":         reports,
+		"report":          joinInlineReports(reports),
+		"points_earned":   0.0,
+		"points_possible": possible,
+	}, nil
+}
+
+// constraintsTestName is the name of the pseudo-test that reports the
+// constraint violations in the outcome.
+const constraintsTestName = "constraints"
@@ -0,0 +1,115 @@
+package autograder
+
+import (
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCheckConstraints(t *testing.T) {
+	python, err := exec.LookPath("python3")
+	if err != nil {
+		t.Skip("python3 is not available")
+	}
+	ag := New("")
+	ag.PythonPath = python
+	tests := []struct {
+		name        string
+		constraints exerciseConstraints
+		submission  string
+		want        []string
+	}{
+		{
+			name:        "ForbiddenName",
+			constraints: exerciseConstraints{ForbiddenNames: []string{"eval", "sort"}},
+			submission:  "x = eval('1')\ny = [2, 1]\ny.sort()\nprint(x)\n",
+			want:        []string{"line 1: use of eval is not allowed", "line 3: use of sort is not allowed"},
+		},
+		{
+			name:        "ForbiddenImport",
+			constraints: exerciseConstraints{ForbiddenImports: []string{"numpy"}},
+			submission:  "import os\nimport numpy.linalg\nfrom numpy import array\n",
+			want:        []string{"line 2: import of numpy.linalg is not allowed", "line 3: import of numpy is not allowed"},
+		},
+		{
+			name:        "RequiredConstructs",
+			constraints: exerciseConstraints{RequiredConstructs: []string{"loop", "recursion"}},
+			submission:  "def f(n):\n  return 1 if n == 0 else n * f(n - 1)\n",
+			want:        []string{"the solution must use a loop"},
+		},
+		{
+			name:        "MaxLines",
+			constraints: exerciseConstraints{MaxLines: 2},
+			submission:  "# comment\nx = 1\n\ny = 2\nz = 3\n",
+			want:        []string{"the solution has 3 lines, at most 2 are allowed"},
+		},
+		{
+			name:        "RequiredFunctions",
+			constraints: exerciseConstraints{RequiredFunctions: []string{"hello(name)", "add(a, b=0)", "f(*args)"}},
+			submission:  "def hello(x):\n  pass\ndef add(a, b):\n  pass\n",
+			want: []string{
+				"line 1: the function hello must have the signature hello(name)",
+				"the solution must define the function f(*args)",
+			},
+		},
+		{
+			name:        "Satisfied",
+			constraints: exerciseConstraints{ForbiddenNames: []string{"eval"}, RequiredConstructs: []string{"for"}, MaxLines: 3},
+			submission:  "for i in range(3):\n  print(i)\n",
+		},
+		{
+			name:        "SyntaxError",
+			constraints: exerciseConstraints{RequiredConstructs: []string{"for"}},
+			submission:  "def f(:\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			violations, err := ag.CheckConstraints(tt.submission, &tt.constraints)
+			if err != nil {
+				t.Fatalf("CheckConstraints returned error %s, want success", err)
+			}
+			var got []string
+			for _, v := range violations {
+				got = append(got, v.String())
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("CheckConstraints returned %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGradeExerciseConstraints(t *testing.T) {
+	python, err := exec.LookPath("python3")
+	if err != nil {
+		t.Skip("python3 is not available")
+	}
+	exerciseDir := setupFixture(t, "hello")
+	err = ioutil.WriteFile(filepath.Join(exerciseDir, "exercise.json"), []byte(`{"forbidden_names": ["print"]}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ag := New("")
+	ag.PythonPath = python
+	// The sandbox must not be used.
+	ag.Sandbox = &NSJail{Path: "/nonexistent/nsjail"}
+	outcome, err := ag.GradeExercise(exerciseDir, t.TempDir(), "print('hi')\n")
+	if err != nil {
+		t.Fatalf("GradeExercise returned error %s, want success", err)
+	}
+	results, _ := outcome["results"].(map[string]interface{})
+	constraints, _ := results["constraints"].(map[string]interface{})
+	if got := constraints["status"]; got != string(StatusConstraintViolation) {
+		t.Errorf("status = %v, want %q", got, StatusConstraintViolation)
+	}
+	if report, _ := outcome["report"].(string); !strings.Contains(report, "line 1: use of print is not allowed") {
+		t.Errorf("report = %q, want the violation", report)
+	}
+	if outcome["points_earned"] != 0.0 {
+		t.Errorf("points_earned = %v, want 0", outcome["points_earned"])
+	}
+}
@@ -0,0 +1,105 @@
+package autograder
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/google/prog-edu-assistant/notebook"
+)
+
+// hiddenTestMessage is the student-facing message shown for a failed hidden test
+// instead of its report.
+const hiddenTestMessage = "A hidden test failed."
+
+// loadHiddenTests returns the set of names of the hidden unit tests (*Test.py)
+// and inline tests (*_inline.py) in the exercise directory. The test files of
+// the hidden tests start with notebook.HiddenTestMarker.
+func loadHiddenTests(exerciseDir string) (map[string]bool, error) {
+	fss, err := ioutil.ReadDir(exerciseDir)
+	if err != nil {
+		return nil, fmt.Errorf("error on listing %q: %s", exerciseDir, err)
+	}
+	hidden := make(map[string]bool)
+	for _, fs := range fss {
+		filename := fs.Name()
+		var name string
+		switch {
+		case strings.HasSuffix(filename, "Test.py"):
+			name = strings.TrimSuffix(filename, ".py")
+		case strings.HasSuffix(filename, "_inline.py"):
+			name = strings.TrimSuffix(filename, "_inline.py")
+		default:
+			continue
+		}
+		b, err := ioutil.ReadFile(filepath.Join(exerciseDir, filename))
+		if err != nil {
+			return nil, fmt.Errorf("error reading %q: %s", filename, err)
+		}
+		if strings.HasPrefix(string(b), notebook.HiddenTestMarker+"\n") {
+			hidden[name] = true
+		}
+	}
+	return hidden, nil
+}
+
+// studentData returns the copy of the report data without the results, logs
+// and reports of the hidden tests, for rendering the student-facing report.
+// The hidden tests are instead listed in the reports under generic names,
+// with a generic message if they did not pass.
+func studentData(data map[string]interface{}, hidden map[string]bool) map[string]interface{} {
+	if len(hidden) == 0 {
+		return data
+	}
+	results, _ := data["results"].(map[string]interface{})
+	logs, _ := data["logs"].(map[string]string)
+	reports, _ := data["reports"].(map[string]string)
+	studentResults := make(map[string]interface{})
+	for name, v := range results {
+		if !hidden[name] {
+			studentResults[name] = v
+		}
+	}
+	studentLogs := make(map[string]string)
+	for name, log := range logs {
+		// The logs of the unit tests are keyed by the file name.
+		if !hidden[name] && !hidden[strings.TrimSuffix(name, ".py")] {
+			studentLogs[name] = log
+		}
+	}
+	studentReports := make(map[string]string)
+	for name, report := range reports {
+		if !hidden[name] {
+			studentReports[name] = report
+		}
+	}
+	// Number the hidden tests in a stable order that does not depend
+	// on the outcomes.
+	var names []string
+	for name := range hidden {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for i, name := range names {
+		outcome, _ := results[name].(map[string]interface{})
+		var buf strings.Builder
+		err := inlineReportTmpl.Execute(&buf, &inlineReportFill{
+			Passed: outcome["passed"] == true,
+			Error:  hiddenTestMessage,
+		})
+		if err != nil {
+			continue
+		}
+		studentReports[fmt.Sprintf("Hidden test %d", i+1)] = buf.String()
+	}
+	student := make(map[string]interface{})
+	for k, v := range data {
+		student[k] = v
+	}
+	student["results"] = studentResults
+	student["logs"] = studentLogs
+	student["reports"] = studentReports
+	return student
+}
@@ -0,0 +1,121 @@
+package autograder
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/google/prog-edu-assistant/notebook"
+)
+
+func TestStudentData(t *testing.T) {
+	data := map[string]interface{}{
+		"results": map[string]interface{}{
+			"HelloTest":  map[string]interface{}{"passed": true},
+			"SecretTest": map[string]interface{}{"passed": false},
+			"Secret2":    map[string]interface{}{"passed": true},
+		},
+		"logs": map[string]string{
+			"HelloTest.py":  "hello log",
+			"SecretTest.py": "secret log",
+			"Secret2":       "secret2 log",
+		},
+		"reports": map[string]string{
+			"Secret2": "secret2 report",
+		},
+		"points_earned": 1.0,
+	}
+	hidden := map[string]bool{"SecretTest": true, "Secret2": true}
+	got := studentData(data, hidden)
+	if want := []string{"HelloTest"}; !reflect.DeepEqual(mapKeys(got["results"]), want) {
+		t.Errorf("results = %v, want %v", got["results"], want)
+	}
+	if want := []string{"HelloTest.py"}; !reflect.DeepEqual(mapKeys(got["logs"]), want) {
+		t.Errorf("logs = %v, want %v", got["logs"], want)
+	}
+	reports := got["reports"].(map[string]string)
+	if want := []string{"Hidden test 1", "Hidden test 2"}; !reflect.DeepEqual(mapKeys(reports), want) {
+		t.Errorf("reports = %v, want %v", reports, want)
+	}
+	// Secret2 sorts before SecretTest.
+	if strings.Contains(reports["Hidden test 1"], hiddenTestMessage) {
+		t.Errorf("report of the passed hidden test = %q, want no %q", reports["Hidden test 1"], hiddenTestMessage)
+	}
+	if !strings.Contains(reports["Hidden test 2"], hiddenTestMessage) {
+		t.Errorf("report of the failed hidden test = %q, want %q", reports["Hidden test 2"], hiddenTestMessage)
+	}
+	if got["points_earned"] != 1.0 {
+		t.Errorf("points_earned = %v, want 1", got["points_earned"])
+	}
+	// The original data must be intact.
+	if len(data["results"].(map[string]interface{})) != 3 || len(data["logs"].(map[string]string)) != 3 {
+		t.Errorf("studentData modified the original data: %v", data)
+	}
+}
+
+// mapKeys returns the sorted keys of the map.
+func mapKeys(v interface{}) []string {
+	var keys []string
+	for _, k := range reflect.ValueOf(v).MapKeys() {
+		keys = append(keys, k.String())
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func TestGradeExerciseHidden(t *testing.T) {
+	python, err := exec.LookPath("python3")
+	if err != nil {
+		t.Skip("python3 is not available")
+	}
+	exerciseDir := setupFixture(t, "fail")
+	filename := filepath.Join(exerciseDir, "FixtureTest.py")
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(filename, []byte(notebook.HiddenTestMarker+"\n"+string(b)), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	submission, err := ioutil.ReadFile(filepath.Join(exerciseDir, "submission.py"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Remove(filepath.Join(exerciseDir, "submission.py"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ag := New("")
+	ag.PythonPath = python
+	ag.Sandbox = &Local{}
+	outcome, err := ag.GradeExercise(exerciseDir, t.TempDir(), string(submission))
+	if err != nil {
+		t.Fatalf("GradeExercise returned error %s, want success", err)
+	}
+	report, _ := outcome["report"].(string)
+	for _, s := range []string{"FixtureTest", "test_0_1", "AssertionError"} {
+		if strings.Contains(report, s) {
+			t.Errorf("report = %q, want no %q", report, s)
+		}
+	}
+	if !strings.Contains(report, hiddenTestMessage) {
+		t.Errorf("report = %q, want %q", report, hiddenTestMessage)
+	}
+	// The stored outcome keeps the details for the instructors.
+	results, _ := outcome["results"].(map[string]interface{})
+	if _, ok := results["FixtureTest"]; !ok {
+		t.Errorf("results = %v, want FixtureTest", results)
+	}
+	if got, want := outcome["hidden_tests"], []string{"FixtureTest"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("hidden_tests = %v, want %v", got, want)
+	}
+	if outcome["points_earned"] != 2.0 || outcome["points_possible"] != 4.0 {
+		t.Errorf("points = %v / %v, want 2 / 4", outcome["points_earned"], outcome["points_possible"])
+	}
+}
@@ -0,0 +1,298 @@
+package autograder
+
+import (
+	"fmt"
+	htmltemplate "html/template"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/golang/glog"
+)
+
+// compareOptions control how the output of an input/output test is compared
+// with the expected output, see notebook.IOTestIgnoreCaseKey etc.
+// The trailing whitespace of the lines and the trailing empty lines
+// are always ignored.
+type compareOptions struct {
+	IgnoreCase       bool    `json:"iotest_ignore_case"`
+	IgnoreWhitespace bool    `json:"iotest_ignore_whitespace"`
+	FloatTolerance   float64 `json:"iotest_float_tolerance"`
+}
+
+// lines splits the output into lines without the trailing whitespace,
+// dropping the trailing empty lines.
+func (o *compareOptions) lines(text string) []string {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " \t\r")
+	}
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// equalToken compares two words, which are equal either literally
+// or as numbers within the float tolerance.
+func (o *compareOptions) equalToken(got, want string) bool {
+	if got == want {
+		return true
+	}
+	if o.FloatTolerance <= 0 {
+		return false
+	}
+	x, err1 := strconv.ParseFloat(got, 64)
+	y, err2 := strconv.ParseFloat(want, 64)
+	return err1 == nil && err2 == nil && math.Abs(x-y) <= o.FloatTolerance
+}
+
+// equalLine compares two lines. The whitespace between the words is only
+// significant if neither whitespace nor float tolerance is ignored.
+func (o *compareOptions) equalLine(got, want string) bool {
+	if o.IgnoreCase {
+		got, want = strings.ToLower(got), strings.ToLower(want)
+	}
+	if !o.IgnoreWhitespace && o.FloatTolerance <= 0 {
+		return got == want
+	}
+	gotWords, wantWords := strings.Fields(got), strings.Fields(want)
+	if len(gotWords) != len(wantWords) {
+		return false
+	}
+	for i := range gotWords {
+		if !o.equalToken(gotWords[i], wantWords[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// match reports whether the output matches the expected output.
+func (o *compareOptions) match(got, want string) bool {
+	gotLines, wantLines := o.lines(got), o.lines(want)
+	if o.IgnoreWhitespace {
+		// The line breaks are whitespace too.
+		return o.equalLine(strings.Join(gotLines, " "), strings.Join(wantLines, " "))
+	}
+	if len(gotLines) != len(wantLines) {
+		return false
+	}
+	for i := range gotLines {
+		if !o.equalLine(gotLines[i], wantLines[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// maxDiffCells limits the size of the table for computing the diff.
+const maxDiffCells = 1 << 20
+
+// diff returns the line diff between the expected and the actual output.
+// The expected lines are prefixed with "-", the actual lines with "+".
+func (o *compareOptions) diff(got, want string) string {
+	a, b := o.lines(want), o.lines(got)
+	if len(a)*len(b) > maxDiffCells {
+		// Too large for the diff, only show the first difference.
+		i := 0
+		for i < len(a) && i < len(b) && o.equalLine(b[i], a[i]) {
+			i++
+		}
+		var parts []string
+		if i < len(a) {
+			parts = append(parts, "- "+a[i])
+		}
+		if i < len(b) {
+			parts = append(parts, "+ "+b[i])
+		}
+		return fmt.Sprintf("first difference at line %d:\n%s\n", i+1, strings.Join(parts, "\n"))
+	}
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:].
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if o.equalLine(b[j], a[i]) {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	var sb strings.Builder
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && o.equalLine(b[j], a[i]):
+			sb.WriteString("  " + b[j] + "\n")
+			i++
+			j++
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			sb.WriteString("- " + a[i] + "\n")
+			i++
+		default:
+			sb.WriteString("+ " + b[j] + "\n")
+			j++
+		}
+	}
+	return sb.String()
+}
+
+// ioTestMismatchMessage is the student-facing message for the input/output test
+// that ran successfully, but printed a wrong output.
+const ioTestMismatchMessage = "The output of your program does not match the expected output."
+
+// maxReportOutput limits the size of the program output shown in the report.
+const maxReportOutput = 4096
+
+type ioReportFill struct {
+	Passed bool
+	Error  string
+	Input  string
+	Diff   string
+	Output string
+}
+
+var ioReportTmpl = htmltemplate.Must(htmltemplate.New("ioreport").Parse(
+	`{{if .Passed}}
+Looks OK.
+{{else}}
+{{.Error}}
+<p>Input:</p>
+<pre>{{.Input}}</pre>
+{{if .Diff}}<p>Difference from the expected output (- expected, + yours):</p>
+<pre>{{.Diff}}</pre>
+{{end}}{{if .Output}}<p>Output:</p>
+<pre>{{.Output}}</pre>
+{{end}}{{end}}
+`))
+
+// RunIOTests runs the submission in the scratch directory with the test runner
+// on the input of each input/output test case NAME.in in casesDir with the given resource limits,
+// and compares the standard output with NAME.out. The name of the test is
+// the base name of the case. Returns the outcomes, the logs and the reports
+// keyed by the test name, like RunInlineTests. The outcome has the field
+// "diff" with the line diff if the output did not match.
+func (ag *Autograder) RunIOTests(casesDir, dir string, limits Limits, opts compareOptions) (map[string]interface{}, map[string]string, map[string]string, error) {
+	outcomes := make(map[string]interface{})
+	logs := make(map[string]string)
+	reports := make(map[string]string)
+	inputs, err := filepath.Glob(filepath.Join(casesDir, "*.in"))
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("error listing %q: %s", casesDir, err)
+	}
+	if len(inputs) == 0 {
+		return outcomes, logs, reports, nil
+	}
+	dir, err = filepath.Abs(dir)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("error getting abs path for %q: %s", dir, err)
+	}
+	sort.Strings(inputs)
+	for _, inputFilename := range inputs {
+		testname := strings.TrimSuffix(filepath.Base(inputFilename), ".in")
+		input, err := ioutil.ReadFile(inputFilename)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("error reading %q: %s", inputFilename, err)
+		}
+		outputFilename := strings.TrimSuffix(inputFilename, ".in") + ".out"
+		want, err := ioutil.ReadFile(outputFilename)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("error reading %q: %s", outputFilename, err)
+		}
+		testOutcome := make(map[string]interface{})
+		outcomes[testname] = testOutcome
+		resultsFile, err := createResults()
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		// The runner only reports whether the submission ran out of memory.
+		result, err := ag.sandbox().Run(&Command{
+			Args:       []string{ag.PythonPath, RunnerFilename, "--script", "submission.py"},
+			Dir:        dir,
+			Env:        []string{"LANG=en_US.UTF-8", resultsFDEnv + "=" + strconv.Itoa(resultsFD)},
+			Stdin:      input,
+			ExtraFiles: []*os.File{resultsFile},
+			Hidden:     ag.hiddenDirs(),
+			Limits:     limits,
+		})
+		results, _ := readResults(resultsFile)
+		resultsFile.Close()
+		fill := &ioReportFill{Input: string(input)}
+		if err != nil {
+			glog.Errorf("error running io test %s: %s", testname, err)
+			setStatus(testOutcome, StatusSandboxFailure)
+			logs[testname] = runDetails(nil, err)
+		} else {
+			glog.V(3).Infof("io test %s: %s", testname, runDetails(result, nil))
+			logs[testname] = string(result.Output)
+			status := classifyRun(result, nil)
+			if status == "" && memoryError(results) {
+				status = StatusMemoryExceeded
+			}
+			if status == "" {
+				// The program raised an exception.
+				status = StatusError
+			}
+			var diff string
+			if status == StatusPassed && !opts.match(string(result.Stdout), string(want)) {
+				status = StatusFailed
+				diff = opts.diff(string(result.Stdout), string(want))
+			}
+			setStatus(testOutcome, status)
+			if status == StatusFailed {
+				testOutcome["message"] = ioTestMismatchMessage
+				testOutcome["diff"] = diff
+				fill.Diff = diff
+			} else if status != StatusPassed {
+				fill.Output = truncateOutput(string(result.Output), maxReportOutput)
+			}
+		}
+		fill.Passed = testOutcome["passed"] == true
+		fill.Error, _ = testOutcome["message"].(string)
+		var buf strings.Builder
+		err = ioReportTmpl.Execute(&buf, fill)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		reports[testname] = buf.String()
+	}
+	return outcomes, logs, reports, nil
+}
+
+// truncateOutput keeps the last n bytes of the output, where the error
+// message of Python is.
+func truncateOutput(output string, n int) string {
+	if len(output) <= n {
+		return output
+	}
+	return "...\n" + output[len(output)-n:]
+}
+
+// ioTestNames returns the names of the input/output tests in the cases directory.
+func ioTestNames(casesDir string) ([]string, error) {
+	fss, err := ioutil.ReadDir(casesDir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error on listing %q: %s", casesDir, err)
+	}
+	var names []string
+	for _, fs := range fss {
+		if strings.HasSuffix(fs.Name(), ".in") {
+			names = append(names, strings.TrimSuffix(fs.Name(), ".in"))
+		}
+	}
+	return names, nil
+}
@@ -0,0 +1,146 @@
+package autograder
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCompareOutput(t *testing.T) {
+	tests := []struct {
+		name string
+		opts compareOptions
+		got  string
+		want string
+		ok   bool
+	}{
+		{"Exact", compareOptions{}, "3\n", "3\n", true},
+		{"TrailingWhitespace", compareOptions{}, "3  \r\n\n\n", "3", true},
+		{"Different", compareOptions{}, "4\n", "3\n", false},
+		{"InnerWhitespace", compareOptions{}, "1  2\n", "1 2\n", false},
+		{"MissingLine", compareOptions{}, "1\n", "1\n2\n", false},
+		{"Case", compareOptions{}, "Yes\n", "YES\n", false},
+		{"IgnoreCase", compareOptions{IgnoreCase: true}, "Yes\n", "YES\n", true},
+		{"IgnoreWhitespace", compareOptions{IgnoreWhitespace: true}, "1\n2  3\n", "1 2\n3\n", true},
+		{"IgnoreWhitespaceDifferent", compareOptions{IgnoreWhitespace: true}, "1 2\n", "1 2 3\n", false},
+		{"FloatExact", compareOptions{}, "0.3333\n", "0.333333\n", false},
+		{"FloatTolerance", compareOptions{FloatTolerance: 1e-3}, "x = 0.3333\n", "x = 0.333333\n", true},
+		{"FloatToleranceExceeded", compareOptions{FloatTolerance: 1e-6}, "0.3333\n", "0.333333\n", false},
+		{"FloatToleranceWords", compareOptions{FloatTolerance: 1e-3}, "a\n", "b\n", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.opts.match(tt.got, tt.want); got != tt.ok {
+				t.Errorf("match(%q, %q) = %v, want %v", tt.got, tt.want, got, tt.ok)
+			}
+		})
+	}
+}
+
+func TestCompareDiff(t *testing.T) {
+	opts := &compareOptions{}
+	got := opts.diff("1\n5\n3\n", "1\n2\n3\n")
+	want := "  1\n- 2\n+ 5\n  3\n"
+	if got != want {
+		t.Errorf("diff = %q, want %q", got, want)
+	}
+}
+
+func TestRunIOTests(t *testing.T) {
+	python, err := exec.LookPath("python3")
+	if err != nil {
+		t.Skip("python3 is not available")
+	}
+	casesDir := t.TempDir()
+	for filename, content := range map[string]string{
+		"Sum.in":       "1 2\n",
+		"Sum.out":      "3\n",
+		"Wrong.in":     "2 2\n",
+		"Wrong.out":    "5\n",
+		"Crashing.in":  "a b\n",
+		"Crashing.out": "",
+		"Huge.in":      "1 40\n",
+		"Huge.out":     "",
+	} {
+		err := ioutil.WriteFile(filepath.Join(casesDir, filename), []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	dir := t.TempDir()
+	err = ioutil.WriteFile(filepath.Join(dir, "submission.py"),
+		[]byte("a, b = input().split()\nif b == '40':\n  x = bytearray(1 << 40)\nprint(int(a) + int(b))\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = writeRunner(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ag := New("")
+	ag.PythonPath = python
+	ag.Sandbox = &Local{}
+	outcomes, logs, reports, err := ag.RunIOTests(casesDir, dir, InlineTestLimits, compareOptions{})
+	if err != nil {
+		t.Fatalf("RunIOTests returned error %s, want success", err)
+	}
+	want := map[string]Status{
+		"Sum":      StatusPassed,
+		"Wrong":    StatusFailed,
+		"Crashing": StatusError,
+		"Huge":     StatusMemoryExceeded,
+	}
+	for name, status := range want {
+		outcome, _ := outcomes[name].(map[string]interface{})
+		if got := outcome["status"]; got != string(status) {
+			t.Errorf("%s: status = %v, want %q, log: %s", name, got, status, logs[name])
+		}
+	}
+	if got, want := outcomes["Wrong"].(map[string]interface{})["diff"], "- 5\n+ 4\n"; got != want {
+		t.Errorf("Wrong: diff = %q, want %q", got, want)
+	}
+	if !strings.Contains(reports["Crashing"], "ValueError") {
+		t.Errorf("Crashing: report = %q, want the ValueError", reports["Crashing"])
+	}
+}
+
+func TestGradeExerciseHidesCases(t *testing.T) {
+	python, err := exec.LookPath("python3")
+	if err != nil {
+		t.Skip("python3 is not available")
+	}
+	exerciseDir := t.TempDir()
+	casesDir := filepath.Join(exerciseDir, "cases")
+	err = os.Mkdir(casesDir, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for filename, content := range map[string]string{
+		"Peek.in":  "",
+		"Peek.out": "secret\n",
+	} {
+		err := ioutil.WriteFile(filepath.Join(casesDir, filename), []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	ag := New("")
+	ag.PythonPath = python
+	ag.Sandbox = &Local{}
+	// The submission tries to print the expected output.
+	outcome, err := ag.GradeExercise(exerciseDir, t.TempDir(), "print(open('cases/Peek.out').read())\n")
+	if err != nil {
+		t.Fatalf("GradeExercise returned error %s, want success", err)
+	}
+	results, _ := outcome["results"].(map[string]interface{})
+	peek, _ := results["Peek"].(map[string]interface{})
+	if got := peek["status"]; got != string(StatusError) {
+		t.Errorf("status = %v, want %q", got, StatusError)
+	}
+	if outcome["points_possible"] != 1.0 {
+		t.Errorf("points_possible = %v, want 1", outcome["points_possible"])
+	}
+}
@@ -0,0 +1,127 @@
+package autograder
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Status classifies the outcome of running one test file.
+// It is stored in the "status" field of the test outcome.
+type Status string
+
+const (
+	// StatusPassed means that all test cases have passed.
+	StatusPassed Status = "passed"
+	// StatusFailed means that some test cases failed the assertions.
+	StatusFailed Status = "failed"
+	// StatusError means that the test could not complete because of
+	// an exception, e.g. a syntax error in the submission.
+	StatusError Status = "error"
+	// StatusTimeout means that the test was killed for running too long.
+	StatusTimeout Status = "timeout"
+	// StatusMemoryExceeded means that the test ran out of memory.
+	StatusMemoryExceeded Status = "memory_exceeded"
+	// StatusCrashed means that the test process was killed by a signal.
+	StatusCrashed Status = "crashed"
+	// StatusSandboxFailure means that the sandbox could not run the test.
+	// It is not the fault of the submission.
+	StatusSandboxFailure Status = "sandbox_failure"
+	// StatusConstraintViolation means that the submission does not satisfy
+	// the static constraints of the exercise, so the tests were not run.
+	StatusConstraintViolation Status = "constraint_violation"
+)
+
+// statusMessages are the student-facing explanations of the statuses.
+var statusMessages = map[Status]string{
+	StatusPassed:              "All tests passed.",
+	StatusFailed:              "Some tests failed.",
+	StatusError:               "The tests could not run to completion because your code raised an error. Please check that it runs without errors in the notebook.",
+	StatusTimeout:             "Your code took too long to run and was stopped. Please check it for infinite loops or very slow computations.",
+	StatusMemoryExceeded:      "Your code used too much memory and was stopped. Please check it for unbounded data structures or infinite recursion.",
+	StatusCrashed:             "Your code crashed the Python interpreter.",
+	StatusSandboxFailure:      "The autograder could not run the tests because of an internal problem. This is not a problem with your code, please try submitting again later.",
+	StatusConstraintViolation: "Your code does not satisfy the requirements of the exercise, so it was not tested.",
+}
+
+// Message returns the student-facing message explaining the status.
+func (s Status) Message() string {
+	return statusMessages[s]
+}
+
+// classifyRun returns the status of a test run that is determined by the run
+// itself rather than by the test outcomes: passed if the command exited
+// successfully, or one of timeout, memory exceeded, crashed or sandbox failure.
+// It returns the empty status if the test outcomes should decide between
+// failed and error. The runErr is the error returned by Sandbox.Run.
+func classifyRun(result *RunResult, runErr error) Status {
+	switch {
+	case runErr != nil || result.SandboxError != "":
+		return StatusSandboxFailure
+	case result.LimitExceeded == TimeLimit || result.LimitExceeded == CPULimit:
+		return StatusTimeout
+	case result.Signal != 0:
+		return StatusCrashed
+	case result.ExitStatus == 0:
+		return StatusPassed
+	}
+	return ""
+}
+
+// setStatus records the status of the test run into the outcome, along with
+// the student-facing message. The passed field is kept for compatibility with
+// the existing report templates.
+func setStatus(outcome map[string]interface{}, status Status) {
+	outcome["status"] = string(status)
+	outcome["passed"] = status == StatusPassed
+	if status != StatusPassed {
+		outcome["message"] = status.Message()
+	}
+}
+
+// runDetails returns the description of the run for the logs.
+func runDetails(result *RunResult, runErr error) string {
+	if runErr != nil {
+		return fmt.Sprintf("sandbox error: %s", runErr)
+	}
+	var parts []string
+	if result.SandboxError != "" {
+		parts = append(parts, "sandbox error: "+result.SandboxError)
+	}
+	parts = append(parts, fmt.Sprintf("exit status %d", result.ExitStatus))
+	if result.Signal != 0 {
+		parts = append(parts, fmt.Sprintf("signal %d (%s)", int(result.Signal), result.Signal))
+	}
+	if result.LimitExceeded != NoLimit {
+		parts = append(parts, fmt.Sprintf("%s limit exceeded", result.LimitExceeded))
+	}
+	parts = append(parts, fmt.Sprintf("wall time %s, cpu time %s, peak memory %d KB",
+		result.WallTime, result.CPUTime, result.PeakMemory>>10))
+	return strings.Join(parts, ", ")
+}
+
+// statusReports returns the default reports for the test outcomes that
+// did not pass or fail normally, e.g. timed out. The reports explain
+// the status to the student.
+func statusReports(outcomes map[string]interface{}) map[string]string {
+	reports := make(map[string]string)
+	for name, v := range outcomes {
+		outcome, ok := v.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		status, _ := outcome["status"].(string)
+		switch Status(status) {
+		case "", StatusPassed, StatusFailed:
+			continue
+		}
+		var buf strings.Builder
+		err := inlineReportTmpl.Execute(&buf, &inlineReportFill{
+			Error: Status(status).Message(),
+		})
+		if err != nil {
+			continue
+		}
+		reports[name] = buf.String()
+	}
+	return reports
+}
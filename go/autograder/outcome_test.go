@@ -0,0 +1,362 @@
+package autograder
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/prog-edu-assistant/notebook"
+)
+
+// fixturesDir contains the example unit tests, one per subdirectory,
+// with the submission in submission.py and the test in *_test.py.
+const fixturesDir = "../../autograder/unittest"
+
+// setupFixture copies the fixture into a scratch directory, renaming the test
+// file to follow the *Test.py convention of RunUnitTests.
+func setupFixture(t *testing.T, name string) string {
+	dir := t.TempDir()
+	tests, err := filepath.Glob(filepath.Join(fixturesDir, name, "*_test.py"))
+	if err != nil || len(tests) != 1 {
+		t.Fatalf("want exactly one test in fixture %s, got %q (err %v)", name, tests, err)
+	}
+	for src, dst := range map[string]string{
+		filepath.Join(fixturesDir, name, "submission.py"): "submission.py",
+		tests[0]: "FixtureTest.py",
+	} {
+		b, err := ioutil.ReadFile(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = ioutil.WriteFile(filepath.Join(dir, dst), b, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = writeRunner(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestRunUnitTestsStatus(t *testing.T) {
+	python, err := exec.LookPath("python3")
+	if err != nil {
+		t.Skip("python3 is not available")
+	}
+	limits := Limits{Time: 2 * time.Second, Memory: 200 << 20}
+	tests := []struct {
+		fixture string
+		sandbox Sandbox
+		want    Status
+	}{
+		{"hello", &Local{}, StatusPassed},
+		{"fail", &Local{}, StatusFailed},
+		{"infloop", &Local{}, StatusTimeout},
+		{"infmem", &Local{}, StatusMemoryExceeded},
+		{"spoof", &Local{}, StatusError},
+		{"hello", &NSJail{Path: "/nonexistent/nsjail"}, StatusSandboxFailure},
+	}
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			dir := setupFixture(t, tt.fixture)
+			ag := New(dir)
+			ag.PythonPath = python
+			ag.Sandbox = tt.sandbox
+			outcomes, logs, err := ag.RunUnitTests(dir, limits)
+			if err != nil {
+				t.Fatalf("RunUnitTests returned error %s, want success", err)
+			}
+			outcome, ok := outcomes["FixtureTest"].(map[string]interface{})
+			if !ok {
+				t.Fatalf("RunUnitTests returned no outcome for FixtureTest: %v", outcomes)
+			}
+			if got := outcome["status"]; got != string(tt.want) {
+				t.Errorf("status = %q, want %q\nlog:\n%s", got, tt.want, logs["FixtureTest.py"])
+			}
+			if tt.want == StatusFailed {
+				results, _ := outcome["tests"].([]*TestResult)
+				tracebacks := 0
+				for _, r := range results {
+					if r.Status == "failed" && r.Traceback != "" {
+						tracebacks++
+					}
+				}
+				if tracebacks == 0 {
+					t.Errorf("tests = %+v, want failed results with tracebacks", results)
+				}
+			}
+			if tt.want != StatusPassed && outcome["message"] != tt.want.Message() {
+				t.Errorf("message = %q, want %q", outcome["message"], tt.want.Message())
+			}
+			if tt.fixture == "spoof" {
+				// The spoofed output does not count.
+				for name, v := range outcome {
+					if v == true {
+						t.Errorf("%s = true, want no test cases passed", name)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestRunUnitTestsNoCases(t *testing.T) {
+	python, err := exec.LookPath("python3")
+	if err != nil {
+		t.Skip("python3 is not available")
+	}
+	dir := t.TempDir()
+	err = ioutil.WriteFile(filepath.Join(dir, "EmptyTest.py"), []byte(`import unittest
+
+class EmptyTest(unittest.TestCase):
+    pass
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = writeRunner(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ag := New(dir)
+	ag.PythonPath = python
+	ag.Sandbox = &Local{}
+	outcomes, logs, err := ag.RunUnitTests(dir, Limits{Time: 2 * time.Second})
+	if err != nil {
+		t.Fatalf("RunUnitTests returned error %s, want success", err)
+	}
+	outcome := outcomes["EmptyTest"].(map[string]interface{})
+	if outcome["status"] != string(StatusError) || outcome["passed"] != false ||
+		outcome["error"] != "no test cases found" {
+		t.Errorf("outcome = %v, want status %q\nlog:\n%s", outcome, StatusError, logs["EmptyTest.py"])
+	}
+}
+
+func TestRunInlineTestStatus(t *testing.T) {
+	python, err := exec.LookPath("python3")
+	if err != nil {
+		t.Skip("python3 is not available")
+	}
+	limits := Limits{Time: 2 * time.Second, Memory: 200 << 20}
+	tests := []struct {
+		name       string
+		submission string
+		want       Status
+	}{
+		{"Passed", "x = 1", StatusPassed},
+		{"Failed", "x = 2", StatusFailed},
+		{"Error", "x = undefined", StatusError},
+		{"Timeout", "while True: pass", StatusTimeout},
+		{"MemoryExceeded", "x = ['*' * 1000000 for i in range(10000)]", StatusMemoryExceeded},
+		{"Crashed", "import os, signal\nos.kill(os.getpid(), signal.SIGSEGV)", StatusCrashed},
+		{"SpoofedOutcome", "x = 2\nprint('OK{{}}')", StatusFailed},
+		{"SpoofedExit", "x = 2\nprint('OK{{}}')\nimport os\nos._exit(0)", StatusError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			b, err := generateInlineTest("import sys", tt.submission, "assert x == 1, 'x is {{%s}}' % x")
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = ioutil.WriteFile(filepath.Join(dir, "X_inlinetest.py"), b, 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = writeRunner(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ag := New(dir)
+			ag.PythonPath = python
+			ag.Sandbox = &Local{}
+			outcome, log, report, err := ag.RunInlineTest(dir, "X_inlinetest.py", limits)
+			if err != nil {
+				t.Fatalf("RunInlineTest returned error %s, want success", err)
+			}
+			if got := outcome["status"]; got != string(tt.want) {
+				t.Errorf("status = %q, want %q\nlog:\n%s", got, tt.want, log)
+			}
+			if report == "" {
+				t.Errorf("RunInlineTest returned empty report")
+			}
+			if tt.want == StatusFailed && outcome["error"] != "x is {{2}}" {
+				t.Errorf("error = %q, want %q", outcome["error"], "x is {{2}}")
+			}
+		})
+	}
+}
+
+// recordingSandbox records the result of the last command.
+type recordingSandbox struct {
+	Local
+	result *RunResult
+}
+
+func (s *recordingSandbox) Run(c *Command) (*RunResult, error) {
+	result, err := s.Local.Run(c)
+	s.result = result
+	return result, err
+}
+
+func TestRunInlineTestMemory(t *testing.T) {
+	python, err := exec.LookPath("python3")
+	if err != nil {
+		t.Skip("python3 is not available")
+	}
+	limits := Limits{Time: 5 * time.Second, Memory: 200 << 20}
+	tests := []struct {
+		name       string
+		submission string
+		// minMemory is the peak memory the submission must use for the test
+		// to be meaningful.
+		minMemory int64
+		want      Status
+	}{
+		// The allocation fails right away, far below the limit.
+		{"MemoryError", "x = bytearray(1 << 40)", 0, StatusMemoryExceeded},
+		// The submission uses most of the memory and fails the test.
+		{"HighMemoryFailed", "y = b'*' * (170 << 20)\nx = 2", limits.Memory - limits.Memory/10, StatusFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			b, err := generateInlineTest("import sys", tt.submission, "assert x == 1, 'x is {{%s}}' % x")
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = ioutil.WriteFile(filepath.Join(dir, "X_inlinetest.py"), b, 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = writeRunner(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sb := &recordingSandbox{}
+			ag := New(dir)
+			ag.PythonPath = python
+			ag.Sandbox = sb
+			outcome, log, _, err := ag.RunInlineTest(dir, "X_inlinetest.py", limits)
+			if err != nil {
+				t.Fatalf("RunInlineTest returned error %s, want success", err)
+			}
+			if sb.result.PeakMemory < tt.minMemory {
+				t.Fatalf("peak memory = %d MB, want at least %d MB", sb.result.PeakMemory>>20, tt.minMemory>>20)
+			}
+			if got := outcome["status"]; got != string(tt.want) {
+				t.Errorf("status = %q, want %q\nlog:\n%s", got, tt.want, log)
+			}
+		})
+	}
+}
+
+func TestGradeExerciseConcurrently(t *testing.T) {
+	python, err := exec.LookPath("python3")
+	if err != nil {
+		t.Skip("python3 is not available")
+	}
+	want := map[string]Status{
+		"hello": StatusPassed,
+		"fail":  StatusFailed,
+	}
+	ag := New("")
+	ag.PythonPath = python
+	ag.Sandbox = &Local{}
+	scratch := t.TempDir()
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		for fixture, status := range want {
+			exerciseDir := setupFixture(t, fixture)
+			submission, err := ioutil.ReadFile(filepath.Join(exerciseDir, "submission.py"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = os.Remove(filepath.Join(exerciseDir, "submission.py"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			scratchDir := filepath.Join(scratch, fixture, string(rune('0'+i)))
+			wg.Add(1)
+			go func(fixture string, status Status) {
+				defer wg.Done()
+				outcome, err := ag.GradeExercise(exerciseDir, scratchDir, string(submission))
+				if err != nil {
+					t.Errorf("GradeExercise(%s) returned error %s, want success", fixture, err)
+					return
+				}
+				results, _ := outcome["results"].(map[string]interface{})
+				testOutcome, _ := results["FixtureTest"].(map[string]interface{})
+				if got := testOutcome["status"]; got != string(status) {
+					t.Errorf("GradeExercise(%s) status = %q, want %q", fixture, got, status)
+				}
+			}(fixture, status)
+		}
+	}
+	wg.Wait()
+}
+
+func TestGradeExerciseWithOutputs(t *testing.T) {
+	python, err := exec.LookPath("python3")
+	if err != nil {
+		t.Skip("python3 is not available")
+	}
+	exerciseDir := t.TempDir()
+	err = ioutil.WriteFile(filepath.Join(exerciseDir, "OutputsTest.py"), []byte(`import json
+import unittest
+
+class OutputsTest(unittest.TestCase):
+  def test_printed(self):
+    with open('submission_outputs.json') as f:
+      outputs = json.load(f)
+    self.assertEqual(outputs['stdout'], 'hi\n')
+    self.assertIn('image/png', outputs['display_data'][0])
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ag := New("")
+	ag.PythonPath = python
+	ag.Sandbox = &Local{}
+	tests := []struct {
+		name    string
+		outputs []*notebook.Output
+		want    bool
+	}{
+		{
+			name: "NoOutputs",
+			want: false,
+		},
+		{
+			name: "Printed",
+			outputs: []*notebook.Output{
+				{Type: notebook.StreamOutput, Name: "stdout", Text: "hi\n"},
+				{Type: notebook.DisplayDataOutput, Data: notebook.MIMEBundle{"image/png": "iVBORw0KGgo=\n"}},
+			},
+			want: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var outputs map[string]interface{}
+			if tt.outputs != nil {
+				outputs = notebook.SubmissionOutputs(tt.outputs)
+			}
+			outcome, err := ag.GradeExerciseWithOutputs(exerciseDir, t.TempDir(), "print('hi')\n", outputs)
+			if err != nil {
+				t.Fatalf("GradeExerciseWithOutputs returned error %s, want success", err)
+			}
+			results, _ := outcome["results"].(map[string]interface{})
+			testOutcome, _ := results["OutputsTest"].(map[string]interface{})
+			if got := testOutcome["passed"]; got != tt.want {
+				t.Errorf("passed = %v, want %v, logs: %v", got, tt.want, outcome["logs"])
+			}
+		})
+	}
+}
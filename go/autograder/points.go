@@ -0,0 +1,147 @@
+package autograder
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/google/prog-edu-assistant/notebook"
+)
+
+// The weights of the tests are declared in the test sources with "points: N",
+// in the docstring of a unit test method:
+//
+//   def test_hello(self):
+//     """Checks the greeting. points: 2"""
+//
+// or in a comment of an inline test:
+//
+//   %%inlinetest HelloTest
+//   # points: 2
+//   assert hello() == "Hello"
+//
+// The tests without declared points, and the input/output tests, have weight 1. The weights are read from
+// the exercise directory rather than from the test results, so that the tests
+// that did not run, e.g. because of a syntax error, are still counted.
+
+var (
+	testMethodRegex = regexp.MustCompile(`(?m)^[ \t]+def (test[a-zA-Z0-9_]*)[ \t]*\(`)
+	pointsRegex     = regexp.MustCompile(`\bpoints:[ \t]*([0-9]+(?:\.[0-9]+)?)`)
+)
+
+// defaultWeight is the weight of a test without declared points.
+const defaultWeight = 1.0
+
+// parsePoints returns the points declared in the text, or the default weight.
+func parsePoints(text string) float64 {
+	if m := pointsRegex.FindStringSubmatch(text); m != nil {
+		if points, err := strconv.ParseFloat(m[1], 64); err == nil {
+			return points
+		}
+	}
+	return defaultWeight
+}
+
+// testWeights holds the weights of the tests of an exercise.
+type testWeights struct {
+	// unit maps the unit test name to the map from the test method name
+	// to its weight.
+	unit map[string]map[string]float64
+	// inline maps the inline test name to its weight.
+	inline map[string]float64
+}
+
+// unitTestWeights returns the weights of the test methods in the unit test source.
+func unitTestWeights(source string) map[string]float64 {
+	weights := make(map[string]float64)
+	mm := testMethodRegex.FindAllStringSubmatchIndex(source, -1)
+	for i, m := range mm {
+		// The method body extends until the next test method.
+		end := len(source)
+		if i+1 < len(mm) {
+			end = mm[i+1][0]
+		}
+		weights[source[m[2]:m[3]]] = parsePoints(source[m[1]:end])
+	}
+	return weights
+}
+
+// loadTestWeights reads the weights of the unit tests (*Test.py) and the inline
+// tests (*_inline.py) in the exercise directory.
+func loadTestWeights(exerciseDir string) (*testWeights, error) {
+	fss, err := ioutil.ReadDir(exerciseDir)
+	if err != nil {
+		return nil, fmt.Errorf("error on listing %q: %s", exerciseDir, err)
+	}
+	w := &testWeights{
+		unit:   make(map[string]map[string]float64),
+		inline: make(map[string]float64),
+	}
+	for _, fs := range fss {
+		filename := fs.Name()
+		isUnit := strings.HasSuffix(filename, "Test.py")
+		isInline := strings.HasSuffix(filename, "_inline.py")
+		if !isUnit && !isInline {
+			continue
+		}
+		b, err := ioutil.ReadFile(filepath.Join(exerciseDir, filename))
+		if err != nil {
+			return nil, fmt.Errorf("error reading %q: %s", filename, err)
+		}
+		if isUnit {
+			w.unit[strings.TrimSuffix(filename, ".py")] = unitTestWeights(string(b))
+		} else {
+			w.inline[strings.TrimSuffix(filename, "_inline.py")] = parsePoints(string(b))
+		}
+	}
+	// The input/output tests have the default weight and are scored
+	// together with the inline tests.
+	names, err := ioTestNames(filepath.Join(exerciseDir, notebook.IOTestDir))
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		w.inline[name] = defaultWeight
+	}
+	return w, nil
+}
+
+// roundPoints rounds the points to two decimal places.
+func roundPoints(x float64) float64 {
+	return math.Round(x*100) / 100
+}
+
+// score computes the points earned and possible given the outcomes of the unit
+// tests and the inline tests. If the exercise declares the points it is worth,
+// the earned points are scaled to it.
+func (w *testWeights) score(unitOutcomes, inlineOutcomes map[string]interface{}, points *float64) (earned, possible float64) {
+	for name, methods := range w.unit {
+		outcome, _ := unitOutcomes[name].(map[string]interface{})
+		for method, weight := range methods {
+			possible += weight
+			if outcome[method] == true {
+				earned += weight
+			}
+		}
+	}
+	for name, weight := range w.inline {
+		possible += weight
+		outcome, _ := inlineOutcomes[name].(map[string]interface{})
+		if outcome["passed"] == true {
+			earned += weight
+		}
+	}
+	if points != nil {
+		if possible > 0 {
+			earned = *points * earned / possible
+		} else {
+			earned = 0
+		}
+		possible = *points
+	}
+	return roundPoints(earned), roundPoints(possible)
+}
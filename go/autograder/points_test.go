@@ -0,0 +1,66 @@
+package autograder
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestUnitTestWeights(t *testing.T) {
+	source := `import unittest
+import submission
+
+class HelloTest(unittest.TestCase):
+  def test_hello(self):
+    """Checks the greeting. points: 2"""
+    self.assertEqual(submission.hello(), "Hello")
+
+  def test_empty(self):
+    self.assertNotEqual(submission.hello(), "")
+
+  def test_world(self):
+    # points: 0.5
+    self.assertIn("world", submission.hello())
+
+  def helper(self):
+    pass
+`
+	want := map[string]float64{
+		"test_hello": 2,
+		"test_empty": 1,
+		"test_world": 0.5,
+	}
+	if got := unitTestWeights(source); !reflect.DeepEqual(got, want) {
+		t.Errorf("unitTestWeights returned %v, want %v", got, want)
+	}
+}
+
+func TestScore(t *testing.T) {
+	w := &testWeights{
+		unit: map[string]map[string]float64{
+			"HelloTest": {"test_a": 2, "test_b": 1},
+			// The test that did not run at all.
+			"OtherTest": {"test_c": 1},
+		},
+		inline: map[string]float64{"Inline": 1},
+	}
+	unitOutcomes := map[string]interface{}{
+		"HelloTest": map[string]interface{}{"passed": false, "test_a": true, "test_b": false},
+		"OtherTest": map[string]interface{}{"passed": false, "status": "timeout"},
+	}
+	inlineOutcomes := map[string]interface{}{
+		"Inline": map[string]interface{}{"passed": true},
+	}
+	earned, possible := w.score(unitOutcomes, inlineOutcomes, nil)
+	if earned != 3 || possible != 5 {
+		t.Errorf("score = %v/%v, want 3/5", earned, possible)
+	}
+	points := 10.0
+	earned, possible = w.score(unitOutcomes, inlineOutcomes, &points)
+	if earned != 6 || possible != 10 {
+		t.Errorf("score with 10 points = %v/%v, want 6/10", earned, possible)
+	}
+	earned, possible = w.score(nil, nil, &points)
+	if earned != 0 || possible != 10 {
+		t.Errorf("score of nothing = %v/%v, want 0/10", earned, possible)
+	}
+}
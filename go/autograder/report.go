@@ -0,0 +1,219 @@
+package autograder
+
+import (
+	"fmt"
+	"html"
+	htmltemplate "html/template"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// The report templates written with %%template NAME go are Go html/template
+// templates, rendered in-process by RenderReports. The template is executed
+// with a map containing the following fields:
+// * results, logs, reports, points_earned, points_possible: same as in
+//   the exercise outcome, without the details of the hidden tests.
+// * source: the submitted source code.
+// * formatted_source: the syntax-highlighted HTML of the submitted source code.
+// The functions in reportFuncs are available to the templates, e.g.
+//
+//   {{badge .results.HelloTest}}
+//   {{if failed .results "HelloTest" "test_hello"}}Your greeting is wrong.{{end}}
+//   {{highlight .source}}
+
+// GoTemplateSuffix is the filename suffix of the Go report templates.
+const GoTemplateSuffix = "_template.tmpl"
+
+// reportFuncs are the helper functions available to the Go report templates.
+var reportFuncs = htmltemplate.FuncMap{
+	"highlight": highlightPython,
+	"badge":     badge,
+	"passed":    passed,
+	"failed":    failed,
+}
+
+// testOutcome returns the outcome of the test or of its method in the results,
+// and whether it is present.
+func testOutcome(results map[string]interface{}, test string, method ...string) (interface{}, bool) {
+	v, ok := results[test]
+	if !ok || len(method) == 0 {
+		return v, ok
+	}
+	m, _ := v.(map[string]interface{})
+	v, ok = m[method[0]]
+	return v, ok
+}
+
+// isPassed reports whether the test outcome, either the outcome map or
+// the boolean outcome of a test method, is passing.
+func isPassed(v interface{}) bool {
+	switch v := v.(type) {
+	case bool:
+		return v
+	case map[string]interface{}:
+		return v["passed"] == true
+	}
+	return false
+}
+
+// passed reports whether the test, or the test method if given, has passed.
+func passed(results map[string]interface{}, test string, method ...string) bool {
+	v, ok := testOutcome(results, test, method...)
+	return ok && isPassed(v)
+}
+
+// failed reports whether the test, or the test method if given, has run
+// and did not pass. The tests that have not run at all, e.g. because of
+// a syntax error in the submission, are neither passed nor failed.
+func failed(results map[string]interface{}, test string, method ...string) bool {
+	v, ok := testOutcome(results, test, method...)
+	return ok && !isPassed(v)
+}
+
+// badge returns a colored PASS or FAIL badge for the test outcome.
+func badge(v interface{}) htmltemplate.HTML {
+	if isPassed(v) {
+		return `<span class="badge badge-pass" style="background: #387; color: white; padding: 1pt 4pt; border-radius: 3pt;">PASS</span>`
+	}
+	return `<span class="badge badge-fail" style="background: #C33; color: white; padding: 1pt 4pt; border-radius: 3pt;">FAIL</span>`
+}
+
+var (
+	// pythonTokenRegex matches one token of the Python source. The groups
+	// are, in order: comment, string, number, name, whitespace.
+	pythonTokenRegex = regexp.MustCompile(`(?s)^(?:` +
+		`(#[^\n]*)|` +
+		`((?i:[rbuf]{0,2})(?:'''.*?(?:'''|$)|""".*?(?:"""|$)|'(?:[^'\\\n]|\\.)*'?|"(?:[^"\\\n]|\\.)*"?))|` +
+		`((?:0[xob][0-9a-fA-F_]+|(?:[0-9][0-9_]*\.?[0-9_]*|\.[0-9][0-9_]*)(?:[eE][+-]?[0-9]+)?)[jJ]?)|` +
+		`([a-zA-Z_][a-zA-Z0-9_]*)|` +
+		`(\s+))`)
+	pythonKeywords = stringSet(
+		"assert", "async", "await", "break", "class", "continue", "def", "del",
+		"elif", "else", "except", "finally", "for", "from", "global", "if",
+		"import", "lambda", "nonlocal", "pass", "raise", "return", "try",
+		"while", "with", "yield")
+	pythonConstants = stringSet("True", "False", "None")
+	pythonWords     = stringSet("and", "in", "is", "not", "or")
+	pythonBuiltins  = stringSet(
+		"abs", "all", "any", "bool", "dict", "enumerate", "filter", "float",
+		"input", "int", "isinstance", "len", "list", "map", "max", "min",
+		"open", "print", "range", "reversed", "round", "set", "sorted", "str",
+		"sum", "super", "tuple", "type", "zip")
+)
+
+func stringSet(words ...string) map[string]bool {
+	ret := make(map[string]bool)
+	for _, w := range words {
+		ret[w] = true
+	}
+	return ret
+}
+
+// highlightPython returns the HTML of the Python source with the tokens
+// wrapped in spans with the CSS classes used by pygments, e.g. k for
+// the keywords and c1 for the comments, so that the stylesheets written
+// for the Python reporter apply.
+func highlightPython(source string) htmltemplate.HTML {
+	var sb strings.Builder
+	sb.WriteString(`<div class="highlight"><pre><span></span>`)
+	span := func(class, text string) {
+		if class == "" {
+			sb.WriteString(html.EscapeString(text))
+			return
+		}
+		fmt.Fprintf(&sb, `<span class="%s">%s</span>`, class, html.EscapeString(text))
+	}
+	// The class of the name after def or class.
+	nextName := ""
+	for len(source) > 0 {
+		m := pythonTokenRegex.FindStringSubmatchIndex(source)
+		if m == nil {
+			// An operator or a punctuation character.
+			r := []rune(source)[0]
+			class := ""
+			if strings.ContainsRune("+-*/%@<>=!&|^~", r) {
+				class = "o"
+			}
+			span(class, string(r))
+			source = source[len(string(r)):]
+			nextName = ""
+			continue
+		}
+		token := source[:m[1]]
+		source = source[m[1]:]
+		switch {
+		case m[2] >= 0:
+			span("c1", token)
+		case m[4] >= 0:
+			class := "s2"
+			if strings.HasSuffix(token, "'") {
+				class = "s1"
+			}
+			span(class, token)
+		case m[6] >= 0:
+			class := "mi"
+			if strings.ContainsAny(token, ".eE") && !strings.HasPrefix(token, "0x") {
+				class = "mf"
+			}
+			span(class, token)
+		case m[8] >= 0:
+			class := ""
+			switch {
+			case nextName != "":
+				class = nextName
+			case token == "import" || token == "from":
+				class = "kn"
+			case pythonKeywords[token]:
+				class = "k"
+			case pythonConstants[token]:
+				class = "kc"
+			case pythonWords[token]:
+				class = "ow"
+			case pythonBuiltins[token]:
+				class = "nb"
+			}
+			span(class, token)
+			nextName = ""
+			if token == "def" {
+				nextName = "nf"
+			} else if token == "class" {
+				nextName = "nc"
+			}
+		default:
+			span("", token)
+		}
+	}
+	sb.WriteString("</pre></div>")
+	return htmltemplate.HTML(sb.String())
+}
+
+// renderGoTemplate renders the Go report template in the scratch directory.
+func renderGoTemplate(dir, filename string, data map[string]interface{}) ([]byte, error) {
+	b, err := ioutil.ReadFile(filepath.Join(dir, filename))
+	if err != nil {
+		return nil, fmt.Errorf("error reading %q: %s", filename, err)
+	}
+	tmpl, err := htmltemplate.New(filename).Funcs(reportFuncs).Parse(string(b))
+	if err != nil {
+		return nil, err
+	}
+	source, err := ioutil.ReadFile(filepath.Join(dir, "submission.py"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("error reading the submission: %s", err)
+	}
+	fill := make(map[string]interface{})
+	for k, v := range data {
+		fill[k] = v
+	}
+	fill["source"] = string(source)
+	fill["formatted_source"] = highlightPython(string(source))
+	var buf strings.Builder
+	err = tmpl.Execute(&buf, fill)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(buf.String()), nil
+}
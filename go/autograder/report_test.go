@@ -0,0 +1,142 @@
+package autograder
+
+import (
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestHighlightPython(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   string
+	}{
+		{"Keyword", "return x", `<span class="k">return</span> x`},
+		{"Function", "def f(a):", `<span class="k">def</span> <span class="nf">f</span>(a):`},
+		{"Import", "import os", `<span class="kn">import</span> os`},
+		{"Comment", "x  # <b>", `x  <span class="c1"># &lt;b&gt;</span>`},
+		{"String", `print("a\"b", 'c')`, `<span class="nb">print</span>(<span class="s2">&#34;a\&#34;b&#34;</span>, <span class="s1">&#39;c&#39;</span>)`},
+		{"Numbers", "1 + 2.5", `<span class="mi">1</span> <span class="o">+</span> <span class="mf">2.5</span>`},
+		{"Constant", "x is None", `x <span class="ow">is</span> <span class="kc">None</span>`},
+		{"Unicode", "s = 'あ'", `s <span class="o">=</span> <span class="s1">&#39;あ&#39;</span>`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := string(highlightPython(tt.source))
+			want := `<div class="highlight"><pre><span></span>` + tt.want + "</pre></div>"
+			if got != want {
+				t.Errorf("highlightPython(%q) = %q, want %q", tt.source, got, want)
+			}
+		})
+	}
+}
+
+func TestPassedFailed(t *testing.T) {
+	results := map[string]interface{}{
+		"HelloTest": map[string]interface{}{
+			"passed":     false,
+			"test_hello": false,
+			"test_arg":   true,
+		},
+		"inline": map[string]interface{}{"passed": true},
+	}
+	tests := []struct {
+		test, method string
+		passed       bool
+		failed       bool
+	}{
+		{"HelloTest", "", false, true},
+		{"HelloTest", "test_hello", false, true},
+		{"HelloTest", "test_arg", true, false},
+		{"HelloTest", "test_missing", false, false},
+		{"inline", "", true, false},
+		{"Missing", "", false, false},
+		{"Missing", "test_hello", false, false},
+	}
+	for _, tt := range tests {
+		var method []string
+		if tt.method != "" {
+			method = []string{tt.method}
+		}
+		if got := passed(results, tt.test, method...); got != tt.passed {
+			t.Errorf("passed(%q, %q) = %v, want %v", tt.test, tt.method, got, tt.passed)
+		}
+		if got := failed(results, tt.test, method...); got != tt.failed {
+			t.Errorf("failed(%q, %q) = %v, want %v", tt.test, tt.method, got, tt.failed)
+		}
+	}
+}
+
+func TestRenderReportsGo(t *testing.T) {
+	dir := t.TempDir()
+	for filename, content := range map[string]string{
+		"submission.py": "print('Hello')\n",
+		"HelloTest_template.tmpl": `{{badge .results.HelloTest}}
+{{.formatted_source}}
+{{if failed .results "HelloTest" "test_hello"}}Your greeting <is> wrong.{{end}}
+{{index .logs "HelloTest"}}`,
+		"Broken_template.tmpl": `{{if}}`,
+	} {
+		err := ioutil.WriteFile(filepath.Join(dir, filename), []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	ag := New("")
+	report, err := ag.RenderReports(dir, map[string]interface{}{
+		"results": map[string]interface{}{
+			"HelloTest": map[string]interface{}{"passed": false, "test_hello": false},
+		},
+		"logs": map[string]string{"HelloTest": "<traceback>"},
+	}, UnitTestLimits)
+	if err != nil {
+		t.Fatalf("RenderReports returned error %s, want success", err)
+	}
+	for _, want := range []string{
+		"Reporter error",
+		"FAIL</span>",
+		`<span class="nb">print</span>`,
+		"Your greeting <is> wrong.",
+		"&lt;traceback&gt;",
+	} {
+		if !strings.Contains(string(report), want) {
+			t.Errorf("RenderReports = %q, want it to contain %q", string(report), want)
+		}
+	}
+}
+
+func TestRenderReportsPython(t *testing.T) {
+	python, err := exec.LookPath("python3")
+	if err != nil {
+		t.Skip("python3 is not available")
+	}
+	dir := t.TempDir()
+	for filename, content := range map[string]string{
+		"Hello_template.py":  "import json, sys\nprint('earned %s' % json.load(sys.stdin)['points_earned'])\n",
+		"Broken_template.py": "raise ValueError('broken')\n",
+	} {
+		err := ioutil.WriteFile(filepath.Join(dir, filename), []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	sb := &countingSandbox{}
+	ag := New("")
+	ag.PythonPath = python
+	ag.Sandbox = sb
+	report, err := ag.RenderReports(dir, map[string]interface{}{"points_earned": 2}, InlineTestLimits)
+	if err != nil {
+		t.Fatalf("RenderReports returned error %s, want success", err)
+	}
+	if sb.runs != 2 {
+		t.Errorf("RenderReports ran %d commands in the sandbox, want 2", sb.runs)
+	}
+	for _, want := range []string{"earned 2", "Reporter error", "ValueError: broken"} {
+		if !strings.Contains(string(report), want) {
+			t.Errorf("RenderReports = %q, want it to contain %q", string(report), want)
+		}
+	}
+}
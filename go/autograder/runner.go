@@ -0,0 +1,272 @@
+package autograder
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// RunnerFilename is the name of the test runner script written into
+// the scratch directory. It runs the unit tests and provides the reporting
+// functions for the inline tests.
+const RunnerFilename = "autograder_runner.py"
+
+// resultsFDEnv is the environment variable that passes the file descriptor
+// of the results file to the test runner.
+const resultsFDEnv = "AUTOGRADER_RESULTS_FD"
+
+// resultsFD is the file descriptor of the results file in the test runner,
+// the first one after stdin, stdout and stderr, see Command.ExtraFiles.
+const resultsFD = 3
+
+// runnerScript writes the test results as JSON to the inherited file descriptor
+// named by the AUTOGRADER_RESULTS_FD environment variable, so that the autograder
+// does not need to parse the test output, which the submitted code can also
+// print to. The results file is created outside of the scratch directory and
+// removed right away, so that the submitted code cannot write the results file
+// by name. The outcome markers of the inline tests are still printed for the logs.
+// The runner also reports the MemoryError exceptions, so that the autograder
+// can tell the code that ran out of memory from the code that failed.
+var runnerScript = `"""Runs the autograder tests and writes the results to a JSON file.
+
+Usage: python autograder_runner.py SomeTest.py
+       python autograder_runner.py --script submission.py
+
+The results file is {"tests": [{"id", "status", "message", "traceback",
+"duration", "memory_error"}]}, where status is one of passed, failed, error
+or skipped, and memory_error is true if the test raised MemoryError.
+With --script, the script is run as __main__ and only a MemoryError
+is reported.
+"""
+
+import json
+import os
+import sys
+import time
+import traceback
+import unittest
+
+_results_fd = os.environ.pop('` + resultsFDEnv + `', None)
+if _results_fd is not None:
+  _results_fd = int(_results_fd)
+  # Do not pass the results file to the subprocesses.
+  os.set_inheritable(_results_fd, False)
+_results = []
+_start = time.time()
+
+
+def _write():
+  if _results_fd is None:
+    return
+  data = json.dumps({'tests': _results}).encode('utf-8')
+  os.lseek(_results_fd, 0, os.SEEK_SET)
+  os.ftruncate(_results_fd, 0)
+  while data:
+    data = data[os.write(_results_fd, data):]
+
+
+def add_result(test_id, status, message='', tb='', duration=0.0, exc_type=None):
+  result = {
+      'id': test_id,
+      'status': status,
+      'message': message,
+      'traceback': tb,
+      'duration': duration,
+  }
+  if exc_type is not None and issubclass(exc_type, MemoryError):
+    result['memory_error'] = True
+  _results.append(result)
+  _write()
+
+
+_inline_statuses = {'OK': 'passed', 'FAIL': 'failed', 'ERROR': 'error'}
+
+
+def report(status, stage='', exc=None):
+  """Reports the outcome of an inline test: OK, FAIL or ERROR."""
+  message = ''
+  tb = ''
+  if exc is not None:
+    message = str(exc)
+    tb = ''.join(traceback.format_exception(type(exc), exc, exc.__traceback__))
+  if status == 'OK':
+    print('OK{{}}')
+  else:
+    print('\n%s: %s{{%s}}' % (stage, status, message))
+  add_result('inline', _inline_statuses[status], message, tb, time.time() - _start,
+             type(exc) if exc is not None else None)
+
+
+class _JSONResult(unittest.TextTestResult):
+
+  def startTest(self, test):
+    self._test_start = time.time()
+    super().startTest(test)
+
+  def _add(self, test_id, status, err=None, test=None):
+    message = ''
+    tb = ''
+    if err is not None:
+      message = str(err[1])
+      tb = self._exc_info_to_string(err, test)
+    add_result(test_id, status, message, tb,
+               time.time() - getattr(self, '_test_start', _start),
+               err[0] if err is not None else None)
+
+  def addSuccess(self, test):
+    super().addSuccess(test)
+    self._add(test.id(), 'passed')
+
+  def addFailure(self, test, err):
+    super().addFailure(test, err)
+    self._add(test.id(), 'failed', err, test)
+
+  def addError(self, test, err):
+    super().addError(test, err)
+    self._add(test.id(), 'error', err, test)
+
+  def addSubTest(self, test, subtest, err):
+    super().addSubTest(test, subtest, err)
+    if err is not None:
+      status = 'failed' if issubclass(err[0], test.failureException) else 'error'
+      self._add(test.id(), status, err, test)
+
+  def addSkip(self, test, reason):
+    super().addSkip(test, reason)
+    add_result(test.id(), 'skipped', reason)
+
+  def addExpectedFailure(self, test, err):
+    super().addExpectedFailure(test, err)
+    self._add(test.id(), 'passed')
+
+  def addUnexpectedSuccess(self, test):
+    super().addUnexpectedSuccess(test)
+    self._add(test.id(), 'failed')
+
+
+def run_script(filename):
+  """Runs the script as python would, reporting a MemoryError."""
+  sys.argv = [filename]
+  with open(filename, 'rb') as f:
+    code = compile(f.read(), filename, 'exec')
+  try:
+    exec(code, {'__name__': '__main__', '__file__': filename,
+                '__builtins__': __builtins__})
+  except SystemExit:
+    raise
+  except BaseException as e:
+    if isinstance(e, MemoryError):
+      add_result('script', 'error', str(e), '', time.time() - _start, type(e))
+    # Leave out the frame of the runner from the traceback.
+    traceback.print_exception(type(e), e, e.__traceback__.tb_next)
+    sys.exit(1)
+
+
+def main(argv):
+  if len(argv) == 3 and argv[1] == '--script':
+    run_script(argv[2])
+    return
+  runner = unittest.TextTestRunner(verbosity=2, resultclass=_JSONResult)
+  try:
+    program = unittest.main(module=None, argv=argv, testRunner=runner, exit=False)
+  except MemoryError as e:
+    # Raised while importing the test, outside of any test case.
+    add_result('main', 'error', str(e), traceback.format_exc(),
+               time.time() - _start, type(e))
+    raise
+  _write()
+  sys.exit(0 if program.result.wasSuccessful() else 1)
+
+
+if __name__ == '__main__':
+  main(sys.argv)
+`
+
+// TestResult is the result of one test case reported by the test runner.
+type TestResult struct {
+	// ID is the unittest test id, e.g. "HelloTest.HelloTest.test_hello",
+	// or "inline" for inline tests.
+	ID string `json:"id"`
+	// Status is one of passed, failed, error or skipped.
+	Status string `json:"status"`
+	// Message is the assertion or exception message.
+	Message string `json:"message"`
+	// Traceback is the formatted traceback of the failure or error.
+	Traceback string `json:"traceback"`
+	// Duration is the running time of the test case in seconds.
+	Duration float64 `json:"duration"`
+	// MemoryError is true if the test raised MemoryError, i.e. ran out of
+	// the memory limit.
+	MemoryError bool `json:"memory_error,omitempty"`
+}
+
+// Method returns the test method name, i.e. the last component of the test id.
+func (r *TestResult) Method() string {
+	return r.ID[strings.LastIndex(r.ID, ".")+1:]
+}
+
+// memoryError returns true if any of the results reports a MemoryError.
+func memoryError(results []*TestResult) bool {
+	for _, r := range results {
+		if r.MemoryError {
+			return true
+		}
+	}
+	return false
+}
+
+// writeRunner writes the test runner script into the scratch directory.
+func writeRunner(dir string) error {
+	filename := filepath.Join(dir, RunnerFilename)
+	err := ioutil.WriteFile(filename, []byte(runnerScript), 0644)
+	if err != nil {
+		return fmt.Errorf("error writing to %q: %s", filename, err)
+	}
+	return nil
+}
+
+// createResults creates the results file for the test runner, which is passed
+// to the sandboxed test as an inherited file descriptor. The file is created
+// in the system temporary directory, outside of the scratch directory, and
+// removed right away, so it has no name the test could open. The caller
+// should close the file.
+func createResults() (*os.File, error) {
+	f, err := ioutil.TempFile("", "autograder-results-")
+	if err != nil {
+		return nil, fmt.Errorf("error creating results file: %s", err)
+	}
+	err = os.Remove(f.Name())
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("error removing %q: %s", f.Name(), err)
+	}
+	return f, nil
+}
+
+// readResults reads the results written by the test runner. It returns
+// an error if the runner did not write the results, e.g. was killed.
+func readResults(f *os.File) ([]*TestResult, error) {
+	_, err := f.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+	b, err := ioutil.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) == 0 {
+		return nil, fmt.Errorf("no results written")
+	}
+	var data struct {
+		Tests []*TestResult `json:"tests"`
+	}
+	err = json.Unmarshal(b, &data)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing results: %s", err)
+	}
+	return data.Tests, nil
+}
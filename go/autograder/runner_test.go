@@ -0,0 +1,23 @@
+package autograder
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseUnitTestOutput(t *testing.T) {
+	out := []byte(`test_a (HelloTest.HelloTest) ... ok
+test_b (HelloTest.HelloTest.test_b) ... FAIL
+test_c (HelloTest.HelloTest) ... ERROR
+print from the test: test_d (Spoof.Spoof) ...
+`)
+	want := []*TestResult{
+		{ID: "HelloTest.HelloTest.test_a", Status: "passed"},
+		{ID: "HelloTest.HelloTest.test_b", Status: "failed"},
+		{ID: "HelloTest.HelloTest.test_c", Status: "error"},
+	}
+	got := parseUnitTestOutput(out)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseUnitTestOutput returned %+v, want %+v", got, want)
+	}
+}
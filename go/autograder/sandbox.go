@@ -0,0 +1,432 @@
+package autograder
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// Limits specifies the resource limits of a sandboxed command.
+// Zero values mean no limit.
+type Limits struct {
+	// Time is the limit on the wall time.
+	Time time.Duration
+	// CPUTime is the limit on the CPU time.
+	CPUTime time.Duration
+	// Memory is the limit on the address space size in bytes.
+	Memory int64
+	// CPUs is the number of CPUs the command can use. Only nsjail enforces it.
+	CPUs int
+	// AllowNetwork permits the command to access the network.
+	AllowNetwork bool
+}
+
+// Command describes a command to run in a sandbox.
+type Command struct {
+	// Args is the command line, starting with the path to the binary.
+	Args []string
+	// Dir is the working directory. It should be an absolute path.
+	Dir string
+	// Env lists the extra environment variables in the form KEY=value.
+	Env []string
+	// Stdin is the standard input of the command. If nil, the command
+	// reads from the null device.
+	Stdin []byte
+	// ExtraFiles are passed to the command as the open file descriptors
+	// 3, 4 and so on.
+	ExtraFiles []*os.File
+	// Hidden lists the absolute paths of the directories that the command
+	// must not read, e.g. the autograder scripts with the expected outputs.
+	// Local does not hide them.
+	Hidden []string
+	// Limits are the resource limits for the command.
+	Limits Limits
+}
+
+// Limit names the resource limit that was hit by a command.
+type Limit string
+
+const (
+	NoLimit   Limit = ""
+	TimeLimit Limit = "time"
+	CPULimit  Limit = "cpu"
+)
+
+// RunResult describes the outcome of a sandboxed command.
+type RunResult struct {
+	// Output is the combined stdout and stderr output of the command.
+	Output []byte
+	// Stdout is the standard output of the command alone.
+	Stdout []byte
+	// ExitStatus is the exit status of the command, or -1 if it was killed by a signal.
+	ExitStatus int
+	// Signal is the signal that killed the command, if any.
+	Signal syscall.Signal
+	// SandboxError is set if the sandbox itself failed, e.g. could not set up
+	// the isolation, and the command may not have run at all.
+	SandboxError string
+	// WallTime is the elapsed real time.
+	WallTime time.Duration
+	// CPUTime is the user and system CPU time.
+	CPUTime time.Duration
+	// PeakMemory is the maximum resident set size in bytes.
+	PeakMemory int64
+	// LimitExceeded is the resource limit hit by the command, if any.
+	LimitExceeded Limit
+}
+
+// Sandbox runs commands in isolation with resource limits.
+type Sandbox interface {
+	// Run runs the command and waits for it to complete. It returns an error
+	// only if the command could not be run, a command exiting with
+	// non-zero status is reported in the result.
+	Run(cmd *Command) (*RunResult, error)
+}
+
+// run executes the command, measures the resources used and detects which limits
+// were hit. The stdin and limits are taken from c. If timeout is positive,
+// the command is killed after the timeout.
+func run(cmd *exec.Cmd, c *Command, timeout time.Duration) (*RunResult, error) {
+	limits := c.Limits
+	// os/exec copies stdout and stderr in separate goroutines.
+	var output lockedBuffer
+	var stdout bytes.Buffer
+	cmd.Stdout = io.MultiWriter(&output, &stdout)
+	cmd.Stderr = &output
+	if c.Stdin != nil {
+		cmd.Stdin = bytes.NewReader(c.Stdin)
+	}
+	cmd.ExtraFiles = c.ExtraFiles
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	glog.V(5).Infof("about to execute %s %q", cmd.Path, cmd.Args)
+	start := time.Now()
+	err := cmd.Start()
+	if err != nil {
+		return nil, fmt.Errorf("error starting %q %q: %s", cmd.Path, cmd.Args, err)
+	}
+	done := make(chan struct{})
+	// timedOut receives whether the command was killed on timeout.
+	timedOut := make(chan bool, 1)
+	go func() {
+		select {
+		case <-ctx.Done():
+			if ctx.Err() == context.DeadlineExceeded {
+				_ = cmd.Process.Kill()
+				timedOut <- true
+				return
+			}
+		case <-done:
+		}
+		timedOut <- false
+	}()
+	err = cmd.Wait()
+	close(done)
+	result := &RunResult{
+		Output:   output.Bytes(),
+		Stdout:   stdout.Bytes(),
+		WallTime: time.Since(start),
+	}
+	if err != nil {
+		if _, ok := err.(*exec.ExitError); !ok {
+			return nil, fmt.Errorf("error running %q %q: %s", cmd.Path, cmd.Args, err)
+		}
+	}
+	state := cmd.ProcessState
+	result.ExitStatus = state.ExitCode()
+	if status, ok := state.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		result.Signal = status.Signal()
+	} else if result.ExitStatus > 128 && result.ExitStatus <= 128+64 {
+		// nsjail and bubblewrap report the child killed by a signal
+		// with the exit status 128+signal.
+		result.Signal = syscall.Signal(result.ExitStatus - 128)
+	}
+	if usage, ok := state.SysUsage().(*syscall.Rusage); ok {
+		result.CPUTime = time.Duration(usage.Utime.Nano() + usage.Stime.Nano())
+		// Maxrss is in kilobytes on Linux.
+		result.PeakMemory = usage.Maxrss * 1024
+	}
+	result.LimitExceeded = detectLimit(result, limits, <-timedOut)
+	return result, nil
+}
+
+// lockedBuffer is a bytes.Buffer safe for concurrent writes.
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+// Bytes returns the written bytes. It should be called after all writes.
+func (b *lockedBuffer) Bytes() []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Bytes()
+}
+
+// detectLimit guesses which time limit the command has hit. Sandboxes do not
+// report it directly, so it relies on the signals and the time used, but not on
+// the output, which is controlled by the command. The memory limit is on
+// the address space, so the allocations fail with MemoryError, which the test
+// runner reports, see TestResult.MemoryError.
+func detectLimit(result *RunResult, limits Limits, timedOut bool) Limit {
+	if result.ExitStatus == 0 {
+		return NoLimit
+	}
+	switch {
+	case timedOut || limits.Time > 0 && result.WallTime >= limits.Time:
+		return TimeLimit
+	case result.Signal == syscall.SIGXCPU || limits.CPUTime > 0 && result.CPUTime >= limits.CPUTime:
+		return CPULimit
+	}
+	return NoLimit
+}
+
+// seconds formats the duration as a number of seconds, rounded up.
+func seconds(d time.Duration) string {
+	return strconv.FormatInt(int64((d+time.Second-1)/time.Second), 10)
+}
+
+// ulimitScript returns the shell commands that apply the CPU and memory limits
+// with the ulimit builtin, each followed by "&&".
+func ulimitScript(cmd *Command) string {
+	script := ""
+	if cmd.Limits.CPUTime > 0 {
+		script += "ulimit -t " + seconds(cmd.Limits.CPUTime) + " && "
+	}
+	if cmd.Limits.Memory > 0 {
+		script += "ulimit -v " + strconv.FormatInt(cmd.Limits.Memory/1024, 10) + " && "
+	}
+	return script
+}
+
+// ulimitArgs returns the command line that applies the CPU and memory limits
+// with the shell ulimit builtin before executing the command.
+func ulimitArgs(cmd *Command) []string {
+	script := ulimitScript(cmd)
+	if script == "" {
+		return cmd.Args
+	}
+	return append([]string{"/bin/sh", "-c", script + `exec "$@"`, "sh"}, cmd.Args...)
+}
+
+// Local runs commands as local processes without any isolation, with the limits
+// applied by ulimit and the time limit enforced by killing the process.
+// The network access and the number of CPUs are not restricted.
+// It is only intended for development on machines without nsjail or bubblewrap.
+type Local struct{}
+
+func (*Local) Run(c *Command) (*RunResult, error) {
+	args := ulimitArgs(c)
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Dir = c.Dir
+	cmd.Env = append(os.Environ(), c.Env...)
+	return run(cmd, c, c.Limits.Time)
+}
+
+// NSJail runs commands under nsjail (https://github.com/google/nsjail).
+// The command runs as the user nobody without a mount namespace, so
+// the Command.Hidden directories must not be accessible to other users.
+type NSJail struct {
+	// Path is the path to nsjail binary.
+	Path string
+}
+
+func (s *NSJail) Run(c *Command) (*RunResult, error) {
+	for _, dir := range c.Hidden {
+		fi, err := os.Stat(dir)
+		if err != nil {
+			return nil, fmt.Errorf("error checking hidden directory: %s", err)
+		}
+		if fi.Mode().Perm()&0005 != 0 {
+			return nil, fmt.Errorf("nsjail cannot hide %q from the command, as it is accessible to other users", dir)
+		}
+	}
+	args := []string{
+		"-Mo",
+		// NSJail does not work under docker without these disable flags.
+		"--disable_clone_newcgroup",
+		"--disable_clone_newipc",
+		"--disable_clone_newns",
+		"--disable_clone_newpid",
+		"--disable_clone_newuser",
+		"--disable_clone_newuts",
+		"--disable_no_new_privs",
+		"--disable_proc",
+		//"--chroot", "/",
+		"--cwd", c.Dir,
+		"--user", "nobody",
+		"--group", "nogroup",
+	}
+	if c.Limits.AllowNetwork {
+		args = append(args, "--disable_clone_newnet")
+	} else {
+		// The command gets a new network namespace without any interfaces.
+		// If nsjail cannot clone it, e.g. in a container without
+		// the privileges, it fails, and the run is a sandbox failure.
+		args = append(args, "--iface_no_lo")
+	}
+	if c.Limits.CPUs > 0 {
+		args = append(args, "--max_cpus", strconv.Itoa(c.Limits.CPUs))
+	}
+	if c.Limits.Time > 0 {
+		args = append(args, "--time_limit", seconds(c.Limits.Time))
+	}
+	if c.Limits.CPUTime > 0 {
+		args = append(args, "--rlimit_cpu", seconds(c.Limits.CPUTime))
+	}
+	if c.Limits.Memory > 0 {
+		args = append(args, "--rlimit_as", strconv.FormatInt(c.Limits.Memory>>20, 10))
+	}
+	for _, env := range c.Env {
+		args = append(args, "--env", env)
+	}
+	// nsjail closes all other file descriptors in the child.
+	for i := range c.ExtraFiles {
+		args = append(args, "--pass_fd", strconv.Itoa(3+i))
+	}
+	// The nsjail messages are logged to a separate file, so that they cannot
+	// be confused with the output of the command.
+	logFile, err := ioutil.TempFile("", "nsjail-log-")
+	if err != nil {
+		return nil, fmt.Errorf("error creating nsjail log file: %s", err)
+	}
+	logFile.Close()
+	defer os.Remove(logFile.Name())
+	args = append(args, "--log", logFile.Name())
+	args = append(args, "--")
+	args = append(args, c.Args...)
+	cmd := exec.Command(s.Path, args...)
+	// nsjail enforces the time limit itself.
+	result, err := run(cmd, c, 0)
+	if err != nil {
+		return nil, err
+	}
+	log, err := ioutil.ReadFile(logFile.Name())
+	if err != nil {
+		return nil, fmt.Errorf("error reading nsjail log: %s", err)
+	}
+	glog.V(5).Infof("nsjail log:\n%s", log)
+	// nsjail exits with status 255 if it fails, and logs the reason
+	// in the lines starting with [E] or [F]. The command itself may exit
+	// with status 255 too.
+	if m := nsjailErrorRegex.FindSubmatch(log); result.ExitStatus == 255 && m != nil {
+		result.Signal = 0
+		result.LimitExceeded = NoLimit
+		result.SandboxError = "nsjail failed: " + string(m[1])
+	}
+	return result, nil
+}
+
+// nsjailErrorRegex matches the error and fatal messages logged by nsjail.
+var nsjailErrorRegex = regexp.MustCompile(`(?m)^\[[EF]\](?:\[[^]]*\])* (.*)$`)
+
+// Bubblewrap runs commands under bubblewrap (https://github.com/containers/bubblewrap),
+// which does not need root privileges and is available in most Linux distributions.
+// The root filesystem is mounted read-only, only the working directory is writable.
+// The Command.Hidden directories are replaced with empty ones.
+// The inherited file descriptors, e.g. Command.ExtraFiles, are kept open.
+type Bubblewrap struct {
+	// Path is the path to bwrap binary.
+	Path string
+}
+
+func (s *Bubblewrap) Run(c *Command) (*RunResult, error) {
+	args := []string{
+		"--ro-bind", "/", "/",
+		"--dev", "/dev",
+		"--proc", "/proc",
+		"--tmpfs", "/tmp",
+	}
+	for _, dir := range c.Hidden {
+		args = append(args, "--tmpfs", dir)
+	}
+	args = append(args,
+		"--bind", c.Dir, c.Dir,
+		"--chdir", c.Dir,
+		"--unshare-all",
+		"--die-with-parent",
+		"--new-session",
+	)
+	if c.Limits.AllowNetwork {
+		args = append(args, "--share-net")
+	}
+	for _, env := range c.Env {
+		if i := strings.IndexByte(env, '='); i > 0 {
+			args = append(args, "--setenv", env[:i], env[i+1:])
+		}
+	}
+	// bubblewrap prints its errors to the same stderr as the command,
+	// so the command is started by a shell that first writes to the started
+	// pipe, and closes it before executing the command. If nothing was
+	// written, the sandbox failed before the command was started.
+	started, startedW, err := os.Pipe()
+	if err != nil {
+		return nil, fmt.Errorf("error creating pipe: %s", err)
+	}
+	defer started.Close()
+	withPipe := *c
+	withPipe.ExtraFiles = append(append([]*os.File(nil), c.ExtraFiles...), startedW)
+	fd := strconv.Itoa(3 + len(c.ExtraFiles))
+	script := ulimitScript(c) + "printf x >&" + fd + " && exec " + fd + ">&- && "
+	args = append(args, "--", "/bin/sh", "-c", script+`exec "$@"`, "sh")
+	args = append(args, c.Args...)
+	cmd := exec.Command(s.Path, args...)
+	result, err := run(cmd, &withPipe, c.Limits.Time)
+	startedW.Close()
+	if err != nil {
+		return nil, err
+	}
+	// All copies of the write end are closed once the sandbox exits.
+	b, err := ioutil.ReadAll(started)
+	if err != nil {
+		return nil, fmt.Errorf("error reading from pipe: %s", err)
+	}
+	if len(b) == 0 && result.ExitStatus != 0 {
+		// The output comes from bubblewrap alone, the first line is
+		// the reason prefixed with "bwrap: ".
+		line := result.Output
+		if i := bytes.IndexByte(line, '\n'); i >= 0 {
+			line = line[:i]
+		}
+		result.Signal = 0
+		result.LimitExceeded = NoLimit
+		result.SandboxError = "bubblewrap failed: " + string(bytes.TrimPrefix(line, []byte("bwrap: ")))
+	}
+	return result, nil
+}
+
+// NewSandbox creates a sandbox by its name: "nsjail", "bwrap" or "local".
+func NewSandbox(name, nsjailPath, bwrapPath string) (Sandbox, error) {
+	switch name {
+	case "nsjail":
+		return &NSJail{Path: nsjailPath}, nil
+	case "bwrap", "bubblewrap":
+		return &Bubblewrap{Path: bwrapPath}, nil
+	case "local":
+		return &Local{}, nil
+	default:
+		return nil, fmt.Errorf("unknown sandbox %q, want nsjail, bwrap or local", name)
+	}
+}
@@ -0,0 +1,203 @@
+package autograder
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLocalOutput(t *testing.T) {
+	sb := &Local{}
+	// The output is written to stdout and stderr concurrently,
+	// run with -race to check the capture.
+	for i := 0; i < 50; i++ {
+		result, err := sb.Run(&Command{
+			Args: []string{"/bin/sh", "-c", "echo out; echo err >&2"},
+			Dir:  t.TempDir(),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		output := string(result.Output)
+		if !strings.Contains(output, "out\n") || !strings.Contains(output, "err\n") {
+			t.Fatalf("run %d: Output = %q, want both stdout and stderr", i, output)
+		}
+		if string(result.Stdout) != "out\n" {
+			t.Errorf("run %d: Stdout = %q, want %q", i, string(result.Stdout), "out\n")
+		}
+	}
+}
+
+// writeScript writes the shell script with the given body to the directory
+// and returns its path. The script skips its arguments up to "--", sets
+// $log to the value of the --log flag and $sharenet if the network namespace
+// is not cloned.
+func writeScript(t *testing.T, dir, name, body string) string {
+	t.Helper()
+	filename := filepath.Join(dir, name)
+	err := ioutil.WriteFile(filename, []byte(`#!/bin/sh
+while [ "$1" != "--" ]; do
+  if [ "$1" = "--log" ]; then log="$2"; fi
+  if [ "$1" = "--disable_clone_newnet" ]; then sharenet=1; fi
+  shift
+done
+shift
+`+body), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+func TestSandboxError(t *testing.T) {
+	dir := t.TempDir()
+	// The command prints the messages that look like the sandbox errors.
+	args := []string{"/bin/sh", "-c", `echo "[E][2026-10-18T00:00:00+0000][1] fake" >&2; echo "bwrap: fake" >&2; exit 255`}
+	tests := []struct {
+		name    string
+		sandbox Sandbox
+		want    string
+	}{
+		{
+			name:    "NSJail",
+			sandbox: &NSJail{Path: writeScript(t, dir, "nsjail", `exec "$@"`)},
+		},
+		{
+			name: "NSJailFailed",
+			sandbox: &NSJail{Path: writeScript(t, dir, "nsjail-failed",
+				`echo "[E][2026-10-18T00:00:00+0000][1] clone failed" >"$log"; exit 255`)},
+			want: "nsjail failed: clone failed",
+		},
+		{
+			name:    "Bubblewrap",
+			sandbox: &Bubblewrap{Path: writeScript(t, dir, "bwrap", `exec "$@"`)},
+		},
+		{
+			name: "BubblewrapFailed",
+			sandbox: &Bubblewrap{Path: writeScript(t, dir, "bwrap-failed",
+				`echo "bwrap: Can't mount proc" >&2; exit 1`)},
+			want: "bubblewrap failed: Can't mount proc",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := tt.sandbox.Run(&Command{Args: args, Dir: dir})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.SandboxError != tt.want {
+				t.Errorf("SandboxError = %q, want %q", result.SandboxError, tt.want)
+			}
+			if tt.want == "" && result.ExitStatus != 255 {
+				t.Errorf("ExitStatus = %d, want 255", result.ExitStatus)
+			}
+		})
+	}
+}
+
+func TestDetectLimit(t *testing.T) {
+	limits := Limits{Time: 2 * time.Second, Memory: 200 << 20}
+	tests := []struct {
+		name     string
+		result   RunResult
+		timedOut bool
+		want     Limit
+	}{
+		{"Exited", RunResult{ExitStatus: 0, PeakMemory: 200 << 20}, false, NoLimit},
+		{"Failed", RunResult{ExitStatus: 1, PeakMemory: 10 << 20}, false, NoLimit},
+		{"TimedOut", RunResult{ExitStatus: -1}, true, TimeLimit},
+		// The memory limit is reported by the test runner, not guessed
+		// from the memory used.
+		{"HighMemory", RunResult{ExitStatus: 1, PeakMemory: 190 << 20}, false, NoLimit},
+		{"MemoryErrorOutput", RunResult{ExitStatus: 1, PeakMemory: 10 << 20, Output: []byte("MemoryError")}, false, NoLimit},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := detectLimit(&tt.result, limits, tt.timedOut); got != tt.want {
+				t.Errorf("detectLimit = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSandboxHidden(t *testing.T) {
+	dir := t.TempDir()
+	hidden := t.TempDir()
+	args := []string{"/bin/true"}
+	nsjail := &NSJail{Path: writeScript(t, dir, "nsjail", `exec "$@"`)}
+	// nsjail runs the command as nobody, so the hidden directory must not
+	// be accessible to other users.
+	err := os.Chmod(hidden, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := nsjail.Run(&Command{Args: args, Dir: dir, Hidden: []string{hidden}}); err == nil {
+		t.Errorf("NSJail.Run with a world-readable hidden directory returned success, want error")
+	}
+	err = os.Chmod(hidden, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := nsjail.Run(&Command{Args: args, Dir: dir, Hidden: []string{hidden}})
+	if err != nil || result.ExitStatus != 0 {
+		t.Errorf("NSJail.Run returned %v, %v, want success", result, err)
+	}
+	// bubblewrap mounts an empty directory over the hidden one.
+	argsFilename := filepath.Join(dir, "bwrap-args")
+	bwrap := filepath.Join(dir, "bwrap")
+	err = ioutil.WriteFile(bwrap, []byte(`#!/bin/sh
+echo "$@" >`+argsFilename+`
+while [ "$1" != "--" ]; do shift; done
+shift
+exec "$@"
+`), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err = (&Bubblewrap{Path: bwrap}).Run(&Command{Args: args, Dir: dir, Hidden: []string{hidden}})
+	if err != nil || result.ExitStatus != 0 || result.SandboxError != "" {
+		t.Fatalf("Bubblewrap.Run returned %v, %v, want success", result, err)
+	}
+	b, err := ioutil.ReadFile(argsFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	i := strings.Index(string(b), "--tmpfs "+hidden+" ")
+	if i < 0 || i > strings.Index(string(b), "--bind "+dir) {
+		t.Errorf("bwrap arguments = %s, want --tmpfs %s before binding the working directory", string(b), hidden)
+	}
+}
+
+func TestNSJailNetwork(t *testing.T) {
+	dir := t.TempDir()
+	// The fake nsjail fails to clone the network namespace, as nsjail
+	// does in a container without the privileges.
+	nsjail := &NSJail{Path: writeScript(t, dir, "nsjail", `if [ -z "$sharenet" ]; then
+  echo "[E][2026-10-18T00:00:00+0000][1] clone(flags=CLONE_NEWNET): Operation not permitted" >"$log"
+  exit 255
+fi
+exec "$@"`)}
+	tests := []struct {
+		allowNetwork bool
+		want         string
+	}{
+		{false, "nsjail failed: clone(flags=CLONE_NEWNET): Operation not permitted"},
+		{true, ""},
+	}
+	for _, tt := range tests {
+		result, err := nsjail.Run(&Command{
+			Args:   []string{"/bin/true"},
+			Dir:    dir,
+			Limits: Limits{AllowNetwork: tt.allowNetwork},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.SandboxError != tt.want {
+			t.Errorf("AllowNetwork %v: SandboxError = %q, want %q", tt.allowNetwork, result.SandboxError, tt.want)
+		}
+	}
+}
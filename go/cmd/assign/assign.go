@@ -12,6 +12,28 @@
 //     -command autograder
 //     -input ../exercies/helloworld-en-master.ipynb
 //     -output ./autograder-dir
+//     -master_output ./master-dir
+//
+//   go run cmd/assign/assign.go
+//     -command lint
+//     -input ../exercies/helloworld-en-master.ipynb
+//
+//   go run cmd/assign/assign.go
+//     -command languages
+//     -language en,ja
+//     -input ../exercies/functional-master.ipynb
+//
+// The languages command reports the groups of cells that are missing
+// the translation to some of the languages.
+//
+// The lint command reports the problems found in the master notebook, one per
+// line in the form "<file>: cell <index>, line <line>: <message>". It exits
+// with status 0 if there are no problems, 1 if there are problems and 2 if
+// the notebook could not be read at all.
+//
+// The master notebook can be either a Jupyter notebook (.ipynb) or
+// a Python script in the percent format (.py). The format of the student
+// notebook is chosen from the extension of the output file in the same way.
 //
 package main
 
@@ -22,6 +44,8 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 
 	"github.com/google/prog-edu-assistant/notebook"
 )
@@ -33,7 +57,17 @@ var (
 	output = flag.String("output", "",
 		"The file name of the output. If empty, output is written to stdout.")
 	language = flag.String("language", "",
-		"The language that should be used in the output notebook.")
+		"The language that should be used in the output notebook, as a BCP-47 tag "+
+			"such as en, ja or pt-BR. If there is no text in the language, the fallback "+
+			"languages are used, e.g. pt-BR falls back to pt and then en. "+
+			"For the languages command, a comma-separated list of languages to check.")
+	masterOutput = flag.String("master_output", "",
+		"The directory to write the master solutions and their outputs into, in the same layout as --output, "+
+			"for checking them with grade --check_master. They are not written into --output, "+
+			"as the autograder directory is deployed to the workers. If empty, they are not written.")
+	deriveCellIDs = flag.Bool("derive_cell_ids", false,
+		"If true, the cell IDs of the output are derived from the master cell IDs, "+
+			"so that the output cells can be traced back to the master notebook.")
 )
 
 type commandDesc struct {
@@ -45,11 +79,27 @@ var commands = map[string]commandDesc{
 	"parse":      commandDesc{"Try parsing the input", parseCommand},
 	"student":    commandDesc{"Extract student notebook", studentCommand},
 	"autograder": commandDesc{"Extract autograder scripts", autograderCommand},
+	"lint":       commandDesc{"Check the master notebook for mistakes", lintCommand},
+	"languages":  commandDesc{"List the cells with missing translations", languagesCommand},
+}
+
+// exitError is an error that makes the binary exit with the given status.
+type exitError struct {
+	status int
+	err    error
+}
+
+func (e *exitError) Error() string {
+	return e.err.Error()
 }
 
 func main() {
 	flag.Parse()
 	err := run()
+	if e, ok := err.(*exitError); ok {
+		log.Print(e)
+		os.Exit(e.status)
+	}
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -70,47 +120,116 @@ func run() error {
 	return cmd.Func()
 }
 
+// isPercentFormat returns true if the file name has the extension of a notebook
+// in the percent format.
+func isPercentFormat(filename string) bool {
+	return strings.ToLower(filepath.Ext(filename)) == ".py"
+}
+
+// parseNotebook parses the notebook file, choosing the format
+// by the file extension.
+func parseNotebook(filename string) (*notebook.Notebook, error) {
+	if isPercentFormat(filename) {
+		return notebook.ParsePercentFile(filename)
+	}
+	return notebook.ParseFile(filename)
+}
+
+// marshalNotebook serializes the notebook in the format that matches
+// the extension of the output file name.
+func marshalNotebook(n *notebook.Notebook, filename string) ([]byte, error) {
+	if isPercentFormat(filename) {
+		return n.MarshalPercent()
+	}
+	return n.Marshal()
+}
+
 func parseCommand() error {
-	n, err := notebook.ParseFile(*input)
+	n, err := parseNotebook(*input)
 	if err != nil {
 		return err
 	}
 	fmt.Printf("%d cells\n", len(n.Cells))
 	for _, cell := range n.Cells {
 		fmt.Printf("%s: %s\n", cell.Type, cell.Source)
+		for _, output := range cell.Outputs {
+			fmt.Printf("[%s output] %s%s\n", output.Type, output.Text, output.EName)
+		}
 		fmt.Println("--")
 	}
 	fmt.Printf("nbformat %d minor %d\n", n.NBFormat, n.NBFormatMinor)
 	return nil
 }
 
-func parseLanguage(l string) (notebook.Language, error) {
-	switch l {
-	case "ja":
-		return notebook.Japanese, nil
-	case "en":
-		return notebook.English, nil
-	case "":
-		return notebook.AnyLanguage, nil
-	default:
-		return notebook.AnyLanguage, fmt.Errorf("unknown language: %s", l)
+func lintCommand() error {
+	n, err := parseNotebook(*input)
+	if err != nil {
+		return &exitError{2, err}
+	}
+	problems := n.Lint()
+	for _, p := range problems {
+		fmt.Printf("%s: %s\n", *input, p)
+	}
+	if len(problems) > 0 {
+		return &exitError{1, fmt.Errorf("found %d problems in %s", len(problems), *input)}
+	}
+	return nil
+}
+
+func languagesCommand() error {
+	n, err := parseNotebook(*input)
+	if err != nil {
+		return err
+	}
+	var langs []notebook.Language
+	if *language != "" {
+		for _, tag := range strings.Split(*language, ",") {
+			l, err := notebook.ParseLanguage(strings.TrimSpace(tag))
+			if err != nil {
+				return err
+			}
+			langs = append(langs, l)
+		}
 	}
+	fmt.Printf("languages: %s\n", joinLanguages(n.Languages()))
+	missing := n.MissingTranslations(langs)
+	for _, m := range missing {
+		var cells []string
+		for _, i := range m.Cells {
+			cells = append(cells, strconv.Itoa(i))
+		}
+		fmt.Printf("%s: cells %s: have %s, missing %s\n", *input,
+			strings.Join(cells, ","), joinLanguages(m.Available), joinLanguages(m.Missing))
+	}
+	if len(missing) > 0 {
+		return &exitError{1, fmt.Errorf("found %d cell groups with missing translations in %s", len(missing), *input)}
+	}
+	return nil
+}
+
+// joinLanguages formats the list of languages as a comma-separated list.
+func joinLanguages(langs []notebook.Language) string {
+	var tags []string
+	for _, l := range langs {
+		tags = append(tags, l.String())
+	}
+	return strings.Join(tags, ",")
 }
 
 func studentCommand() error {
-	l, err := parseLanguage(*language)
+	l, err := notebook.ParseLanguage(*language)
 	if err != nil {
 		return err
 	}
-	n, err := notebook.ParseFile(*input)
+	n, err := parseNotebook(*input)
 	if err != nil {
 		return err
 	}
-	n, err = n.ToStudent(l)
+	n, err = n.ToStudent(l, notebook.Options{DeriveCellIDs: *deriveCellIDs})
 	if err != nil {
 		return err
 	}
-	b, err := n.Marshal()
+	b, err := marshalNotebook(n, *output)
 	if err != nil {
 		return fmt.Errorf("error serializing notebook: %s", err)
 	}
@@ -122,11 +241,11 @@ func studentCommand() error {
 }
 
 func autograderCommand() error {
-	n, err := notebook.ParseFile(*input)
+	n, err := parseNotebook(*input)
 	if err != nil {
 		return err
 	}
-	n, err = n.ToAutograder()
+	n, err = n.ToAutograder(notebook.Options{DeriveCellIDs: *deriveCellIDs})
 	if err != nil {
 		return err
 	}
@@ -156,12 +275,24 @@ func autograderCommand() error {
 		if !ok {
 			return fmt.Errorf("missing or incorrect exercise_id metadata: %v", exerciseID)
 		}
-		dir := filepath.Join(*output, assignmentID, exerciseID)
-		err = os.MkdirAll(dir, 0775)
+		dir := *output
+		if notebook.IsMasterFile(filename) {
+			if *masterOutput == "" {
+				continue
+			}
+			dir = *masterOutput
+		}
+		// The filename may include a subdirectory, e.g. the input/output
+		// test cases are written into cases/.
+		filename = filepath.Join(dir, assignmentID, exerciseID, filename)
+		err = os.MkdirAll(filepath.Dir(filename), 0775)
+		if err != nil {
+			return err
+		}
+		err = ioutil.WriteFile(filename, []byte(source), 0775)
 		if err != nil {
 			return err
 		}
-		err = ioutil.WriteFile(filepath.Join(dir, filename), []byte(source), 0775)
 	}
 	return nil
 }
@@ -7,6 +7,21 @@
 //
 //   go run cmd/grade/grade.go
 //     --autograder_dir ./autograder-scratch-dir
+//
+// With --check_master, it runs the master solutions extracted by the assign tool
+// with --master_output (master_solution.py) through the autograder instead,
+// and exits with an error if any of the tests does not pass on the master
+// solution:
+//
+//   go run cmd/grade/grade.go
+//     --autograder_dir ./autograder-dir
+//     --master_dir ./master-dir
+//     --check_master
+//
+// The files to grade are notebooks or submission envelopes, and the reports
+// are printed as report envelopes, like the worker posts them.
+//
+// On machines without nsjail, use --sandbox bwrap or --sandbox local.
 package main
 
 import (
@@ -17,9 +32,9 @@ import (
 	"log"
 	"os"
 	"path/filepath"
-	"reflect"
 
 	"github.com/google/prog-edu-assistant/autograder"
+	"github.com/google/prog-edu-assistant/envelope"
 )
 
 var (
@@ -27,6 +42,11 @@ var (
 		"The root directory of autograder scripts.")
 	nsjailPath = flag.String("nsjail_path", "/usr/local/bin/nsjail",
 		"The path to nsjail binary.")
+	bwrapPath = flag.String("bwrap_path", "/usr/bin/bwrap",
+		"The path to bubblewrap binary.")
+	sandbox = flag.String("sandbox", "nsjail",
+		"The sandbox to run the tests in: nsjail, bwrap or local. "+
+			"The local sandbox provides no isolation and is only intended for development.")
 	pythonPath = flag.String("python_path", "/usr/bin/python3",
 		"The path to python binary.")
 	scratchDir = flag.String("scratch_dir", "/tmp/autograde",
@@ -36,8 +56,19 @@ var (
 	autoRemove = flag.Bool("auto_remove", false,
 		"If true, removes the scratch directory before creating a new one. "+
 			"This is useful together with --disable_cleanup.")
+	cacheDir = flag.String("cache_dir", "",
+		"The directory to cache the exercise outcomes in, keyed by the hash of "+
+			"the autograder scripts and the submission. If empty, the outcomes are not cached.")
+	cacheMaxBytes = flag.Int64("cache_max_bytes", 1<<30,
+		"The maximum total size of the cached outcomes.")
 	submissionID = flag.String("submission_id", "dummy",
 		"The submission id.")
+	checkMaster = flag.Bool("check_master", false,
+		"If true, runs the master solutions of all exercises in the master directory "+
+			"through the autograder and fails if any test does not pass.")
+	masterDir = flag.String("master_dir", "",
+		"The root directory of the master solutions written by the assign tool "+
+			"with --master_output, used with --check_master.")
 )
 
 func main() {
@@ -48,25 +79,6 @@ func main() {
 	}
 }
 
-func setSubmissionID(b []byte, id string) ([]byte, error) {
-	data := make(map[string]interface{})
-	err := json.Unmarshal(b, &data)
-	if err != nil {
-		return nil, fmt.Errorf("could not parse request as JSON: %s", err)
-	}
-	v, ok := data["metadata"]
-	if !ok {
-		v = make(map[string]interface{})
-		data["metadata"] = v
-	}
-	metadata, ok := v.(map[string]interface{})
-	if !ok {
-		return nil, fmt.Errorf("metadata is not a map, but %s", reflect.TypeOf(v))
-	}
-	metadata["submission_id"] = id
-	return json.Marshal(data)
-}
-
 func run() error {
 	if *autograderDir == "" {
 		return fmt.Errorf("please specify --autograder_dir")
@@ -84,20 +96,58 @@ func run() error {
 	ag.ScratchDir = *scratchDir
 	ag.NSJailPath = *nsjailPath
 	ag.PythonPath = *pythonPath
+	sb, err := autograder.NewSandbox(*sandbox, *nsjailPath, *bwrapPath)
+	if err != nil {
+		return err
+	}
+	ag.Sandbox = sb
 	ag.DisableCleanup = *disableCleanup
 	ag.AutoRemove = *autoRemove
+	if *cacheDir != "" {
+		ag.Cache, err = autograder.NewCache(*cacheDir, *cacheMaxBytes)
+		if err != nil {
+			return err
+		}
+	}
+	if *checkMaster {
+		if *masterDir == "" {
+			return fmt.Errorf("please specify --master_dir")
+		}
+		ag.MasterDir = *masterDir
+		problems, err := ag.CheckMasterSolutions()
+		if err != nil {
+			return err
+		}
+		for _, problem := range problems {
+			fmt.Println(problem)
+		}
+		if len(problems) > 0 {
+			return fmt.Errorf("found %d problems with master solutions", len(problems))
+		}
+		return nil
+	}
 	for _, filename := range flag.Args() {
 		b, err := ioutil.ReadFile(filename)
 		if err != nil {
 			return fmt.Errorf("error reading %q: %s", filename, err)
 		}
-		b, err = setSubmissionID(b, *submissionID)
+		// The submission is validated after setting the submission ID.
+		s, err := envelope.ParseSubmission(b)
+		if s == nil {
+			return fmt.Errorf("error reading %q: %s", filename, err)
+		}
+		s.SubmissionID = *submissionID
+		err = s.Validate()
 		if err != nil {
-			return fmt.Errorf("error setting submission_id: %s", err)
+			return fmt.Errorf("error reading %q: %s", filename, err)
+		}
+		r := ag.GradeSubmission(s)
+		if r.Error != "" {
+			return fmt.Errorf("error grading %q: %s", filename, r.Error)
 		}
-		report, err := ag.Grade(b)
+		report, err := json.MarshalIndent(r, "", "  ")
 		if err != nil {
-			return fmt.Errorf("error grading %q: %s", filename, err)
+			return err
 		}
 		fmt.Println(string(report))
 	}
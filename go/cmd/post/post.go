@@ -1,5 +1,8 @@
 // Binary post is for sending notebooks for grading to the worker daemon
-// via a message queue.
+// via a message queue. The notebooks are wrapped into the submission
+// envelopes, with new submission IDs unless the notebook metadata has
+// the submission_id. The report is written by the upload server to
+// <submission_id>.txt in its upload directory.
 //
 // Usage:
 //
@@ -15,7 +18,9 @@ import (
 	"os"
 	"path/filepath"
 
+	"github.com/google/prog-edu-assistant/envelope"
 	"github.com/google/prog-edu-assistant/queue"
+	"github.com/google/uuid"
 )
 
 var (
@@ -23,6 +28,10 @@ var (
 		"The spec of the message queue to connect to.")
 	autograderQueue = flag.String("autograder_queue", "autograde",
 		"The name of the autograder queue to post the work requests.")
+	userHash = flag.String("user_hash", "",
+		"The user hash to post the submissions with, if not in the notebook metadata.")
+	contentEncoding = flag.String("content_encoding", envelope.EncodingJSON,
+		"The encoding of the notebooks in the envelopes: json, base64 or gzip.")
 )
 
 func main() {
@@ -51,10 +60,30 @@ func run() error {
 		if err != nil {
 			return fmt.Errorf("error reading %q: %s", filename, err)
 		}
+		s, err := envelope.FromNotebook(b)
+		if err != nil {
+			return fmt.Errorf("error reading %q: %s", filename, err)
+		}
+		if s.SubmissionID == "" {
+			s.SubmissionID = uuid.New().String()
+		}
+		if s.UserHash == "" {
+			s.UserHash = *userHash
+		}
+		s.TraceID = uuid.New().String()
+		err = s.SetContent(b, *contentEncoding)
+		if err != nil {
+			return err
+		}
+		b, err = s.Marshal()
+		if err != nil {
+			return fmt.Errorf("error posting %q: %s", filename, err)
+		}
 		err = q.Post(*autograderQueue, b)
 		if err != nil {
 			return fmt.Errorf("error posting to %q: %s", *autograderQueue, err)
 		}
+		fmt.Printf("%s\t%s\n", s.SubmissionID, filename)
 	}
 	return nil
 }
@@ -1,6 +1,7 @@
 // Binary try-queue is for quick testing of the queue.
-// It expects a default installation of RabbitMQ to be running
-// on the default port (5672). The purpose of the tool
+// By default it expects a default installation of RabbitMQ to be running
+// on the default port (5672). With --spec dir:///path/to/spool it uses
+// the spool directory instead. The purpose of the tool
 // is to make it possible for people who are new to message queues
 // to play with the message queue and try it out for themselves.
 //
@@ -62,7 +63,7 @@ func run() error {
 	return cmd.Func()
 }
 
-var channel *queue.Channel
+var channel queue.Queue
 
 func initQueue() error {
 	var err error
@@ -87,9 +88,9 @@ func receiveCommand() error {
 	if err != nil {
 		return err
 	}
-	b := <-ch
-	fmt.Printf("Received: %q\n", string(b))
-	return nil
+	d := <-ch
+	fmt.Printf("Received: %q\n", string(d.Body))
+	return channel.Ack(d)
 }
 
 func listenCommand() error {
@@ -101,8 +102,12 @@ func listenCommand() error {
 	if err != nil {
 		return err
 	}
-	for b := range ch {
-		fmt.Printf("Received: %q\n", string(b))
+	for d := range ch {
+		fmt.Printf("Received: %q\n", string(d.Body))
+		err := channel.Ack(d)
+		if err != nil {
+			return err
+		}
 	}
 	return nil
 }
@@ -0,0 +1,353 @@
+// Package envelope defines the messages passed over the queue between
+// the upload server and the autograder workers: the submissions posted
+// for grading and the reports posted back.
+//
+// A message is a JSON object with the header fields of Envelope, which
+// identify the submission, and the payload: the submitted notebook or
+// the grading report. The version of the format is checked on parsing,
+// so that the messages of an unsupported version are rejected rather
+// than misinterpreted.
+package envelope
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"regexp"
+	"time"
+)
+
+// Version is the current version of the envelope format.
+const Version = 1
+
+// The types of the messages.
+const (
+	TypeSubmission = "submission"
+	TypeReport     = "report"
+)
+
+// The content encodings of the payload.
+const (
+	// EncodingJSON embeds the payload, which must be valid JSON, as is.
+	// The payload is compacted by Marshal.
+	EncodingJSON = "json"
+	// EncodingBase64 stores the payload as a base64 string.
+	EncodingBase64 = "base64"
+	// EncodingGzip stores the gzip-compressed payload as a base64 string.
+	EncodingGzip = "gzip"
+)
+
+// Envelope holds the fields common to the submissions and the reports.
+type Envelope struct {
+	// Version is the version of the envelope format, see Version.
+	Version int `json:"version"`
+	// Type is TypeSubmission or TypeReport.
+	Type string `json:"type"`
+	// SubmissionID identifies the submission. It is used in file names.
+	SubmissionID string `json:"submission_id"`
+	// UserHash is the hash of the user id of the submitter, if known.
+	UserHash string `json:"user_hash,omitempty"`
+	// AssignmentID selects the autograder scripts to grade the submission.
+	AssignmentID string `json:"assignment_id,omitempty"`
+	// SubmittedAt is when the submission was received.
+	SubmittedAt time.Time `json:"submitted_at"`
+	// Attempt is the number of the grading attempt, starting from 1.
+	// The reports count the retries of the submission in the queue.
+	Attempt int `json:"attempt"`
+	// TraceID identifies the upload in the logs of the server and the workers.
+	TraceID string `json:"trace_id,omitempty"`
+	// ContentEncoding is the encoding of the payload, e.g. EncodingJSON.
+	ContentEncoding string `json:"content_encoding,omitempty"`
+	// Payload is the encoded content, see Content.
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// Submission is the message posted for grading. The content is the
+// submitted notebook.
+type Submission struct {
+	Envelope
+}
+
+// Report is the message posted back with the result of grading.
+// The content is the report JSON produced by the autograder, unless
+// the submission could not be graded.
+type Report struct {
+	Envelope
+	// GradedAt is when the grading finished.
+	GradedAt time.Time `json:"graded_at"`
+	// Error describes why the submission could not be graded, if it could not.
+	// The report then has no content.
+	Error string `json:"error,omitempty"`
+}
+
+// idRegex matches the submission and assignment IDs, which are used
+// as file and directory names.
+var idRegex = regexp.MustCompile(`^[A-Za-z0-9_-][A-Za-z0-9._-]*$`)
+
+// NewSubmission returns a submission with the given ID, submitted now.
+// The caller should set the other fields and the content.
+func NewSubmission(submissionID string) *Submission {
+	return &Submission{Envelope{
+		Version:      Version,
+		Type:         TypeSubmission,
+		SubmissionID: submissionID,
+		SubmittedAt:  time.Now().UTC(),
+		Attempt:      1,
+	}}
+}
+
+// FromNotebook returns the submission of the notebook. The submission,
+// assignment and user IDs are taken from the notebook metadata, if present.
+func FromNotebook(b []byte) (*Submission, error) {
+	data := make(map[string]interface{})
+	err := json.Unmarshal(b, &data)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse notebook as JSON: %s", err)
+	}
+	s := NewSubmission("")
+	if v, ok := data["metadata"]; ok {
+		metadata, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("metadata is not a map, but %s", reflect.TypeOf(v))
+		}
+		for key, field := range map[string]*string{
+			"submission_id": &s.SubmissionID,
+			"assignment_id": &s.AssignmentID,
+			"user_hash":     &s.UserHash,
+		} {
+			v, ok := metadata[key]
+			if !ok {
+				continue
+			}
+			*field, ok = v.(string)
+			if !ok {
+				return nil, fmt.Errorf("metadata.%s is not a string but %s", key, reflect.TypeOf(v))
+			}
+		}
+	}
+	err = s.SetContent(b, EncodingJSON)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// ParseSubmission parses and validates the submission. The messages without
+// a version, which are the bare notebooks posted by the older versions of
+// the server, are converted by FromNotebook. If the submission was parsed,
+// but is not valid, it is returned together with the error, so that
+// the error can be reported back by the submission ID.
+func ParseSubmission(b []byte) (*Submission, error) {
+	s := new(Submission)
+	versioned, err := parse(b, s)
+	if err != nil {
+		return nil, err
+	}
+	if !versioned {
+		s, err = FromNotebook(b)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return s, s.Validate()
+}
+
+// Validate checks that the submission can be graded.
+func (s *Submission) Validate() error {
+	err := s.validate(TypeSubmission)
+	if err != nil {
+		return err
+	}
+	if !idRegex.MatchString(s.AssignmentID) {
+		return fmt.Errorf("bad assignment_id %q", s.AssignmentID)
+	}
+	if len(s.Payload) == 0 {
+		return fmt.Errorf("submission %s has no payload", s.SubmissionID)
+	}
+	return nil
+}
+
+// Marshal validates the submission and serializes it to JSON.
+func (s *Submission) Marshal() ([]byte, error) {
+	err := s.Validate()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(s)
+}
+
+// NewReport returns the report for the submission, graded now.
+// The caller should set either the content or the error.
+func NewReport(s *Submission) *Report {
+	r := &Report{
+		Envelope: s.Envelope,
+		GradedAt: time.Now().UTC(),
+	}
+	r.Type = TypeReport
+	r.ContentEncoding = ""
+	r.Payload = nil
+	return r
+}
+
+// ParseReport parses and validates the report. The messages without
+// a version, which are the bare reports posted by the older versions of
+// the worker, are converted to the reports with that content.
+func ParseReport(b []byte) (*Report, error) {
+	r := new(Report)
+	versioned, err := parse(b, r)
+	if err != nil {
+		return nil, err
+	}
+	if !versioned {
+		data := make(map[string]interface{})
+		err = json.Unmarshal(b, &data)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse report as JSON: %s", err)
+		}
+		r = &Report{Envelope: Envelope{Version: Version, Type: TypeReport, Attempt: 1}}
+		for key, field := range map[string]*string{
+			"submission_id": &r.SubmissionID,
+			"assignment_id": &r.AssignmentID,
+			"user_hash":     &r.UserHash,
+		} {
+			if v, ok := data[key].(string); ok {
+				*field = v
+			}
+		}
+		err = r.SetContent(b, EncodingJSON)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return r, r.Validate()
+}
+
+// Validate checks that the report can be delivered to the user.
+func (r *Report) Validate() error {
+	err := r.validate(TypeReport)
+	if err != nil {
+		return err
+	}
+	if r.Error == "" && len(r.Payload) == 0 {
+		return fmt.Errorf("report for submission %s has neither payload nor error", r.SubmissionID)
+	}
+	return nil
+}
+
+// Marshal validates the report and serializes it to JSON.
+func (r *Report) Marshal() ([]byte, error) {
+	err := r.Validate()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(r)
+}
+
+// parse parses the message into v, unless it has no version field.
+// It returns whether the message has the version.
+func parse(b []byte, v interface{}) (bool, error) {
+	fields := make(map[string]json.RawMessage)
+	err := json.Unmarshal(b, &fields)
+	if err != nil {
+		return false, fmt.Errorf("could not parse message as JSON: %s", err)
+	}
+	if _, ok := fields["version"]; !ok {
+		return false, nil
+	}
+	err = json.Unmarshal(b, v)
+	if err != nil {
+		return false, fmt.Errorf("could not parse message: %s", err)
+	}
+	return true, nil
+}
+
+// validate checks the header fields of the message of the given type.
+func (e *Envelope) validate(typ string) error {
+	if e.Version < 1 || e.Version > Version {
+		return fmt.Errorf("unsupported message version %d, want 1 to %d", e.Version, Version)
+	}
+	if e.Type != typ {
+		return fmt.Errorf("message type is %q, want %q", e.Type, typ)
+	}
+	if !idRegex.MatchString(e.SubmissionID) {
+		return fmt.Errorf("bad submission_id %q", e.SubmissionID)
+	}
+	if e.Attempt < 1 {
+		return fmt.Errorf("submission %s: attempt is %d, want at least 1", e.SubmissionID, e.Attempt)
+	}
+	if len(e.Payload) == 0 {
+		return nil
+	}
+	switch e.ContentEncoding {
+	case EncodingJSON, EncodingBase64, EncodingGzip:
+	default:
+		return fmt.Errorf("submission %s: unsupported content encoding %q", e.SubmissionID, e.ContentEncoding)
+	}
+	return nil
+}
+
+// SetContent encodes the content into the payload.
+func (e *Envelope) SetContent(content []byte, encoding string) error {
+	switch encoding {
+	case EncodingJSON:
+		if !json.Valid(content) {
+			return fmt.Errorf("content is not valid JSON")
+		}
+		e.Payload = append(json.RawMessage(nil), content...)
+	case EncodingBase64, EncodingGzip:
+		if encoding == EncodingGzip {
+			var buf bytes.Buffer
+			w := gzip.NewWriter(&buf)
+			w.Write(content)
+			err := w.Close()
+			if err != nil {
+				return err
+			}
+			content = buf.Bytes()
+		}
+		b, err := json.Marshal(base64.StdEncoding.EncodeToString(content))
+		if err != nil {
+			return err
+		}
+		e.Payload = b
+	default:
+		return fmt.Errorf("unsupported content encoding %q", encoding)
+	}
+	e.ContentEncoding = encoding
+	return nil
+}
+
+// Content decodes the payload.
+func (e *Envelope) Content() ([]byte, error) {
+	if e.ContentEncoding == EncodingJSON {
+		return []byte(e.Payload), nil
+	}
+	var s string
+	err := json.Unmarshal(e.Payload, &s)
+	if err != nil {
+		return nil, fmt.Errorf("payload in %q encoding is not a string: %s", e.ContentEncoding, err)
+	}
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding payload: %s", err)
+	}
+	switch e.ContentEncoding {
+	case EncodingBase64:
+		return b, nil
+	case EncodingGzip:
+		r, err := gzip.NewReader(bytes.NewReader(b))
+		if err != nil {
+			return nil, fmt.Errorf("error decompressing payload: %s", err)
+		}
+		b, err = ioutil.ReadAll(r)
+		if err != nil {
+			return nil, fmt.Errorf("error decompressing payload: %s", err)
+		}
+		return b, nil
+	}
+	return nil, fmt.Errorf("unsupported content encoding %q", e.ContentEncoding)
+}
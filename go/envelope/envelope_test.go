@@ -0,0 +1,176 @@
+package envelope
+
+import (
+	"strings"
+	"testing"
+)
+
+// testNotebook is compact, as the JSON payload is compacted by Marshal.
+const testNotebook = `{"metadata":{"assignment_id":"hello","submission_id":"s1","user_hash":"u1"},"cells":[]}`
+
+func TestContent(t *testing.T) {
+	for _, encoding := range []string{EncodingJSON, EncodingBase64, EncodingGzip} {
+		s := NewSubmission("s1")
+		s.AssignmentID = "hello"
+		err := s.SetContent([]byte(testNotebook), encoding)
+		if err != nil {
+			t.Fatalf("SetContent(%s) returned error %s", encoding, err)
+		}
+		b, err := s.Marshal()
+		if err != nil {
+			t.Fatalf("Marshal(%s) returned error %s", encoding, err)
+		}
+		got, err := ParseSubmission(b)
+		if err != nil {
+			t.Fatalf("ParseSubmission(%s) returned error %s", encoding, err)
+		}
+		content, err := got.Content()
+		if err != nil {
+			t.Fatalf("Content(%s) returned error %s", encoding, err)
+		}
+		if string(content) != testNotebook {
+			t.Errorf("Content(%s) = %q, want %q", encoding, string(content), testNotebook)
+		}
+		if got.ContentEncoding != encoding || got.SubmittedAt.IsZero() || got.Attempt != 1 {
+			t.Errorf("ParseSubmission(%s) = %+v", encoding, got.Envelope)
+		}
+	}
+	s := NewSubmission("s1")
+	if err := s.SetContent([]byte("not json"), EncodingJSON); err == nil {
+		t.Errorf("SetContent of invalid JSON returned success, want error")
+	}
+	if err := s.SetContent([]byte("{}"), "zip"); err == nil {
+		t.Errorf("SetContent with unknown encoding returned success, want error")
+	}
+}
+
+func TestParseSubmission(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		// want is the submission ID, if the submission is returned.
+		want string
+		// wantErr is the substring of the error, if any.
+		wantErr string
+	}{
+		{
+			name:  "Envelope",
+			input: `{"version": 1, "type": "submission", "submission_id": "s1", "assignment_id": "hello", "attempt": 1, "content_encoding": "json", "payload": {}}`,
+			want:  "s1",
+		},
+		{
+			name:  "Legacy",
+			input: testNotebook,
+			want:  "s1",
+		},
+		{
+			name:    "NotJSON",
+			input:   `<html>`,
+			wantErr: "could not parse",
+		},
+		{
+			name:    "FutureVersion",
+			input:   `{"version": 2, "type": "submission", "submission_id": "s1", "assignment_id": "hello", "attempt": 1}`,
+			want:    "s1",
+			wantErr: "unsupported message version 2",
+		},
+		{
+			name:    "Report",
+			input:   `{"version": 1, "type": "report", "submission_id": "s1", "attempt": 1, "error": "failed"}`,
+			want:    "s1",
+			wantErr: `message type is "report"`,
+		},
+		{
+			name:    "BadSubmissionID",
+			input:   `{"version": 1, "type": "submission", "submission_id": "../s1", "assignment_id": "hello", "attempt": 1, "content_encoding": "json", "payload": {}}`,
+			want:    "../s1",
+			wantErr: "bad submission_id",
+		},
+		{
+			name:    "NoAssignmentID",
+			input:   `{"metadata": {"submission_id": "s1"}, "cells": []}`,
+			want:    "s1",
+			wantErr: "bad assignment_id",
+		},
+		{
+			name:    "NoPayload",
+			input:   `{"version": 1, "type": "submission", "submission_id": "s1", "assignment_id": "hello", "attempt": 1}`,
+			want:    "s1",
+			wantErr: "no payload",
+		},
+		{
+			name:    "BadEncoding",
+			input:   `{"version": 1, "type": "submission", "submission_id": "s1", "assignment_id": "hello", "attempt": 1, "content_encoding": "zip", "payload": "x"}`,
+			want:    "s1",
+			wantErr: "unsupported content encoding",
+		},
+		{
+			name:    "NoAttempt",
+			input:   `{"version": 1, "type": "submission", "submission_id": "s1", "assignment_id": "hello", "content_encoding": "json", "payload": {}}`,
+			want:    "s1",
+			wantErr: "attempt is 0",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := ParseSubmission([]byte(tt.input))
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("ParseSubmission returned error %s, want success", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("ParseSubmission returned error %v, want %q", err, tt.wantErr)
+			}
+			got := ""
+			if s != nil {
+				got = s.SubmissionID
+			}
+			if got != tt.want {
+				t.Errorf("ParseSubmission returned submission %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReport(t *testing.T) {
+	s, err := ParseSubmission([]byte(testNotebook))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.TraceID = "t1"
+	r := NewReport(s)
+	if _, err := r.Marshal(); err == nil {
+		t.Errorf("Marshal of the report without content returned success, want error")
+	}
+	err = r.SetContent([]byte(`{"points_earned": 1}`), EncodingJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Attempt = 2
+	b, err := r.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ParseReport(b)
+	if err != nil {
+		t.Fatalf("ParseReport returned error %s, want success", err)
+	}
+	if got.Type != TypeReport || got.SubmissionID != "s1" || got.AssignmentID != "hello" ||
+		got.UserHash != "u1" || got.TraceID != "t1" || got.Attempt != 2 || got.GradedAt.IsZero() {
+		t.Errorf("ParseReport = %+v", got)
+	}
+	if _, err := ParseSubmission(b); err == nil {
+		t.Errorf("ParseSubmission of the report returned success, want error")
+	}
+	// The reports of the older workers are converted.
+	legacy := `{"submission_id": "s2", "points_earned": 1}`
+	got, err = ParseReport([]byte(legacy))
+	if err != nil {
+		t.Fatalf("ParseReport of the legacy report returned error %s, want success", err)
+	}
+	if content, err := got.Content(); err != nil || got.SubmissionID != "s2" || string(content) != legacy {
+		t.Errorf("ParseReport of the legacy report = %+v, content %q (err %v)", got, string(content), err)
+	}
+	if _, err := ParseReport([]byte(`{"points_earned": 1}`)); err == nil {
+		t.Errorf("ParseReport of the report without submission_id returned success, want error")
+	}
+}
@@ -0,0 +1,114 @@
+package notebook
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// The keys of the static constraints on the submitted code in the exercise
+// metadata. The autograder checks them on the syntax tree of the submission
+// before running any tests, e.g.
+//
+//   forbidden_names: [eval, exec]
+//   forbidden_imports: [numpy]
+//   required_constructs: [for]
+//   max_lines: 10
+//   required_functions: ["hello(name)"]
+//
+// * forbidden_names: the names that must not be used, e.g. built-in functions,
+//   including the attribute names, e.g. sort.
+// * forbidden_imports: the modules that must not be imported.
+// * required_constructs: the constructs that the code must use, see Constructs.
+// * max_lines: the maximum number of lines that are not empty or comments.
+// * required_functions: the functions that must be defined at the top level,
+//   with the signatures given as name(param1, param2=default, *args, **kwargs).
+//   Only the parameter names and kinds are checked.
+const (
+	ForbiddenNamesKey     = "forbidden_names"
+	ForbiddenImportsKey   = "forbidden_imports"
+	RequiredConstructsKey = "required_constructs"
+	MaxLinesKey           = "max_lines"
+	RequiredFunctionsKey  = "required_functions"
+)
+
+// Constructs are the names of the constructs accepted in required_constructs,
+// in sorted order.
+var Constructs = []string{
+	"class", "comprehension", "def", "for", "if", "lambda", "loop",
+	"recursion", "try", "while", "with",
+}
+
+var (
+	moduleRegex    = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*(\.[a-zA-Z_][a-zA-Z0-9_]*)*$`)
+	signatureRegex = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*\(.*\)$`)
+)
+
+// stringList converts the metadata value to a list of strings.
+func stringList(key string, v interface{}) ([]string, error) {
+	list, ok := v.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("bad %s: want a list, got %v", key, v)
+	}
+	var ret []string
+	for _, x := range list {
+		s, ok := x.(string)
+		if !ok {
+			return nil, fmt.Errorf("bad %s: want a list of strings, got %s in the list", key, reflect.TypeOf(x))
+		}
+		ret = append(ret, strings.TrimSpace(s))
+	}
+	return ret, nil
+}
+
+// ExerciseConstraints extracts the static constraints from the exercise
+// metadata and validates them. It returns nil if the metadata does not
+// declare any constraints.
+func ExerciseConstraints(metadata map[string]interface{}) (map[string]interface{}, error) {
+	var constraints map[string]interface{}
+	set := func(key string, value interface{}) {
+		if constraints == nil {
+			constraints = make(map[string]interface{})
+		}
+		constraints[key] = value
+	}
+	lists := []struct {
+		key   string
+		valid func(string) bool
+		want  string
+	}{
+		{ForbiddenNamesKey, identifierRegex.MatchString, "a name"},
+		{ForbiddenImportsKey, moduleRegex.MatchString, "a module name"},
+		{RequiredConstructsKey, func(s string) bool {
+			i := sort.SearchStrings(Constructs, s)
+			return i < len(Constructs) && Constructs[i] == s
+		}, "one of " + strings.Join(Constructs, ", ")},
+		{RequiredFunctionsKey, signatureRegex.MatchString, "a signature such as name(a, b)"},
+	}
+	for _, l := range lists {
+		v, ok := metadata[l.key]
+		if !ok {
+			continue
+		}
+		values, err := stringList(l.key, v)
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range values {
+			if !l.valid(s) {
+				return nil, fmt.Errorf("bad %s: %q is not %s", l.key, s, l.want)
+			}
+		}
+		set(l.key, values)
+	}
+	if v, ok := metadata[MaxLinesKey]; ok {
+		n, ok := v.(int)
+		if !ok || n <= 0 {
+			return nil, fmt.Errorf("bad %s: want a positive integer, got %v", MaxLinesKey, v)
+		}
+		set(MaxLinesKey, n)
+	}
+	return constraints, nil
+}
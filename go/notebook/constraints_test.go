@@ -0,0 +1,79 @@
+package notebook
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExerciseConstraints(t *testing.T) {
+	tests := []struct {
+		name     string
+		metadata map[string]interface{}
+		want     map[string]interface{}
+		wantErr  bool
+	}{
+		{
+			name:     "None",
+			metadata: map[string]interface{}{"exercise_id": "A"},
+		},
+		{
+			name: "All",
+			metadata: map[string]interface{}{
+				"forbidden_names":     []interface{}{"eval", "exec"},
+				"forbidden_imports":   []interface{}{"numpy", "os.path"},
+				"required_constructs": []interface{}{"for"},
+				"max_lines":           10,
+				"required_functions":  []interface{}{"hello(name, *args, greeting='Hi')"},
+			},
+			want: map[string]interface{}{
+				"forbidden_names":     []string{"eval", "exec"},
+				"forbidden_imports":   []string{"numpy", "os.path"},
+				"required_constructs": []string{"for"},
+				"max_lines":           10,
+				"required_functions":  []string{"hello(name, *args, greeting='Hi')"},
+			},
+		},
+		{
+			name:     "NotAList",
+			metadata: map[string]interface{}{"forbidden_names": "eval"},
+			wantErr:  true,
+		},
+		{
+			name:     "BadName",
+			metadata: map[string]interface{}{"forbidden_names": []interface{}{"not a name"}},
+			wantErr:  true,
+		},
+		{
+			name:     "UnknownConstruct",
+			metadata: map[string]interface{}{"required_constructs": []interface{}{"goto"}},
+			wantErr:  true,
+		},
+		{
+			name:     "BadMaxLines",
+			metadata: map[string]interface{}{"max_lines": 0},
+			wantErr:  true,
+		},
+		{
+			name:     "BadSignature",
+			metadata: map[string]interface{}{"required_functions": []interface{}{"hello"}},
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ExerciseConstraints(tt.metadata)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("ExerciseConstraints(%v) returned %v, want error", tt.metadata, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ExerciseConstraints(%v) returned error %s, want success", tt.metadata, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ExerciseConstraints(%v) = %v, want %v", tt.metadata, got, tt.want)
+			}
+		})
+	}
+}
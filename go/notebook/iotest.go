@@ -0,0 +1,87 @@
+package notebook
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// The input/output tests are declared with the %%iotest cell magic. The cell
+// contains the input given to the submitted program on stdin, followed by
+// the # OUTPUT marker and the expected output:
+//
+//   %%iotest Sum
+//   1 2
+//   # OUTPUT
+//   3
+//
+// ToAutograder writes the input and the expected output of the test into
+// IOTestDir/Sum.in and IOTestDir/Sum.out in the exercise directory.
+
+// IOTestDir is the subdirectory of the exercise directory of the autograder
+// that contains the input/output test cases.
+const IOTestDir = "cases"
+
+// The keys of the output comparison options of the input/output tests
+// in the exercise metadata. By default the outputs are compared exactly,
+// except for the trailing whitespace of the lines and the trailing empty lines.
+// * iotest_ignore_case: compare the outputs case-insensitively.
+// * iotest_ignore_whitespace: treat all runs of whitespace, including
+//   the line breaks, as a single space.
+// * iotest_float_tolerance: compare the numbers with the given absolute tolerance.
+const (
+	IOTestIgnoreCaseKey       = "iotest_ignore_case"
+	IOTestIgnoreWhitespaceKey = "iotest_ignore_whitespace"
+	IOTestFloatToleranceKey   = "iotest_float_tolerance"
+)
+
+var (
+	ioTestRegex       = regexp.MustCompile("(?ms)^[ \t]*#? ?%%iotest(?:[ \t]+([a-zA-Z][a-zA-Z0-9_]*))[ \t]*\n?")
+	ioTestOutputRegex = regexp.MustCompile("(?m)^[ \t]*# OUTPUT[ \t]*(?:\n|$)")
+)
+
+// splitIOTest splits the body of the %%iotest cell into the input
+// and the expected output.
+func splitIOTest(body string) (input, output string, err error) {
+	m := ioTestOutputRegex.FindStringIndex(body)
+	if m == nil {
+		return "", "", fmt.Errorf("%%%%iotest cell has no # OUTPUT marker")
+	}
+	return body[:m[0]], body[m[1]:], nil
+}
+
+// ioTestOptions validates the output comparison options of the input/output
+// tests in the exercise metadata and returns them, or nil if there are none.
+func ioTestOptions(metadata map[string]interface{}) (map[string]interface{}, error) {
+	var options map[string]interface{}
+	set := func(key string, value interface{}) {
+		if options == nil {
+			options = make(map[string]interface{})
+		}
+		options[key] = value
+	}
+	for _, key := range []string{IOTestIgnoreCaseKey, IOTestIgnoreWhitespaceKey} {
+		if v, ok := metadata[key]; ok {
+			b, ok := v.(bool)
+			if !ok {
+				return nil, fmt.Errorf("bad %s: want true or false, got %v", key, v)
+			}
+			set(key, b)
+		}
+	}
+	if v, ok := metadata[IOTestFloatToleranceKey]; ok {
+		var tolerance float64
+		switch v := v.(type) {
+		case int:
+			tolerance = float64(v)
+		case float64:
+			tolerance = v
+		default:
+			return nil, fmt.Errorf("bad %s: want a number, got %v", IOTestFloatToleranceKey, v)
+		}
+		if tolerance < 0 {
+			return nil, fmt.Errorf("bad %s: must not be negative, got %v", IOTestFloatToleranceKey, v)
+		}
+		set(IOTestFloatToleranceKey, tolerance)
+	}
+	return options, nil
+}
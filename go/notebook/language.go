@@ -0,0 +1,272 @@
+package notebook
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// This file implements the selection of natural language variants of cells.
+// A markdown cell is marked as written in a language with a **lang:xx** marker,
+// where xx is a BCP-47 language tag such as "en", "ja" or "pt-BR". Consecutive
+// marked cells are variants of the same text in different languages, and
+// a cell can also contain several variants, each starting at its marker:
+//
+//   **lang:en** Hello!
+//   **lang:pt-BR** Olá!
+//
+// When producing a student notebook for a language, only the variants in the
+// best available language are kept, following the fallback chain of the
+// language, e.g. pt-BR, then pt, then en.
+
+// Language is a natural language identified by a BCP-47 language tag
+// in the canonical form, e.g. "en", "ja" or "pt-BR".
+type Language string
+
+const (
+	// AnyLanguage keeps all language variants.
+	AnyLanguage Language = ""
+	English     Language = "en"
+	Japanese    Language = "ja"
+	// DefaultLanguage is the last language in every fallback chain.
+	DefaultLanguage = English
+	// unavailable is assigned to the cells whose variants are all in languages
+	// outside of the fallback chain. It is not a valid language tag.
+	unavailable Language = "-"
+)
+
+var (
+	languageMetadataRegex = regexp.MustCompile(`\*\*lang:([a-zA-Z]{2,8}(?:[-_][a-zA-Z0-9]{1,8})*)\*\*`)
+	languageTagRegex      = regexp.MustCompile(`^[a-zA-Z]{2,8}(?:[-_][a-zA-Z0-9]{1,8})*$`)
+)
+
+// ParseLanguage parses a BCP-47 language tag and returns it in the canonical
+// form: language in lower case, script in title case and region in upper case,
+// e.g. "pt-br" becomes "pt-BR" and "zh_hant_tw" becomes "zh-Hant-TW".
+// An empty string is parsed as AnyLanguage.
+func ParseLanguage(tag string) (Language, error) {
+	if tag == "" {
+		return AnyLanguage, nil
+	}
+	if !languageTagRegex.MatchString(tag) {
+		return AnyLanguage, fmt.Errorf("invalid language tag %q", tag)
+	}
+	subtags := strings.FieldsFunc(tag, func(r rune) bool { return r == '-' || r == '_' })
+	for i, subtag := range subtags {
+		subtag = strings.ToLower(subtag)
+		switch {
+		case i == 0:
+		case len(subtag) == 4 && isAlpha(subtag):
+			// Script, e.g. Hant.
+			subtag = strings.ToUpper(subtag[:1]) + subtag[1:]
+		case len(subtag) == 2 && isAlpha(subtag) || len(subtag) == 3 && !isAlpha(subtag):
+			// Region, e.g. BR or 419.
+			subtag = strings.ToUpper(subtag)
+		}
+		subtags[i] = subtag
+	}
+	return Language(strings.Join(subtags, "-")), nil
+}
+
+// isAlpha returns true if the string consists of ASCII letters only.
+func isAlpha(s string) bool {
+	for _, r := range s {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z') {
+			return false
+		}
+	}
+	return true
+}
+
+func (l Language) String() string {
+	return string(l)
+}
+
+// Fallbacks returns the chain of languages to try when looking for a variant
+// in the language: the language itself, the language tag with the trailing
+// subtags removed one by one, and finally DefaultLanguage. For example,
+// the chain of pt-BR is pt-BR, pt, en.
+func (l Language) Fallbacks() []Language {
+	if l == AnyLanguage {
+		return nil
+	}
+	var chain []Language
+	subtags := strings.Split(string(l), "-")
+	for i := len(subtags); i > 0; i-- {
+		chain = append(chain, Language(strings.Join(subtags[:i], "-")))
+	}
+	if chain[len(chain)-1] != DefaultLanguage {
+		chain = append(chain, DefaultLanguage)
+	}
+	return chain
+}
+
+// languageVariant is a part of the cell source written in one language.
+type languageVariant struct {
+	lang Language
+	// text is the source with the language marker removed.
+	text string
+}
+
+// splitVariants splits the cell source into language variants, each starting
+// at a language marker. The text before the first marker belongs to the first
+// variant. It returns nil if the source has no language markers.
+func splitVariants(source string) []languageVariant {
+	mm := languageMetadataRegex.FindAllStringSubmatchIndex(source, -1)
+	var variants []languageVariant
+	for i, m := range mm {
+		start := m[0]
+		if i == 0 {
+			start = 0
+		}
+		end := len(source)
+		if i < len(mm)-1 {
+			end = mm[i+1][0]
+		}
+		// Tags in markers are canonicalized, the regex only allows valid tags.
+		lang, _ := ParseLanguage(source[m[2]:m[3]])
+		variants = append(variants, languageVariant{
+			lang: lang,
+			text: source[start:m[0]] + source[m[1]:end],
+		})
+	}
+	return variants
+}
+
+// filterVariants returns the cell source with only the variants in the given
+// language, or the source of all variants if the language is AnyLanguage.
+// The language markers are removed. The source without markers is returned as is.
+func filterVariants(source string, lang Language) string {
+	variants := splitVariants(source)
+	if variants == nil {
+		return source
+	}
+	var parts []string
+	for _, v := range variants {
+		if lang == AnyLanguage || v.lang == lang {
+			parts = append(parts, v.text)
+		}
+	}
+	return strings.Join(parts, "")
+}
+
+// variantGroups returns the groups of consecutive markdown cells that have
+// language markers, as lists of cell indices.
+func (n *Notebook) variantGroups() [][]int {
+	var groups [][]int
+	var group []int
+	for i, cell := range n.Cells {
+		if cell.Type == "markdown" && languageMetadataRegex.MatchString(cell.Source) {
+			group = append(group, i)
+			continue
+		}
+		if group != nil {
+			groups = append(groups, group)
+			group = nil
+		}
+	}
+	if group != nil {
+		groups = append(groups, group)
+	}
+	return groups
+}
+
+// groupLanguages returns the sorted list of languages of the variants in the group of cells.
+func (n *Notebook) groupLanguages(group []int) []Language {
+	seen := make(map[Language]bool)
+	var langs []Language
+	for _, i := range group {
+		for _, v := range splitVariants(n.Cells[i].Source) {
+			if !seen[v.lang] {
+				seen[v.lang] = true
+				langs = append(langs, v.lang)
+			}
+		}
+	}
+	sort.Slice(langs, func(i, j int) bool { return langs[i] < langs[j] })
+	return langs
+}
+
+// selectVariants chooses the language of the variants to keep for each cell
+// with language markers, following the fallback chain of the language.
+// It returns nil for AnyLanguage.
+func (n *Notebook) selectVariants(lang Language) map[*Cell]Language {
+	if lang == AnyLanguage {
+		return nil
+	}
+	chosen := make(map[*Cell]Language)
+	for _, group := range n.variantGroups() {
+		available := make(map[Language]bool)
+		for _, l := range n.groupLanguages(group) {
+			available[l] = true
+		}
+		choice := unavailable
+		for _, l := range lang.Fallbacks() {
+			if available[l] {
+				choice = l
+				break
+			}
+		}
+		for _, i := range group {
+			chosen[n.Cells[i]] = choice
+		}
+	}
+	return chosen
+}
+
+// Languages returns the sorted list of languages used in the language markers
+// of the notebook.
+func (n *Notebook) Languages() []Language {
+	var all []int
+	for i := range n.Cells {
+		all = append(all, i)
+	}
+	return n.groupLanguages(all)
+}
+
+// MissingTranslation describes a group of consecutive cells that are variants
+// of the same text, but lack the variants in some languages.
+type MissingTranslation struct {
+	// Cells lists the indices of the cells in the group.
+	Cells []int
+	// Available lists the languages of the existing variants.
+	Available []Language
+	// Missing lists the languages without a variant.
+	Missing []Language
+}
+
+// MissingTranslations checks every group of language variants in the notebook
+// for the variants in the given languages. If no languages are given, it
+// checks all languages used in the notebook. Note that only the exact language
+// match counts as a translation, the fallback chains are not used.
+func (n *Notebook) MissingTranslations(langs []Language) []*MissingTranslation {
+	if len(langs) == 0 {
+		langs = n.Languages()
+	}
+	var ret []*MissingTranslation
+	for _, group := range n.variantGroups() {
+		available := n.groupLanguages(group)
+		var missing []Language
+		for _, l := range langs {
+			found := false
+			for _, a := range available {
+				if a == l {
+					found = true
+					break
+				}
+			}
+			if !found {
+				missing = append(missing, l)
+			}
+		}
+		if len(missing) > 0 {
+			ret = append(ret, &MissingTranslation{
+				Cells:     group,
+				Available: available,
+				Missing:   missing,
+			})
+		}
+	}
+	return ret
+}
@@ -0,0 +1,122 @@
+package notebook
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseLanguage(t *testing.T) {
+	tests := []struct {
+		input string
+		want  Language
+	}{
+		{"", AnyLanguage},
+		{"en", English},
+		{"JA", Japanese},
+		{"pt-br", "pt-BR"},
+		{"zh_hant_tw", "zh-Hant-TW"},
+		{"es-419", "es-419"},
+	}
+	for _, tt := range tests {
+		got, err := ParseLanguage(tt.input)
+		if err != nil {
+			t.Errorf("ParseLanguage(%q) returned error %s, want success", tt.input, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseLanguage(%q) = %q, want %q", tt.input, got, tt.want)
+		}
+	}
+	for _, input := range []string{"e", "en-", "en us", "**"} {
+		if _, err := ParseLanguage(input); err == nil {
+			t.Errorf("ParseLanguage(%q) returned success, want error", input)
+		}
+	}
+}
+
+func TestFallbacks(t *testing.T) {
+	tests := []struct {
+		lang Language
+		want []Language
+	}{
+		{AnyLanguage, nil},
+		{English, []Language{"en"}},
+		{"en-GB", []Language{"en-GB", "en"}},
+		{"pt-BR", []Language{"pt-BR", "pt", "en"}},
+		{"zh-Hant-TW", []Language{"zh-Hant-TW", "zh-Hant", "zh", "en"}},
+	}
+	for _, tt := range tests {
+		if got := tt.lang.Fallbacks(); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q.Fallbacks() = %q, want %q", tt.lang, got, tt.want)
+		}
+	}
+}
+
+func TestToStudentLanguage(t *testing.T) {
+	input := []string{
+		"## Title",
+		"## **lang:en** Hello",
+		"## **lang:ja** Konnichiwa",
+		"## **lang:pt** Olá",
+		"x = 1",
+		"## **lang:en** Bye\n**lang:pt-BR** Tchau",
+		"## **lang:ja** Sayonara",
+	}
+	tests := []struct {
+		lang Language
+		want []string
+	}{
+		{AnyLanguage, []string{"## Title", "##  Hello", "##  Konnichiwa", "##  Olá", "x = 1", "##  Bye\n Tchau", "##  Sayonara"}},
+		{English, []string{"## Title", "##  Hello", "x = 1", "##  Bye\n"}},
+		{Japanese, []string{"## Title", "##  Konnichiwa", "x = 1", "##  Sayonara"}},
+		{"pt-BR", []string{"## Title", "##  Olá", "x = 1", " Tchau"}},
+		{"pt", []string{"## Title", "##  Olá", "x = 1", "##  Bye\n"}},
+		{"fr", []string{"## Title", "##  Hello", "x = 1", "##  Bye\n"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.lang.String(), func(t *testing.T) {
+			n := createNotebook(input)
+			got, err := n.ToStudent(tt.lang, Options{})
+			if err != nil {
+				t.Fatalf("ToStudent returned error %s, want success", err)
+			}
+			var gotSources []string
+			for _, cell := range got.Cells {
+				gotSources = append(gotSources, cell.Source)
+			}
+			if !reflect.DeepEqual(gotSources, tt.want) {
+				t.Errorf("ToStudent(%q) returned\n%q\nwant\n%q", tt.lang, gotSources, tt.want)
+			}
+		})
+	}
+}
+
+func TestMissingTranslations(t *testing.T) {
+	n := createNotebook([]string{
+		"## **lang:en** Hello",
+		"## **lang:ja** Konnichiwa",
+		"x = 1",
+		"## **lang:en** Bye",
+		"## Untranslated",
+		"## **lang:pt-br** Tchau",
+	})
+	if got, want := n.Languages(), []Language{"en", "ja", "pt-BR"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Languages() = %q, want %q", got, want)
+	}
+	got := n.MissingTranslations(nil)
+	want := []*MissingTranslation{
+		{Cells: []int{0, 1}, Available: []Language{"en", "ja"}, Missing: []Language{"pt-BR"}},
+		{Cells: []int{3}, Available: []Language{"en"}, Missing: []Language{"ja", "pt-BR"}},
+		{Cells: []int{5}, Available: []Language{"pt-BR"}, Missing: []Language{"en", "ja"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("MissingTranslations(nil) returned %+v, want %+v", got, want)
+	}
+	got = n.MissingTranslations([]Language{"en"})
+	want = []*MissingTranslation{
+		{Cells: []int{5}, Available: []Language{"pt-BR"}, Missing: []Language{"en"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("MissingTranslations([en]) returned %+v, want %+v", got, want)
+	}
+}
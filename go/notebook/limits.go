@@ -0,0 +1,201 @@
+package notebook
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ExerciseConfigFilename is the name of the file in the exercise directory of
+// the autograder that contains the settings of the exercise declared in
+// the exercise metadata: the resource limits, the points, the output
+// comparison options of the input/output tests and the static constraints.
+const ExerciseConfigFilename = "exercise.json"
+
+// PointsKey is the key of the number of points the exercise is worth
+// in the exercise metadata. The points earned are proportional to the weights
+// of the passed tests.
+const PointsKey = "points"
+
+// The keys of the resource limits in the exercise metadata. The values are
+// written into ExerciseConfigFilename in the canonical units:
+// * time_limit: the wall time limit for running one test, in seconds.
+//   The metadata may give it as a number of seconds or as a duration, e.g. "2m".
+// * memory_limit: the address space limit, in bytes.
+//   The metadata may give it as a number of megabytes or with a unit, e.g. "2G".
+// * cpu_limit: the number of CPUs the tests can use.
+// * allow_network: whether the tests can access the network.
+const (
+	TimeLimitKey    = "time_limit"
+	MemoryLimitKey  = "memory_limit"
+	CPULimitKey     = "cpu_limit"
+	AllowNetworkKey = "allow_network"
+)
+
+var memorySizeRegex = regexp.MustCompile(`^([0-9]+(?:\.[0-9]+)?) *([KMGkmg]?)i?[Bb]?$`)
+
+// parseMemorySize parses the memory size given as a number of megabytes
+// or as a string with a unit suffix K, M or G, and returns it in bytes.
+func parseMemorySize(v interface{}) (int64, error) {
+	var size float64
+	switch v := v.(type) {
+	case int:
+		size = float64(v) * (1 << 20)
+	case float64:
+		size = v * (1 << 20)
+	case string:
+		m := memorySizeRegex.FindStringSubmatch(strings.TrimSpace(v))
+		if m == nil {
+			return 0, fmt.Errorf("invalid memory size %q, want e.g. 512M or 2G", v)
+		}
+		size, _ = strconv.ParseFloat(m[1], 64)
+		switch strings.ToUpper(m[2]) {
+		case "K":
+			size *= 1 << 10
+		case "", "M":
+			size *= 1 << 20
+		case "G":
+			size *= 1 << 30
+		}
+	default:
+		return 0, fmt.Errorf("memory size is not a number or a string, but %s", reflect.TypeOf(v))
+	}
+	if size <= 0 {
+		return 0, fmt.Errorf("memory size must be positive, got %v", v)
+	}
+	return int64(size), nil
+}
+
+// parseDuration parses the duration given as a number of seconds or
+// as a string accepted by time.ParseDuration, and returns it in seconds.
+func parseDuration(v interface{}) (float64, error) {
+	var seconds float64
+	switch v := v.(type) {
+	case int:
+		seconds = float64(v)
+	case float64:
+		seconds = v
+	case string:
+		d, err := time.ParseDuration(strings.TrimSpace(v))
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q, want e.g. 10s or 2m", v)
+		}
+		seconds = d.Seconds()
+	default:
+		return 0, fmt.Errorf("duration is not a number or a string, but %s", reflect.TypeOf(v))
+	}
+	if seconds <= 0 {
+		return 0, fmt.Errorf("duration must be positive, got %v", v)
+	}
+	return seconds, nil
+}
+
+// ExerciseLimits extracts the resource limits from the exercise metadata
+// and converts them to the canonical units. It returns nil if the metadata
+// does not declare any limits.
+func ExerciseLimits(metadata map[string]interface{}) (map[string]interface{}, error) {
+	var limits map[string]interface{}
+	set := func(key string, value interface{}) {
+		if limits == nil {
+			limits = make(map[string]interface{})
+		}
+		limits[key] = value
+	}
+	if v, ok := metadata[TimeLimitKey]; ok {
+		seconds, err := parseDuration(v)
+		if err != nil {
+			return nil, fmt.Errorf("bad %s: %s", TimeLimitKey, err)
+		}
+		set(TimeLimitKey, seconds)
+	}
+	if v, ok := metadata[MemoryLimitKey]; ok {
+		size, err := parseMemorySize(v)
+		if err != nil {
+			return nil, fmt.Errorf("bad %s: %s", MemoryLimitKey, err)
+		}
+		set(MemoryLimitKey, size)
+	}
+	if v, ok := metadata[CPULimitKey]; ok {
+		cpus, ok := v.(int)
+		if !ok || cpus <= 0 {
+			return nil, fmt.Errorf("bad %s: want a positive integer, got %v", CPULimitKey, v)
+		}
+		set(CPULimitKey, cpus)
+	}
+	if v, ok := metadata[AllowNetworkKey]; ok {
+		allow, ok := v.(bool)
+		if !ok {
+			return nil, fmt.Errorf("bad %s: want true or false, got %v", AllowNetworkKey, v)
+		}
+		set(AllowNetworkKey, allow)
+	}
+	return limits, nil
+}
+
+// ExerciseConfig extracts the resource limits, the points, the output
+// comparison options of the input/output tests and the static constraints
+// from the exercise metadata. It returns nil if the metadata does not declare
+// any of them.
+func ExerciseConfig(metadata map[string]interface{}) (map[string]interface{}, error) {
+	config, err := ExerciseLimits(metadata)
+	if err != nil {
+		return nil, err
+	}
+	if v, ok := metadata[PointsKey]; ok {
+		var points float64
+		switch v := v.(type) {
+		case int:
+			points = float64(v)
+		case float64:
+			points = v
+		default:
+			return nil, fmt.Errorf("bad %s: want a number, got %v", PointsKey, v)
+		}
+		if points < 0 {
+			return nil, fmt.Errorf("bad %s: must not be negative, got %v", PointsKey, v)
+		}
+		if config == nil {
+			config = make(map[string]interface{})
+		}
+		config[PointsKey] = points
+	}
+	options, err := ioTestOptions(metadata)
+	if err != nil {
+		return nil, err
+	}
+	constraints, err := ExerciseConstraints(metadata)
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range []map[string]interface{}{options, constraints} {
+		for k, v := range m {
+			if config == nil {
+				config = make(map[string]interface{})
+			}
+			config[k] = v
+		}
+	}
+	return config, nil
+}
+
+// configCell returns the autograder cell with the exercise config in JSON format,
+// or nil if the exercise metadata does not declare any settings.
+func configCell(exerciseMetadata map[string]interface{}, assignmentID string) (*Cell, error) {
+	config, err := ExerciseConfig(exerciseMetadata)
+	if err != nil || config == nil {
+		return nil, err
+	}
+	b, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return &Cell{
+		Type:     "code",
+		Metadata: cloneMetadata(exerciseMetadata, "filename", ExerciseConfigFilename, "assignment_id", assignmentID),
+		Source:   string(b) + "\n",
+	}, nil
+}
@@ -0,0 +1,70 @@
+package notebook
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExerciseLimits(t *testing.T) {
+	tests := []struct {
+		metadata map[string]interface{}
+		want     map[string]interface{}
+	}{
+		{map[string]interface{}{"exercise_id": "A"}, nil},
+		{map[string]interface{}{"time_limit": 10}, map[string]interface{}{"time_limit": 10.0}},
+		{map[string]interface{}{"time_limit": "1.5s"}, map[string]interface{}{"time_limit": 1.5}},
+		{map[string]interface{}{"time_limit": "2m"}, map[string]interface{}{"time_limit": 120.0}},
+		{map[string]interface{}{"memory_limit": 100}, map[string]interface{}{"memory_limit": int64(100 << 20)}},
+		{map[string]interface{}{"memory_limit": "512K"}, map[string]interface{}{"memory_limit": int64(512 << 10)}},
+		{map[string]interface{}{"memory_limit": "2GB"}, map[string]interface{}{"memory_limit": int64(2 << 30)}},
+		{map[string]interface{}{"memory_limit": "1.5G"}, map[string]interface{}{"memory_limit": int64(3 << 29)}},
+		{map[string]interface{}{"cpu_limit": 2, "allow_network": true},
+			map[string]interface{}{"cpu_limit": 2, "allow_network": true}},
+	}
+	for _, tt := range tests {
+		got, err := ExerciseLimits(tt.metadata)
+		if err != nil {
+			t.Errorf("ExerciseLimits(%v) returned error %s, want success", tt.metadata, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ExerciseLimits(%v) = %v, want %v", tt.metadata, got, tt.want)
+		}
+	}
+	for _, metadata := range []map[string]interface{}{
+		{"time_limit": "forever"},
+		{"time_limit": -1},
+		{"memory_limit": "1T"},
+		{"memory_limit": true},
+		{"cpu_limit": 1.5},
+		{"cpu_limit": 0},
+		{"allow_network": "yes"},
+	} {
+		if _, err := ExerciseLimits(metadata); err == nil {
+			t.Errorf("ExerciseLimits(%v) returned success, want error", metadata)
+		}
+	}
+}
+
+func TestExerciseConfig(t *testing.T) {
+	got, err := ExerciseConfig(map[string]interface{}{"exercise_id": "A", "points": 5, "time_limit": 1,
+		"iotest_ignore_case": true, "iotest_float_tolerance": 1e-6})
+	if err != nil {
+		t.Fatalf("ExerciseConfig returned error %s, want success", err)
+	}
+	want := map[string]interface{}{"points": 5.0, "time_limit": 1.0,
+		"iotest_ignore_case": true, "iotest_float_tolerance": 1e-6}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ExerciseConfig returned %v, want %v", got, want)
+	}
+	for _, metadata := range []map[string]interface{}{
+		{"points": "five"},
+		{"points": -1},
+		{"iotest_ignore_whitespace": "yes"},
+		{"iotest_float_tolerance": -0.1},
+	} {
+		if _, err := ExerciseConfig(metadata); err == nil {
+			t.Errorf("ExerciseConfig(%v) returned success, want error", metadata)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package notebook
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+// LintProblem describes a mistake in a master notebook found by Lint.
+type LintProblem struct {
+	// Cell is the index of the cell in the notebook, starting from 0.
+	Cell int
+	// Line is the line number in the cell source, starting from 1.
+	Line int
+	// Message describes the problem.
+	Message string
+}
+
+func (p *LintProblem) String() string {
+	return fmt.Sprintf("cell %d, line %d: %s", p.Cell, p.Line, p.Message)
+}
+
+// lineAt returns the line number of the byte offset in the text.
+func lineAt(text string, offset int) int {
+	return strings.Count(text[:offset], "\n") + 1
+}
+
+// lintMarkers checks that every begin marker in the code cell source is closed
+// by the matching end marker before the next begin marker. It returns
+// the problems with the line numbers set.
+func lintMarkers(source, name string, begin, end *regexp.Regexp) []*LintProblem {
+	var problems []*LintProblem
+	report := func(line int, format string, args ...interface{}) {
+		problems = append(problems, &LintProblem{
+			Line:    line,
+			Message: fmt.Sprintf(format, args...),
+		})
+	}
+	// open is the line number of the unclosed begin marker, or 0.
+	open := 0
+	for i, line := range strings.Split(source, "\n") {
+		// The marker regexes expect the trailing newline.
+		line += "\n"
+		switch {
+		case begin.MatchString(line):
+			if open > 0 {
+				report(open, "# BEGIN %s has no matching # END %s", name, name)
+			}
+			open = i + 1
+		case end.MatchString(line):
+			if open == 0 {
+				report(i+1, "# END %s without # BEGIN %s", name, name)
+			}
+			open = 0
+		}
+	}
+	if open > 0 {
+		report(open, "# BEGIN %s has no matching # END %s", name, name)
+	}
+	return problems
+}
+
+// Lint checks the master notebook for mistakes that would otherwise only show
+// up when extracting the student notebook or autograder scripts, or even later
+// at grading time. It walks the cells in the same way as ToStudent and
+// ToAutograder do and returns the list of all problems found.
+func (n *Notebook) Lint() []*LintProblem {
+	var problems []*LintProblem
+	report := func(cell, line int, format string, args ...interface{}) {
+		problems = append(problems, &LintProblem{
+			Cell:    cell,
+			Line:    line,
+			Message: fmt.Sprintf(format, args...),
+		})
+	}
+	// exerciseCells maps exercise IDs to the index of the cell that defined it.
+	exerciseCells := make(map[string]int)
+	// exerciseCell is the index of the cell with the metadata of the current
+	// exercise, or -1 before the first exercise.
+	exerciseCell := -1
+	// solutionCell is the index of the %%solution cell of the current exercise, or -1.
+	solutionCell := -1
+	// inlineTests maps the inline and input/output test names of the current
+	// exercise to the index of the cell that defined it. The names must be
+	// unique, as the autograder reports the outcomes by the test name.
+	inlineTests := make(map[string]int)
+	for i, cell := range n.Cells {
+		source := cell.Source
+		if cell.Type == "markdown" {
+			if hasMetadata(assignmentMetadataRegex, source) {
+				if _, _, err := extractMetadata(assignmentMetadataRegex, source); err != nil {
+					line := lineAt(source, assignmentMetadataRegex.FindStringIndex(source)[0])
+					report(i, line, "%s", err)
+				}
+			}
+			if hasMetadata(exerciseMetadataRegex, source) {
+				line := lineAt(source, exerciseMetadataRegex.FindStringIndex(source)[0])
+				metadata, _, err := extractMetadata(exerciseMetadataRegex, source)
+				if err != nil {
+					report(i, line, "%s", err)
+					continue
+				}
+				exerciseCell = i
+				solutionCell = -1
+				inlineTests = make(map[string]int)
+				if _, err := ExerciseConfig(metadata); err != nil {
+					report(i, line, "%s", err)
+				}
+				v, ok := metadata["exercise_id"]
+				if !ok {
+					report(i, line, "exercise metadata has no exercise_id")
+					continue
+				}
+				if k := strings.Index(source, "exercise_id"); k >= 0 {
+					line = lineAt(source, k)
+				}
+				id, ok := v.(string)
+				if !ok {
+					report(i, line, "exercise_id is not a string, but %s", reflect.TypeOf(v))
+					continue
+				}
+				if prev, ok := exerciseCells[id]; ok {
+					report(i, line, "duplicate exercise_id %q, already defined in cell %d", id, prev)
+				} else {
+					exerciseCells[id] = i
+				}
+			}
+			continue
+		}
+		if cell.Type != "code" {
+			continue
+		}
+		for _, p := range append(lintMarkers(source, "SOLUTION", solutionBeginRegex, solutionEndRegex),
+			append(lintMarkers(source, "UNITTEST", unittestBeginRegex, unittestEndRegex),
+				lintMarkers(source, "HIDDEN", hiddenBeginRegex, hiddenEndRegex)...)...) {
+			p.Cell = i
+			problems = append(problems, p)
+		}
+		if m := solutionMagicRegex.FindStringIndex(source); m != nil {
+			line := lineAt(source, m[0])
+			if exerciseCell < 0 {
+				report(i, line, "%%%%solution cell has no preceding # EXERCISE METADATA")
+			} else if solutionCell >= 0 {
+				report(i, line, "%%%%solution cell for the exercise defined in cell %d, which already has a %%%%solution cell %d",
+					exerciseCell, solutionCell)
+			} else {
+				solutionCell = i
+			}
+			if mbeg := promptBeginRegex.FindStringIndex(source); mbeg != nil {
+				mend := promptEndRegex.FindStringIndex(source)
+				if mend == nil {
+					report(i, lineAt(source, mbeg[0]), "# BEGIN PROMPT has no matching # END PROMPT")
+				} else if mend[1] < mbeg[0] {
+					report(i, lineAt(source, mend[0]+1), "# END PROMPT is before # BEGIN PROMPT")
+				}
+			}
+		}
+		if m := unittestBeginRegex.FindStringIndex(source); m != nil {
+			line := lineAt(source, m[0])
+			if exerciseCell < 0 {
+				report(i, line, "unit test has no preceding # EXERCISE METADATA")
+			}
+			text, err := cutText(unittestBeginRegex, unittestEndRegex, source)
+			if err == nil && !testClassRegex.MatchString(text) {
+				report(i, line, "unit test does not define a unittest.TestCase class")
+			}
+		}
+		if m := ioTestRegex.FindStringSubmatchIndex(source); m != nil {
+			line := lineAt(source, m[0])
+			name := source[m[2]:m[3]]
+			if exerciseCell < 0 {
+				report(i, line, "%%%%iotest cell has no preceding # EXERCISE METADATA")
+			}
+			if _, _, err := splitIOTest(source[m[1]:]); err != nil {
+				report(i, line, "%s", err)
+			}
+			if prev, ok := inlineTests[name]; ok {
+				report(i, line, "duplicate inline test name %q, already used in cell %d", name, prev)
+			} else {
+				inlineTests[name] = i
+			}
+		}
+		if m := inlineTestRegex.FindStringSubmatchIndex(source); m != nil {
+			line := lineAt(source, m[0])
+			name := source[m[2]:m[3]]
+			if prev, ok := inlineTests[name]; ok {
+				report(i, line, "duplicate inline test name %q, already used in cell %d", name, prev)
+			} else {
+				inlineTests[name] = i
+			}
+		}
+	}
+	return problems
+}
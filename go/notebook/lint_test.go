@@ -0,0 +1,127 @@
+package notebook
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLint(t *testing.T) {
+	tests := []struct {
+		name  string
+		input []string
+		want  []string
+	}{
+		{
+			name: "Clean",
+			input: []string{
+				"## Exercise\n```\n# EXERCISE METADATA\nexercise_id: A\n```",
+				"%%solution\ndef f():\n  # BEGIN SOLUTION\n  return 1\n  # END SOLUTION",
+				"%%inlinetest FTest\nassert f() == 1",
+				"# BEGIN UNITTEST\nimport unittest\nclass FTest(unittest.TestCase):\n  pass\n# END UNITTEST",
+				"## Exercise\n```\n# EXERCISE METADATA\nexercise_id: B\n```",
+				"%%solution\npass",
+				"%%inlinetest FTest\nassert True",
+			},
+		},
+		{
+			name: "UnclosedSolution",
+			input: []string{
+				"## Exercise\n```\n# EXERCISE METADATA\nexercise_id: A\n```",
+				"%%solution\ndef f():\n  # BEGIN SOLUTION\n  return 1\n  # BEGIN SOLUTION\n  return 2\n  # END SOLUTION\n  # END SOLUTION",
+			},
+			want: []string{
+				"cell 1, line 3: # BEGIN SOLUTION has no matching # END SOLUTION",
+				"cell 1, line 8: # END SOLUTION without # BEGIN SOLUTION",
+			},
+		},
+		{
+			name: "DuplicateExerciseID",
+			input: []string{
+				"## Exercise\n```\n# EXERCISE METADATA\nexercise_id: A\n```",
+				"%%solution\npass",
+				"## Exercise\n\n```\n# EXERCISE METADATA\nexercise_id: A\n```",
+				"%%solution\npass",
+			},
+			want: []string{
+				`cell 2, line 5: duplicate exercise_id "A", already defined in cell 0`,
+			},
+		},
+		{
+			name: "SolutionWithoutMetadata",
+			input: []string{
+				"import math",
+				"%%solution\npass",
+			},
+			want: []string{
+				"cell 1, line 1: %%solution cell has no preceding # EXERCISE METADATA",
+			},
+		},
+		{
+			name: "UnittestWithoutTestCase",
+			input: []string{
+				"## Exercise\n```\n# EXERCISE METADATA\nexercise_id: A\n```",
+				"# comment\n# BEGIN UNITTEST\nassert True\n# END UNITTEST",
+				"# BEGIN UNITTEST\nimport unittest\nclass T(unittest.TestCase):\n  pass",
+			},
+			want: []string{
+				"cell 1, line 2: unit test does not define a unittest.TestCase class",
+				"cell 2, line 1: # BEGIN UNITTEST has no matching # END UNITTEST",
+			},
+		},
+		{
+			name: "BadLimits",
+			input: []string{
+				"## Exercise\n```\n# EXERCISE METADATA\nexercise_id: A\ntime_limit: forever\n```",
+				"%%solution\npass",
+			},
+			want: []string{
+				`cell 0, line 3: bad time_limit: invalid duration "forever", want e.g. 10s or 2m`,
+			},
+		},
+		{
+			name: "BadConstraints",
+			input: []string{
+				"## Exercise\n```\n# EXERCISE METADATA\nexercise_id: A\nrequired_constructs: [goto]\n```",
+				"%%solution\npass",
+			},
+			want: []string{
+				`cell 0, line 3: bad required_constructs: "goto" is not one of class, comprehension, def, for, if, lambda, loop, recursion, try, while, with`,
+			},
+		},
+		{
+			name: "DuplicateInlineTest",
+			input: []string{
+				"## Exercise\n```\n# EXERCISE METADATA\nexercise_id: A\n```",
+				"%%inlinetest FTest\nassert True",
+				"%%inlinetest FTest\nassert False",
+			},
+			want: []string{
+				`cell 2, line 1: duplicate inline test name "FTest", already used in cell 1`,
+			},
+		},
+		{
+			name: "BadIOTest",
+			input: []string{
+				"## Exercise\n```\n# EXERCISE METADATA\nexercise_id: A\n```",
+				"%%iotest Sum\n1 2\n3",
+				"%%inlinetest Sum\nassert True",
+			},
+			want: []string{
+				"cell 1, line 1: %%iotest cell has no # OUTPUT marker",
+				`cell 2, line 1: duplicate inline test name "Sum", already used in cell 1`,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n := createNotebook(tt.input)
+			var got []string
+			for _, p := range n.Lint() {
+				got = append(got, p.String())
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Lint returned %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,269 @@
+package notebook
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// This file contains the handling of different nbformat versions:
+// the upgrade of nbformat v3 notebooks to v4 and the cell IDs
+// introduced in nbformat 4.5.
+
+// cellIDRegex is the pattern of cell IDs defined by nbformat 4.5.
+var cellIDRegex = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,64}$`)
+
+// hasCellIDs returns true if the notebook format version requires cell IDs.
+func (n *Notebook) hasCellIDs() bool {
+	return n.NBFormat > 4 || (n.NBFormat == 4 && n.NBFormatMinor >= 5)
+}
+
+// generateCellID returns a cell ID derived from the cell type and source,
+// so that the same cell gets the same ID every time the notebook is processed.
+func generateCellID(cell *Cell) string {
+	h := sha256.Sum256([]byte(cell.Type + "\x00" + cell.Source))
+	return hex.EncodeToString(h[:4])
+}
+
+// uniqueCellID makes the ID unique in the set of IDs seen so far and adds it
+// to the set.
+func uniqueCellID(id string, seen map[string]bool) string {
+	candidate := id
+	for i := 2; seen[candidate]; i++ {
+		suffix := "-" + strconv.Itoa(i)
+		if len(id)+len(suffix) > 64 {
+			id = id[:64-len(suffix)]
+		}
+		candidate = id + suffix
+	}
+	seen[candidate] = true
+	return candidate
+}
+
+// cellIDs returns the list of IDs for all cells of the notebook. Existing cell
+// IDs are preserved, missing ones are generated from the cell contents.
+func (n *Notebook) cellIDs() []string {
+	seen := make(map[string]bool)
+	for _, cell := range n.Cells {
+		if cell.ID != "" {
+			seen[cell.ID] = true
+		}
+	}
+	var ids []string
+	for _, cell := range n.Cells {
+		if cell.ID != "" {
+			ids = append(ids, cell.ID)
+			continue
+		}
+		ids = append(ids, uniqueCellID(generateCellID(cell), seen))
+	}
+	return ids
+}
+
+// EnsureCellIDs assigns stable IDs to all cells that do not have one.
+func (n *Notebook) EnsureCellIDs() {
+	for i, id := range n.cellIDs() {
+		n.Cells[i].ID = id
+	}
+}
+
+// fillCellIDs sets the IDs of the cells without one in the parsed JSON
+// of the notebook, generating them the same way as cellIDs.
+func fillCellIDs(data map[string]interface{}) {
+	cells, _ := data["cells"].([]interface{})
+	seen := make(map[string]bool)
+	for _, x := range cells {
+		if celldata, ok := x.(map[string]interface{}); ok {
+			if id, ok := celldata["id"].(string); ok {
+				seen[id] = true
+			}
+		}
+	}
+	for _, x := range cells {
+		celldata, ok := x.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if _, ok := celldata["id"]; ok {
+			continue
+		}
+		cell := &Cell{}
+		cell.Type, _ = celldata["cell_type"].(string)
+		cell.Source, _ = parseText(celldata["source"])
+		celldata["id"] = uniqueCellID(generateCellID(cell), seen)
+	}
+}
+
+// deriveCellID returns the ID of the i-th cell produced from the master cell
+// with the given ID. The first cell reuses the master ID, so that a student
+// cell can be traced back to its master cell.
+func deriveCellID(masterID string, i int) string {
+	if i == 0 {
+		return masterID
+	}
+	suffix := "-" + strconv.Itoa(i)
+	if len(masterID)+len(suffix) > 64 {
+		masterID = masterID[:64-len(suffix)]
+	}
+	return masterID + suffix
+}
+
+// v3MIMETypes maps the output keys of nbformat v3 to MIME types.
+var v3MIMETypes = map[string]string{
+	"text":       "text/plain",
+	"html":       "text/html",
+	"svg":        "image/svg+xml",
+	"png":        "image/png",
+	"jpeg":       "image/jpeg",
+	"latex":      "text/latex",
+	"json":       "application/json",
+	"javascript": "application/javascript",
+}
+
+// upgradeOutputV3 converts an nbformat v3 output into the v4 format.
+func upgradeOutputV3(data map[string]interface{}) map[string]interface{} {
+	ret := make(map[string]interface{})
+	outputType, _ := data["output_type"].(string)
+	switch outputType {
+	case "pyout", "display_data":
+		if outputType == "pyout" {
+			ret["output_type"] = ExecuteResultOutput
+			ret["execution_count"] = data["prompt_number"]
+		} else {
+			ret["output_type"] = DisplayDataOutput
+		}
+		bundle := make(map[string]interface{})
+		for k, v := range data {
+			if mimeType, ok := v3MIMETypes[k]; ok {
+				bundle[mimeType] = v
+			}
+		}
+		ret["data"] = bundle
+		metadata, ok := data["metadata"].(map[string]interface{})
+		if !ok {
+			metadata = make(map[string]interface{})
+		}
+		ret["metadata"] = metadata
+	case "pyerr":
+		ret["output_type"] = ErrorOutput
+		ret["ename"] = data["ename"]
+		ret["evalue"] = data["evalue"]
+		ret["traceback"] = data["traceback"]
+	case "stream":
+		ret["output_type"] = StreamOutput
+		ret["name"] = data["stream"]
+		ret["text"] = data["text"]
+	default:
+		// Unknown output type, keep it as is.
+		return data
+	}
+	return ret
+}
+
+// upgradeCellV3 converts an nbformat v3 cell into the v4 format.
+func upgradeCellV3(data map[string]interface{}) (map[string]interface{}, error) {
+	ret := make(map[string]interface{})
+	metadata, ok := data["metadata"].(map[string]interface{})
+	if !ok {
+		metadata = make(map[string]interface{})
+	}
+	ret["metadata"] = metadata
+	cellType, _ := data["cell_type"].(string)
+	switch cellType {
+	case "code":
+		ret["cell_type"] = "code"
+		ret["source"] = data["input"]
+		ret["execution_count"] = data["prompt_number"]
+		if v, ok := data["collapsed"]; ok {
+			metadata["collapsed"] = v
+		}
+		outputs := []interface{}{}
+		if v, ok := data["outputs"]; ok {
+			list, ok := v.([]interface{})
+			if !ok {
+				return nil, fmt.Errorf("cell.outputs is not a list but %s", reflect.TypeOf(v))
+			}
+			for _, x := range list {
+				output, ok := x.(map[string]interface{})
+				if !ok {
+					return nil, fmt.Errorf("output is not a map but %s", reflect.TypeOf(x))
+				}
+				outputs = append(outputs, upgradeOutputV3(output))
+			}
+		}
+		ret["outputs"] = outputs
+	case "heading":
+		// Headings are converted to markdown cells with the matching number of #.
+		source, err := parseText(data["source"])
+		if err != nil {
+			return nil, err
+		}
+		level := 1
+		if v, ok := data["level"]; ok {
+			level, err = parseInt(v)
+			if err != nil {
+				return nil, fmt.Errorf("heading level: %s", err)
+			}
+		}
+		ret["cell_type"] = "markdown"
+		ret["source"] = strings.Repeat("#", level) + " " +
+			strings.Join(strings.Split(strings.TrimRight(source, "\n"), "\n"), " ")
+	default:
+		ret["cell_type"] = cellType
+		ret["source"] = data["source"]
+	}
+	return ret, nil
+}
+
+// upgradeV3 converts the parsed JSON of an nbformat v3 notebook into
+// the nbformat v4.0 structure in place, following the conversion rules
+// of the nbformat Python package.
+func upgradeV3(data map[string]interface{}) error {
+	cells := []interface{}{}
+	if v, ok := data["worksheets"]; ok {
+		worksheets, ok := v.([]interface{})
+		if !ok {
+			return fmt.Errorf(".worksheets is not a list but %s", reflect.TypeOf(v))
+		}
+		for _, x := range worksheets {
+			worksheet, ok := x.(map[string]interface{})
+			if !ok {
+				return fmt.Errorf("worksheet is not a map but %s", reflect.TypeOf(x))
+			}
+			v, ok := worksheet["cells"]
+			if !ok {
+				continue
+			}
+			list, ok := v.([]interface{})
+			if !ok {
+				return fmt.Errorf("worksheet.cells is not a list but %s", reflect.TypeOf(v))
+			}
+			for _, y := range list {
+				celldata, ok := y.(map[string]interface{})
+				if !ok {
+					return fmt.Errorf("cell is not a map but %s", reflect.TypeOf(y))
+				}
+				cell, err := upgradeCellV3(celldata)
+				if err != nil {
+					return err
+				}
+				cells = append(cells, cell)
+			}
+		}
+	}
+	delete(data, "worksheets")
+	data["cells"] = cells
+	if metadata, ok := data["metadata"].(map[string]interface{}); ok {
+		// Notebook name and signature are not part of nbformat v4.
+		delete(metadata, "name")
+		delete(metadata, "signature")
+	}
+	data["nbformat"] = json.Number("4")
+	data["nbformat_minor"] = json.Number("0")
+	return nil
+}
@@ -3,6 +3,7 @@
 package notebook
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -26,22 +27,97 @@ type Notebook struct {
 	Metadata map[string]interface{} `json:"metadata"`
 	// Cells is the list of cells.
 	Cells []*Cell `json:"cells"`
+	// Extra holds the top-level fields that the parser does not recognize.
+	// They are written back on serialization as is.
+	Extra map[string]interface{} `json:"-"`
+	// Warnings lists the problems found by validating the notebook against
+	// the nbformat schema. Parse does not reject the notebook for them, as
+	// the notebooks saved by other tools often deviate from the schema in ways
+	// that do not matter for grading. ParseStrict rejects them.
+	Warnings []*ValidationError `json:"-"`
 }
 
-// Cell represents one cell of a Jupyter notebook. It is limited in
-// the kind of cells it can represent.
+// Cell represents one cell of a Jupyter notebook.
 type Cell struct {
-	// Type is "code" or "markdown".
+	// ID is the cell id field introduced in nbformat 4.5.
+	// It is empty if the cell does not have an ID.
+	ID string
+	// Type is "code", "markdown" or "raw".
 	Type string
 	// Data is the raw parsed JSON contents of the cell.
 	// When serializing cell back to JSON, Data is ignored.
 	Data map[string]interface{}
 	// Metadata is the "metadata" field of the cell.
 	Metadata map[string]interface{}
-	// Outputs are the recorded outputs of the cell.
-	Outputs map[string]string
+	// ExecutionCount is the execution count of a code cell,
+	// or nil if the cell has not been executed.
+	ExecutionCount *int
+	// Outputs are the recorded outputs of the cell in the original order.
+	Outputs []*Output
+	// Attachments maps attachment names of a markdown or raw cell
+	// to their MIME bundles.
+	Attachments map[string]MIMEBundle
 	// Source is the raw source of the cell.
 	Source string
+	// Extra holds the cell fields that the parser does not recognize.
+	// They are written back on serialization as is.
+	Extra map[string]interface{}
+}
+
+// Output types defined by nbformat v4.
+const (
+	StreamOutput        = "stream"
+	DisplayDataOutput   = "display_data"
+	ExecuteResultOutput = "execute_result"
+	ErrorOutput         = "error"
+)
+
+// Output represents one recorded output of a code cell.
+type Output struct {
+	// Type is the output_type field, one of StreamOutput, DisplayDataOutput,
+	// ExecuteResultOutput or ErrorOutput.
+	Type string
+	// Name is the stream name ("stdout" or "stderr") of a stream output.
+	Name string
+	// Text is the text of a stream output.
+	Text string
+	// Data is the MIME bundle of a display_data or execute_result output.
+	Data MIMEBundle
+	// Metadata is the metadata of a display_data or execute_result output.
+	Metadata map[string]interface{}
+	// ExecutionCount is the execution count of an execute_result output.
+	ExecutionCount *int
+	// EName is the exception name of an error output.
+	EName string
+	// EValue is the exception value of an error output.
+	EValue string
+	// Traceback is the list of traceback lines of an error output.
+	Traceback []string
+	// Extra holds the output fields that the parser does not recognize.
+	// They are written back on serialization as is.
+	Extra map[string]interface{}
+}
+
+// MIMEBundle maps MIME types to the content in that representation.
+// The values are kept in the parsed JSON format, so that they can be
+// written back unchanged. Textual representations are either a string
+// or a list of strings, JSON representations (e.g. application/json) are
+// arbitrary JSON values.
+type MIMEBundle map[string]interface{}
+
+// Text returns the textual content stored under the given MIME type.
+// The second return value is false if the bundle does not have the MIME type
+// or its content is not textual.
+func (b MIMEBundle) Text(mimeType string) (string, bool) {
+	v, ok := b[mimeType]
+	if !ok {
+		return "", false
+	}
+	text, err := parseText(v)
+	if err != nil {
+		return "", false
+	}
+	return text, true
 }
 
 // ParseFile loads a notebook file from the specified file and parses it
@@ -85,24 +161,248 @@ func parseText(v interface{}) (text string, err error) {
 	return
 }
 
+// parseInt parses an integer value from the JSON decoded with json.Number.
+func parseInt(v interface{}) (int, error) {
+	num, ok := v.(json.Number)
+	if !ok {
+		return 0, fmt.Errorf("expected a number, got %s", reflect.TypeOf(v))
+	}
+	val, err := num.Int64()
+	if err != nil {
+		return 0, err
+	}
+	return int(val), nil
+}
+
+// parseExecutionCount parses the execution_count field, which is either
+// an integer or null.
+func parseExecutionCount(v interface{}) (*int, error) {
+	if v == nil {
+		return nil, nil
+	}
+	count, err := parseInt(v)
+	if err != nil {
+		return nil, fmt.Errorf("execution_count: %s", err)
+	}
+	return &count, nil
+}
+
+// extraFields returns the fields of the JSON object that are not listed
+// in known, or nil if there are no such fields.
+func extraFields(data map[string]interface{}, known ...string) map[string]interface{} {
+	var extra map[string]interface{}
+outer:
+	for k, v := range data {
+		for _, name := range known {
+			if k == name {
+				continue outer
+			}
+		}
+		if extra == nil {
+			extra = make(map[string]interface{})
+		}
+		extra[k] = v
+	}
+	return extra
+}
+
+// parseMIMEBundle parses a MIME bundle (output data or an attachment).
+func parseMIMEBundle(v interface{}) (MIMEBundle, error) {
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("MIME bundle is not a map but %s", reflect.TypeOf(v))
+	}
+	return MIMEBundle(m), nil
+}
+
+// parseOutput parses one element of the cell outputs list.
+func parseOutput(data map[string]interface{}) (*Output, error) {
+	output := &Output{}
+	var err error
+	output.Type, _ = data["output_type"].(string)
+	switch output.Type {
+	case StreamOutput:
+		nameVal := data["name"]
+		name, ok := nameVal.(string)
+		if !ok {
+			return nil, fmt.Errorf("output name is not a string but %s",
+				reflect.TypeOf(nameVal))
+		}
+		output.Name = name
+		output.Text, err = parseText(data["text"])
+		if err != nil {
+			return nil, fmt.Errorf("could not parse text: %s", err)
+		}
+		output.Extra = extraFields(data, "output_type", "name", "text")
+	case DisplayDataOutput, ExecuteResultOutput:
+		if v, ok := data["data"]; ok {
+			output.Data, err = parseMIMEBundle(v)
+			if err != nil {
+				return nil, fmt.Errorf("output data: %s", err)
+			}
+		}
+		if v, ok := data["metadata"]; ok {
+			output.Metadata, ok = v.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("output metadata is not a map but %s",
+					reflect.TypeOf(v))
+			}
+		}
+		if output.Type == ExecuteResultOutput {
+			output.ExecutionCount, err = parseExecutionCount(data["execution_count"])
+			if err != nil {
+				return nil, err
+			}
+		}
+		output.Extra = extraFields(data, "output_type", "data", "metadata", "execution_count")
+	case ErrorOutput:
+		output.EName, _ = data["ename"].(string)
+		output.EValue, _ = data["evalue"].(string)
+		if v, ok := data["traceback"]; ok {
+			lines, ok := v.([]interface{})
+			if !ok {
+				return nil, fmt.Errorf("traceback is not a list but %s", reflect.TypeOf(v))
+			}
+			for _, line := range lines {
+				s, ok := line.(string)
+				if !ok {
+					return nil, fmt.Errorf("traceback has not a string but %s",
+						reflect.TypeOf(line))
+				}
+				output.Traceback = append(output.Traceback, s)
+			}
+		}
+		output.Extra = extraFields(data, "output_type", "ename", "evalue", "traceback")
+	default:
+		// Keep the unknown output as is.
+		output.Extra = extraFields(data, "output_type")
+	}
+	return output, nil
+}
+
+// parseCell parses one element of the notebook cells list.
+func parseCell(celldata map[string]interface{}) (*Cell, error) {
+	var err error
+	cell := &Cell{
+		Data: celldata,
+	}
+	if v, ok := celldata["id"]; ok {
+		id, ok := v.(string)
+		if !ok || !cellIDRegex.MatchString(id) {
+			return nil, fmt.Errorf("invalid cell id %v", v)
+		}
+		cell.ID = id
+	}
+	if v, ok := celldata["cell_type"]; ok {
+		cell.Type, _ = v.(string)
+	}
+	if v, ok := celldata["metadata"]; ok {
+		cell.Metadata, ok = v.(map[string]interface{})
+	}
+	cell.Source, err = parseText(celldata["source"])
+	if err != nil {
+		return nil, err
+	}
+	if v, ok := celldata["execution_count"]; ok {
+		cell.ExecutionCount, err = parseExecutionCount(v)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if v, ok := celldata["outputs"]; ok {
+		ss, ok := v.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("cell.outputs is not a list but %s",
+				reflect.TypeOf(v))
+		}
+		// Code cells always have a list of outputs, even if empty.
+		cell.Outputs = []*Output{}
+		for _, s := range ss {
+			m, ok := s.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("output is not a map but %s", reflect.TypeOf(s))
+			}
+			output, err := parseOutput(m)
+			if err != nil {
+				return nil, err
+			}
+			cell.Outputs = append(cell.Outputs, output)
+		}
+	}
+	if v, ok := celldata["attachments"]; ok {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("cell.attachments is not a map but %s",
+				reflect.TypeOf(v))
+		}
+		cell.Attachments = make(map[string]MIMEBundle)
+		for name, x := range m {
+			cell.Attachments[name], err = parseMIMEBundle(x)
+			if err != nil {
+				return nil, fmt.Errorf("attachment %q: %s", name, err)
+			}
+		}
+	}
+	cell.Extra = extraFields(celldata, "id", "cell_type", "metadata", "source",
+		"execution_count", "outputs", "attachments")
+	return cell, nil
+}
+
+// ParseStrict is like Parse, but returns an error if the notebook does not
+// match the nbformat schema.
+func ParseStrict(b []byte) (*Notebook, error) {
+	n, err := Parse(b)
+	if err != nil {
+		return nil, err
+	}
+	if len(n.Warnings) > 0 {
+		return nil, fmt.Errorf("invalid notebook: %s", validationErrorsString(n.Warnings))
+	}
+	return n, nil
+}
+
 // Parse parses a byte slice into a Notebook structure. The input data
-// must be a notebook in JSON encoding.
+// must be a notebook in JSON encoding. Notebooks in nbformat v3 are upgraded
+// to nbformat v4.0, other versions except v4 are rejected. The problems found
+// by the schema validation are reported in Warnings.
 func Parse(b []byte) (*Notebook, error) {
 	data := make(map[string]interface{})
-	err := json.Unmarshal(b, &data)
+	// Numbers are kept as json.Number to write them back exactly as they were.
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	err := dec.Decode(&data)
 	if err != nil {
 		return nil, fmt.Errorf("error parsing JSON: %s", err)
 	}
+	if v, ok := data["nbformat"]; ok {
+		version, err := parseInt(v)
+		if err != nil {
+			return nil, fmt.Errorf("nbformat: %s", err)
+		}
+		switch version {
+		case 3:
+			err = upgradeV3(data)
+			if err != nil {
+				return nil, fmt.Errorf("error upgrading nbformat v3 notebook: %s", err)
+			}
+		case 4:
+		default:
+			return nil, fmt.Errorf("unsupported nbformat version %d", version)
+		}
+	}
+	if minor, err := parseInt(data["nbformat_minor"]); err == nil && minor >= 5 {
+		// Like Jupyter, fill in the missing cell IDs instead of rejecting the notebook.
+		fillCellIDs(data)
+	}
 	ret := &Notebook{
-		Data: data,
+		Data:     data,
+		Warnings: validateData(data),
 	}
 	if v, ok := data["nbformat"]; ok {
-		val, _ := v.(float64)
-		ret.NBFormat = int(val)
+		ret.NBFormat, _ = parseInt(v)
 	}
 	if v, ok := data["nbformat_minor"]; ok {
-		val, _ := v.(float64)
-		ret.NBFormatMinor = int(val)
+		ret.NBFormatMinor, _ = parseInt(v)
 	}
 	ret.Metadata, _ = data["metadata"].(map[string]interface{})
 	cells, ok := data["cells"]
@@ -116,75 +416,84 @@ func Parse(b []byte) (*Notebook, error) {
 			if !ok {
 				return nil, fmt.Errorf("cell is not a map but %s", reflect.TypeOf(x))
 			}
-			cell := &Cell{}
-			if v, ok := celldata["cell_type"]; ok {
-				cell.Type, _ = v.(string)
-			}
-			if v, ok := celldata["metadata"]; ok {
-				cell.Metadata, ok = v.(map[string]interface{})
-			}
-			cell.Source, err = parseText(celldata["source"])
-			if v, ok := celldata["outputs"]; ok {
-				ss, ok := v.([]interface{})
-				if !ok {
-					return nil, fmt.Errorf("cell.outputs is not a list but %s",
-						reflect.TypeOf(v))
-				}
-				outputs := make(map[string]string)
-				for _, s := range ss {
-					m, ok := s.(map[string]interface{})
-					if !ok {
-						continue
-					}
-					nameVal, ok := m["name"]
-					if !ok {
-						continue
-					}
-					name, ok := nameVal.(string)
-					if !ok {
-						return nil, fmt.Errorf("output name is not a string but %s",
-							reflect.TypeOf(nameVal))
-					}
-					outputs[name], err = parseText(m["text"])
-					if err != nil {
-						return nil, fmt.Errorf("could not parse text: %s", err)
-					}
-				}
-				cell.Outputs = outputs
+			cell, err := parseCell(celldata)
+			if err != nil {
+				return nil, err
 			}
 			ret.Cells = append(ret.Cells, cell)
 		}
 	}
+	ret.Extra = extraFields(data, "nbformat", "nbformat_minor", "metadata", "cells")
 	return ret, nil
 }
 
-// marshalText serializes a multi-line text string
-// into a format that is compatible with JSON encoder.
+// marshalText serializes a multi-line text string into a format that is
+// compatible with JSON encoder. Like Jupyter, it splits the text into a list
+// of lines that keep their trailing newlines.
 func marshalText(text string) []interface{} {
-	var ret []interface{}
-	lines := strings.Split(text, "\n")
-	for i, line := range lines {
-		if i == len(lines)-1 {
-			ret = append(ret, line)
+	ret := []interface{}{}
+	lines := strings.SplitAfter(text, "\n")
+	for _, line := range lines {
+		if line == "" {
+			// Only the last element can be empty.
 			break
 		}
-		ret = append(ret, line+"\n")
+		ret = append(ret, line)
+	}
+	return ret
+}
+
+// copyFields copies the extra fields to the JSON-like map.
+func copyFields(ret, extra map[string]interface{}) {
+	for k, v := range extra {
+		ret[k] = v
+	}
+}
+
+// json returns a JSON-like map representing a cell output.
+func (output *Output) json() map[string]interface{} {
+	emptyMap := make(map[string]interface{})
+	ret := make(map[string]interface{})
+	copyFields(ret, output.Extra)
+	ret["output_type"] = output.Type
+	switch output.Type {
+	case StreamOutput:
+		ret["name"] = output.Name
+		ret["text"] = marshalText(output.Text)
+	case DisplayDataOutput, ExecuteResultOutput:
+		if output.Data != nil {
+			ret["data"] = output.Data
+		} else {
+			ret["data"] = emptyMap
+		}
+		if output.Metadata != nil {
+			ret["metadata"] = output.Metadata
+		} else {
+			ret["metadata"] = emptyMap
+		}
+		if output.Type == ExecuteResultOutput {
+			ret["execution_count"] = output.ExecutionCount
+		}
+	case ErrorOutput:
+		ret["ename"] = output.EName
+		ret["evalue"] = output.EValue
+		if output.Traceback != nil {
+			ret["traceback"] = output.Traceback
+		} else {
+			ret["traceback"] = []string{}
+		}
 	}
 	return ret
 }
 
-// json returns a JSON-like map representing a cell.
-func (cell *Cell) json() map[string]interface{} {
+// json returns a JSON-like map representing a cell. If id is not empty,
+// it is written as the cell id.
+func (cell *Cell) json(id string) map[string]interface{} {
 	emptyMap := make(map[string]interface{})
 	ret := make(map[string]interface{})
-	var outputs []interface{}
-	// TODO(salikh): Do we need to handle any other kind of output?
-	for name, output := range cell.Outputs {
-		o := make(map[string]interface{})
-		o["name"] = name
-		o["output_type"] = "stream"
-		o["text"] = marshalText(output)
-		outputs = append(outputs, o)
+	copyFields(ret, cell.Extra)
+	if id != "" {
+		ret["id"] = id
 	}
 	if cell.Metadata != nil {
 		ret["metadata"] = cell.Metadata
@@ -193,77 +502,133 @@ func (cell *Cell) json() map[string]interface{} {
 	}
 	ret["cell_type"] = cell.Type
 	if cell.Type == "code" {
-		ret["execution_count"] = nil
-		if len(outputs) > 0 {
-			ret["outputs"] = outputs
-		} else {
-			// Empty slice.
-			ret["outputs"] = []interface{}{}
+		ret["execution_count"] = cell.ExecutionCount
+		// Empty slice if there are no outputs.
+		outputs := []interface{}{}
+		for _, output := range cell.Outputs {
+			outputs = append(outputs, output.json())
 		}
+		ret["outputs"] = outputs
+	}
+	if cell.Attachments != nil {
+		ret["attachments"] = cell.Attachments
 	}
 	ret["source"] = marshalText(cell.Source)
 	return ret
 }
 
 // Marshal produces a JSON content suitable for writing to .ipynb file.
+// The output follows the formatting that Jupyter uses when saving notebooks
+// (sorted keys, one space indent, no escaping of HTML characters), so
+// a notebook saved by Jupyter is written back byte-for-byte after Parse.
+// If the notebook format is 4.5 or later, cells without an ID get a stable
+// generated ID.
 func (n *Notebook) Marshal() ([]byte, error) {
 	output := make(map[string]interface{})
-	var cells []interface{}
-	for _, cell := range n.Cells {
-		cells = append(cells, cell.json())
+	copyFields(output, n.Extra)
+	var ids []string
+	if n.hasCellIDs() {
+		ids = n.cellIDs()
+	}
+	cells := []interface{}{}
+	for i, cell := range n.Cells {
+		id := cell.ID
+		if ids != nil {
+			id = ids[i]
+		}
+		cells = append(cells, cell.json(id))
 	}
 	output["nbformat"] = n.NBFormat
 	output["nbformat_minor"] = n.NBFormatMinor
-	output["metadata"] = n.Metadata
+	if n.Metadata != nil {
+		output["metadata"] = n.Metadata
+	} else {
+		output["metadata"] = make(map[string]interface{})
+	}
 	output["cells"] = cells
-	return json.Marshal(output)
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", " ")
+	err := enc.Encode(output)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
 }
 
 // MapCells runs a function on each cell and replaces the cell with the returned values.
 // If mapFunc returns error, the function terminates the iteration and returns the error.
 func (n *Notebook) MapCells(mapFunc func(c *Cell) ([]*Cell, error)) (*Notebook, error) {
+	return n.mapCells(mapFunc, false)
+}
+
+// mapCells implements MapCells. If deriveIDs is true, the IDs of the returned
+// cells are derived from the ID of the cell they were produced from.
+func (n *Notebook) mapCells(mapFunc func(c *Cell) ([]*Cell, error), deriveIDs bool) (*Notebook, error) {
+	var ids []string
+	if deriveIDs {
+		ids = n.cellIDs()
+	}
 	var out []*Cell
-	for _, cell := range n.Cells {
+	for i, cell := range n.Cells {
 		ncell, err := mapFunc(cell)
 		if err != nil {
 			return nil, err
 		}
+		if deriveIDs {
+			for j, c := range ncell {
+				if c == cell {
+					// Do not modify the input cell in place.
+					clone := *c
+					c = &clone
+					ncell[j] = c
+				}
+				c.ID = deriveCellID(ids[i], j)
+			}
+		}
 		if len(ncell) > 0 {
 			out = append(out, ncell...)
 		}
 	}
-	return &Notebook{
+	ret := &Notebook{
 		NBFormat:      n.NBFormat,
 		NBFormatMinor: n.NBFormatMinor,
 		Metadata:      n.Metadata,
 		Cells:         out,
-	}, nil
+		Extra:         n.Extra,
+	}
+	if deriveIDs && ret.NBFormat == 4 && ret.NBFormatMinor < 5 {
+		// The cell IDs are only valid since nbformat 4.5.
+		ret.NBFormatMinor = 5
+	}
+	return ret, nil
 }
 
-// TODO(salikh): Implement smarter replacement strategies similar to jassign, e.g.
-// x = 1 # SOLUTION   ===>   x = ...
 var (
 	assignmentMetadataRegex     = regexp.MustCompile("(?m)^[ \t]*# ASSIGNMENT METADATA")
 	exerciseMetadataRegex       = regexp.MustCompile("(?m)^[ \t]*# EXERCISE METADATA")
-	languageMetadataRegex       = regexp.MustCompile("\\*\\*lang:([a-z]{2})\\*\\*")
 	tripleBacktickedRegex       = regexp.MustCompile("(?ms)^```([^`]|`[^`]|``[^`])*^```")
 	testMarkerRegex             = regexp.MustCompile("(?ms)^[ \t]*# TEST[^\n]*[\n]*")
 	studentTestRegex            = regexp.MustCompile("(?ms)^[ \t]*#? ?%%studenttest(?:[ \t]+([a-zA-Z][a-zA-Z0-9_]*))[ \t]*[\n]*")
-	inlineTestRegex             = regexp.MustCompile("(?ms)^[ \t]*#? ?%%inlinetest(?:[ \t]+([a-zA-Z][a-zA-Z0-9_]*))[ \t]*[\n]*")
+	inlineTestRegex             = regexp.MustCompile("(?ms)^[ \t]*#? ?%%inlinetest(?:[ \t]+([a-zA-Z][a-zA-Z0-9_]*))(?:[ \t]+(hidden))?[ \t]*[\n]*")
 	inlineOrStudentTestRegex    = regexp.MustCompile("(?ms)^[ \t]*#? ?%%(?:inline|student)test(?:[ \t]+([a-zA-Z][a-zA-Z0-9_]*))[ \t]*[\n]*")
 	solutionMagicRegex          = regexp.MustCompile("^[ \t]*%%solution[^\n]*\n")
 	solutionBeginRegex          = regexp.MustCompile("(?m)^([ \t]*)# BEGIN SOLUTION *\n")
 	solutionEndRegex            = regexp.MustCompile("(?m)^[ \t]*# END SOLUTION *")
 	promptBeginRegex            = regexp.MustCompile("(?m)^[ \t]*\"\"\" # BEGIN PROMPT *\n|^[ \t]*# BEGIN PROMPT *\n")
 	promptEndRegex              = regexp.MustCompile("(?m)\n[ \t]*\"\"\" # END PROMPT *\n|\n[ \t]*# END PROMPT *\n")
-	unittestBeginRegex          = regexp.MustCompile("(?m)^[ \t]*# BEGIN UNITTEST *\n")
+	solutionLineRegex           = regexp.MustCompile("^([ \t]*)([^# \t].*?)[ \t]*# SOLUTION( NO PROMPT)? *$")
+	hiddenBeginRegex            = regexp.MustCompile("(?m)^[ \t]*# BEGIN HIDDEN *\n")
+	hiddenEndRegex              = regexp.MustCompile("(?m)^[ \t]*# END HIDDEN *")
+	unittestBeginRegex          = regexp.MustCompile("(?m)^[ \t]*# BEGIN UNITTEST( hidden)? *\n")
 	unittestEndRegex            = regexp.MustCompile("(?m)^[ \t]*# END UNITTEST *")
 	autotestMarkerRegex         = regexp.MustCompile("%autotest|autotest\\(")
 	submissionMarkerRegex       = regexp.MustCompile("(?ms)^[ \t]*%%(submission|solution)")
 	templateOrReportMarkerRegex = regexp.MustCompile("(?ms)^[ \t]*%%(template|report)|report\\(")
 	masterOnlyMarkerRegex       = regexp.MustCompile("(?ms)^[ \t]*#+ MASTER ONLY[^\n]*\n?")
 	importRegex                 = regexp.MustCompile("(?m)^[ \t]*#[ \t]*import[ \t]+([a-zA-Z][a-zA-Z0-9_]*)[ \t]*$")
-	templateRegex               = regexp.MustCompile("(?m)^[ \t]*%%template(?:[ \t]+([a-zA-Z][a-zA-Z0-9_]*))\n")
+	templateRegex               = regexp.MustCompile("(?m)^[ \t]*%%template(?:[ \t]+([a-zA-Z][a-zA-Z0-9_]*))(?:[ \t]+(go))?[ \t]*\n")
 	reportRegex                 = regexp.MustCompile("(?m)^[ \t]*%%report.*\n *([a-zA-Z][a-zA-Z0-9_]*)$")
 )
 
@@ -330,45 +695,85 @@ func extractMetadata(re *regexp.Regexp, source string) (metadata map[string]inte
 	return
 }
 
-// Language represents a type of natural languages in which a cell is written.
-type Language int
-
-const (
-	English Language = iota
-	Japanese
-	AnyLanguage
+var (
+	// assignmentTargetRegex matches the left-hand side of an assignment statement,
+	// including augmented assignments, e.g. "x =", "a, b =", "self.x +=" or "d['k'] =".
+	assignmentTargetRegex = regexp.MustCompile(`^((?:[a-zA-Z_][a-zA-Z0-9_.]*(?:\[[^\]=]*\])*[ \t]*,?[ \t]*)+(?:[-+*/%@&|^]|//|\*\*|<<|>>)?=)[ \t]*[^= \t]`)
+	// returnRegex matches the statements that keep the keyword in the placeholder.
+	returnRegex = regexp.MustCompile(`^(return|yield)\b`)
 )
 
-func (l Language) String() string {
-	switch l {
-	case English:
-		return "en"
-	case Japanese:
-		return "ja"
-	default:
-		return ""
+// solutionLinePlaceholder returns the student version of the code line
+// marked with # SOLUTION: the right-hand side of an assignment or a return
+// statement is replaced with "...", any other statement is replaced
+// with "..." entirely.
+func solutionLinePlaceholder(code string) string {
+	if m := assignmentTargetRegex.FindStringSubmatch(code); m != nil {
+		return m[1] + " ..."
+	}
+	if m := returnRegex.FindStringSubmatch(code); m != nil {
+		return m[1] + " ..."
 	}
+	return "..."
 }
 
-func (l Language) filterText(s string) string {
-	// If no language is specified, use s by removing language metadata.
-	if l == AnyLanguage {
-		return languageMetadataRegex.ReplaceAllString(s, "")
+// hasSolutionLines returns true if the code has any line-level solution markers,
+// i.e. # SOLUTION, # SOLUTION NO PROMPT or # BEGIN HIDDEN.
+func hasSolutionLines(source string) bool {
+	if hiddenBeginRegex.MatchString(source) {
+		return true
 	}
-
-	var ms [][]byte
-	if ms = languageMetadataRegex.FindSubmatch([]byte(s)); len(ms) == 0 {
-		// We always use a cell as is where no language is specified.
-		return s
+	for _, line := range strings.Split(source, "\n") {
+		if solutionLineRegex.MatchString(line) {
+			return true
+		}
 	}
+	return false
+}
 
-	// If the language used in the cell is different from l, return the empty string.
-	if string(ms[1]) != l.String() {
-		return ""
+// replaceSolutionLines applies the line-level solution markers to the code:
+//
+//   x = 1  # SOLUTION              ===>   x = ...
+//   foo()  # SOLUTION              ===>   ...
+//   bar()  # SOLUTION NO PROMPT    ===>   (removed)
+//   # BEGIN HIDDEN
+//   ...                            ===>   (removed)
+//   # END HIDDEN
+//
+// The placeholders keep the indentation of the replaced line.
+func replaceSolutionLines(source string) (string, error) {
+	var lines []string
+	hidden := false
+	for _, line := range strings.Split(source, "\n") {
+		switch {
+		case hiddenBeginRegex.MatchString(line + "\n"):
+			if hidden {
+				return "", fmt.Errorf("BEGIN HIDDEN inside another BEGIN HIDDEN")
+			}
+			hidden = true
+			continue
+		case hiddenEndRegex.MatchString(line):
+			if !hidden {
+				return "", fmt.Errorf("END HIDDEN without BEGIN HIDDEN")
+			}
+			hidden = false
+			continue
+		case hidden:
+			continue
+		}
+		if m := solutionLineRegex.FindStringSubmatch(line); m != nil {
+			if m[3] != "" {
+				// # SOLUTION NO PROMPT
+				continue
+			}
+			line = m[1] + solutionLinePlaceholder(m[2])
+		}
+		lines = append(lines, line)
 	}
-
-	// Use s by removing language metadata.
-	return languageMetadataRegex.ReplaceAllString(s, "")
+	if hidden {
+		return "", fmt.Errorf("BEGIN HIDDEN has no matching END HIDDEN")
+	}
+	return strings.Join(lines, "\n"), nil
 }
 
 // CleanForStudent takes a code cell and produces a clean student version,
@@ -423,6 +828,10 @@ func CleanForStudent(cell *Cell, assignmentMetadata, exerciseMetadata map[string
 		// Skip the %%inline test cell.
 		return nil, nil
 	}
+	if ioTestRegex.MatchString(source) {
+		// Skip the %%iotest cell.
+		return nil, nil
+	}
 	if m := solutionMagicRegex.FindStringIndex(source); m != nil {
 		// Strip the line with %%solution magic.
 		source = source[m[1]:]
@@ -444,6 +853,18 @@ func CleanForStudent(cell *Cell, assignmentMetadata, exerciseMetadata map[string
 		// Remove the solution.
 		mbeg := solutionBeginRegex.FindAllStringSubmatchIndex(source, -1)
 		if mbeg == nil {
+			if hasSolutionLines(source) {
+				// Only the marked lines are replaced.
+				source, err := replaceSolutionLines(source)
+				if err != nil {
+					return nil, err
+				}
+				return &Cell{
+					Type:     "code",
+					Metadata: exerciseMetadata,
+					Source:   source,
+				}, nil
+			}
 			// No BEGIN/END SOLUTION markers. Just return "..."
 			return &Cell{
 				Type:     "code",
@@ -475,10 +896,14 @@ func CleanForStudent(cell *Cell, assignmentMetadata, exerciseMetadata map[string
 				glog.V(3).Infof("last part: %q", source[mend[i][1]:])
 			}
 		}
+		source, err := replaceSolutionLines(strings.Join(outputs, ""))
+		if err != nil {
+			return nil, err
+		}
 		return &Cell{
 			Type:     "code",
 			Metadata: exerciseMetadata,
-			Source:   strings.Join(outputs, ""),
+			Source:   source,
 		}, nil
 	}
 	// Skip # BEGIN UNITTEST, %%submission, %%solution, %autotest and # MASTER ONLY cells.
@@ -490,25 +915,38 @@ func CleanForStudent(cell *Cell, assignmentMetadata, exerciseMetadata map[string
 		// Skip the cell.
 		return nil, nil
 	}
+	source, err := replaceSolutionLines(source)
+	if err != nil {
+		return nil, err
+	}
 	// Source may have been modified.
 	return &Cell{
 		Type:   "code",
 		Source: source,
 	}, nil
-	return &Cell{
-		Type:   cell.Type,
-		Source: source,
-	}, nil
+}
+
+// Options configures the conversion of master notebooks
+// by ToStudent and ToAutograder.
+type Options struct {
+	// DeriveCellIDs makes the cell IDs of the converted notebook derived
+	// from the IDs of the master cells they were produced from, so that
+	// they can be traced back to the master notebook. Master cells without
+	// an ID get a stable generated ID first. The converted notebook is
+	// upgraded to nbformat 4.5, which introduced the cell IDs.
+	DeriveCellIDs bool
 }
 
 // ToStudent converts a master notebook into the student notebook.
-func (n *Notebook) ToStudent(lang Language) (*Notebook, error) {
+func (n *Notebook) ToStudent(lang Language, opts Options) (*Notebook, error) {
 	// Assignment metadata is global for the notebook.
 	assignmentMetadata := make(map[string]interface{})
 	// Exercise metadata only applies to the next code block,
 	// and is nil otherwise.
 	var exerciseMetadata map[string]interface{}
-	transformed, err := n.MapCells(func(cell *Cell) ([]*Cell, error) {
+	// The language of the variants to keep for the cells with language markers.
+	variantLanguage := n.selectVariants(lang)
+	transformed, err := n.mapCells(func(cell *Cell) ([]*Cell, error) {
 		source := cell.Source
 		if cell.Type == "markdown" {
 			var err error
@@ -536,12 +974,16 @@ func (n *Notebook) ToStudent(lang Language) (*Notebook, error) {
 				// Skip # MASTER ONLY
 				return nil, nil
 			}
-			if source = lang.filterText(source); len(source) == 0 {
+			if source = filterVariants(source, variantLanguage[cell]); len(source) == 0 {
 				return nil, nil
 			}
 		}
 		if cell.Type != "code" {
-			return []*Cell{&Cell{Type: cell.Type, Source: source}}, nil
+			return []*Cell{&Cell{
+				Type:        cell.Type,
+				Source:      source,
+				Attachments: cell.Attachments,
+			}}, nil
 		}
 		if m := testMarkerRegex.FindStringIndex(source); m != nil {
 			// Remove the # TEST marker.
@@ -555,6 +997,10 @@ func (n *Notebook) ToStudent(lang Language) (*Notebook, error) {
 			// Skip the %%inline test cell.
 			return nil, nil
 		}
+		if ioTestRegex.MatchString(source) {
+			// Skip the %%iotest cell.
+			return nil, nil
+		}
 		if m := solutionMagicRegex.FindStringIndex(source); m != nil {
 			clean, err := CleanForStudent(cell, assignmentMetadata, exerciseMetadata, lang)
 			if err != nil {
@@ -571,12 +1017,22 @@ func (n *Notebook) ToStudent(lang Language) (*Notebook, error) {
 			// Skip the cell.
 			return nil, nil
 		}
+		source, err := replaceSolutionLines(source)
+		if err != nil {
+			return nil, err
+		}
 		// Source may have been modified.
-		return []*Cell{&Cell{
+		clean := &Cell{
 			Type:   "code",
 			Source: source,
-		}}, nil
-	})
+		}
+		if source == cell.Source {
+			// Keep the example outputs of the cells that are copied unchanged.
+			clean.ExecutionCount = cell.ExecutionCount
+			clean.Outputs = cell.Outputs
+		}
+		return []*Cell{clean}, nil
+	}, opts.DeriveCellIDs)
 	if err != nil {
 		return nil, err
 	}
@@ -600,6 +1056,26 @@ func cloneMetadata(metadata map[string]interface{}, extras ...interface{}) map[s
 	return ret
 }
 
+// MasterSolutionFilename is the name of the file in the exercise master
+// directory that contains the master solution of the exercise.
+const MasterSolutionFilename = "master_solution.py"
+
+// IsMasterFile reports whether the file produced by ToAutograder is only
+// needed to check the master notebook, like MasterSolutionFilename and
+// MasterOutputsFilename. Such files
+// go into a separate master directory with the same layout as the autograder
+// directory, which must not be deployed to the workers, as the submitted code
+// could read them there.
+func IsMasterFile(filename string) bool {
+	return filename == MasterSolutionFilename || filename == MasterOutputsFilename
+}
+
+// HiddenTestMarker is the first line of the test files of the hidden tests,
+// declared with "# BEGIN UNITTEST hidden" or "%%inlinetest Name hidden".
+// The hidden tests count toward the score, but the autograder leaves their
+// names and logs out of the student-facing report.
+const HiddenTestMarker = "# HIDDEN TEST"
+
 var (
 	// testClassRegex detects the test cases that need to be written down into a separate file.
 	// The name of the file is derived from the name of the test class.
@@ -611,7 +1087,7 @@ var (
 // and the file name is stored in metadata["filename"]. It is later written into the autograder directory.
 // Note: the autograder notebooks do not exist in the form of notebook files, it is only a convenience
 // representation that it actually saved in the directory autograder format.
-func (n *Notebook) ToAutograder() (*Notebook, error) {
+func (n *Notebook) ToAutograder(opts Options) (*Notebook, error) {
 	// Assignment metadata is global for the notebook.
 	assignmentMetadata := make(map[string]interface{})
 	var assignmentID string
@@ -623,8 +1099,11 @@ func (n *Notebook) ToAutograder() (*Notebook, error) {
 	// but including the student test cells.
 	var globalContext []*Cell
 	var exerciseContext []*Cell
-	transformed, err := n.MapCells(func(cell *Cell) ([]*Cell, error) {
+	transformed, err := n.mapCells(func(cell *Cell) ([]*Cell, error) {
 		source := cell.Source
+		// config is the cell with the exercise config (limits and points),
+		// emitted when the exercise metadata declares any.
+		var config *Cell
 		if cell.Type == "markdown" {
 			var err error
 			if hasMetadata(assignmentMetadataRegex, cell.Source) {
@@ -659,19 +1138,50 @@ func (n *Notebook) ToAutograder() (*Notebook, error) {
 					exerciseID = id
 				}
 				glog.V(3).Infof("parsed metadata: %s", exerciseMetadata)
+				config, err = configCell(exerciseMetadata, assignmentID)
+				if err != nil {
+					return nil, fmt.Errorf("exercise %s: %s", exerciseID, err)
+				}
 				// Reset the exercise context.
 				exerciseContext = nil
 			}
 		}
 		if cell.Type != "code" {
+			if config != nil {
+				return []*Cell{config}, nil
+			}
 			// We do not need to emit non-code cells.
 			return nil, nil
 		}
-		if m := inlineTestRegex.FindStringSubmatchIndex(source); m != nil {
+		if m := ioTestRegex.FindStringSubmatchIndex(source); m != nil {
+			name := source[m[2]:m[3]]
+			input, output, err := splitIOTest(source[m[1]:])
+			if err != nil {
+				return nil, fmt.Errorf("iotest %s: %s", name, err)
+			}
+			// Store the input and the expected output into the cases directory,
+			// the autograder runs the submission on each input.
+			return []*Cell{
+				&Cell{
+					Type:     "code",
+					Metadata: cloneMetadata(exerciseMetadata, "filename", IOTestDir+"/"+name+".in", "assignment_id", assignmentID),
+					Source:   input,
+				},
+				&Cell{
+					Type:     "code",
+					Metadata: cloneMetadata(exerciseMetadata, "filename", IOTestDir+"/"+name+".out", "assignment_id", assignmentID),
+					Source:   output,
+				},
+			}, nil
+		} else if m := inlineTestRegex.FindStringSubmatchIndex(source); m != nil {
 			// Extract the inline test name.
 			name := source[m[2]:m[3]]
+			hidden := m[4] >= 0
 			// Peel off the magic string.
 			source = source[m[1]:]
+			if hidden {
+				source = HiddenTestMarker + "\n" + source
+			}
 			var parts []string
 			// Create an inline test.
 			for _, c := range globalContext {
@@ -699,7 +1209,8 @@ func (n *Notebook) ToAutograder() (*Notebook, error) {
 					Source:   source + "\n",
 				},
 			}, nil
-		} else if unittestBeginRegex.MatchString(source) {
+		} else if m := unittestBeginRegex.FindStringSubmatchIndex(source); m != nil {
+			hidden := m[2] >= 0
 			text, err := cutText(unittestBeginRegex, unittestEndRegex, source)
 			if err != nil {
 				return nil, err
@@ -717,6 +1228,9 @@ func (n *Notebook) ToAutograder() (*Notebook, error) {
 				imports = append(imports, "import "+m[1]+"\n")
 			}
 			text = strings.Join(imports, "") + text
+			if hidden {
+				text = HiddenTestMarker + "\n" + text
+			}
 			glog.V(3).Infof("metadata: %v, exercise_id: %q", exerciseMetadata, exerciseID)
 			glog.V(3).Infof("parsed unit test: %s\n", text)
 			return []*Cell{&Cell{
@@ -729,8 +1243,12 @@ func (n *Notebook) ToAutograder() (*Notebook, error) {
 			if err != nil {
 				return nil, err
 			}
+			outputs, err := masterOutputsCell(cell, exerciseMetadata, assignmentID)
+			if err != nil {
+				return nil, err
+			}
 			// Store the untouched source cell value.
-			return []*Cell{
+			cells := []*Cell{
 				// empty_source.py is an easy way to access empty submission content
 				// from python code by referencing empty_source.source.
 				&Cell{
@@ -745,7 +1263,20 @@ func (n *Notebook) ToAutograder() (*Notebook, error) {
 					Metadata: cloneMetadata(exerciseMetadata, "filename", "empty_submission.py", "assignment_id", assignmentID),
 					Source:   clean.Source,
 				},
-			}, nil
+				// master_solution.py is the master solution, used to check
+				// that it passes all tests of the exercise.
+				&Cell{
+					Type:     "code",
+					Metadata: cloneMetadata(exerciseMetadata, "filename", MasterSolutionFilename, "assignment_id", assignmentID),
+					Source:   source[m[1]:],
+				},
+			}
+			if outputs != nil {
+				// master_outputs.json are the outputs of the master solution,
+				// given to the tests when checking the master solution.
+				cells = append(cells, outputs)
+			}
+			return cells, nil
 		} else {
 			// For every non-solution and non-inline test code cell, add it to global
 			// or exercise context (for inline tests).
@@ -760,12 +1291,22 @@ func (n *Notebook) ToAutograder() (*Notebook, error) {
 		// Extract the reporter into a script. The reporter script takes the JSON on the standard input,
 		// expecting 'results' field to contain an outcome dictionary, and 'logs' field to contain the
 		// dictionary of test logs, keyed by the test name.
+		// The templates marked with %%template NAME go are Go html/template templates,
+		// which are written as is into NAME.tmpl and rendered by the autograder in-process.
 		if m := templateRegex.FindStringSubmatchIndex(source); m != nil {
 			// Extract the template name.
 			name := source[m[2]:m[3]]
-			filename := name + ".py"
+			isGo := m[4] >= 0
 			// Cut the magic string.
 			source = source[m[1]:]
+			if isGo {
+				return []*Cell{&Cell{
+					Type:     "code",
+					Metadata: cloneMetadata(exerciseMetadata, "filename", name+".tmpl", "assignment_id", assignmentID),
+					Source:   source,
+				}}, nil
+			}
+			filename := name + ".py"
 			return []*Cell{&Cell{
 				Type:     "code",
 				Metadata: cloneMetadata(exerciseMetadata, "filename", filename, "assignment_id", assignmentID),
@@ -792,7 +1333,7 @@ if __name__ == '__main__':
 		}
 		// Do not emit other code cells.
 		return nil, nil
-	})
+	}, opts.DeriveCellIDs)
 	if err != nil {
 		return nil, err
 	}
@@ -1,6 +1,7 @@
 package notebook
 
 import (
+	"io/ioutil"
 	"strings"
 	"testing"
 
@@ -138,6 +139,29 @@ x = 1
 			input: []string{"result, log = %autotest HelloTest\nx = 1"},
 			want:  []string{},
 		},
+		{
+			name:  "Inlinetest2",
+			input: []string{"context1", "%%inlinetest A hidden\ninline1"},
+			want:  []string{"context1"},
+		},
+		{
+			name:  "IOTest1",
+			input: []string{"context1", "%%iotest Sum\n1 2\n# OUTPUT\n3\n"},
+			want:  []string{"context1"},
+		},
+		{
+			name: "HiddenUnittest1",
+			input: []string{`
+# BEGIN UNITTEST hidden
+# import submission
+import unittest
+
+class MyTest(unittest.TestCase):
+	def test1(self):
+		pass
+# END UNITTEST`},
+			want: []string{},
+		},
 		{
 			name:  "Studenttest1",
 			input: []string{"%%studenttest name\naaa\nbbb"},
@@ -148,11 +172,46 @@ x = 1
 			input: []string{"%%inlinetest name\naaa\nbbb"},
 			want:  []string{},
 		},
+		{
+			name:  "SolutionLine1",
+			input: []string{"x = 1 # SOLUTION\nprint(x)"},
+			want:  []string{"x = ...\nprint(x)"},
+		},
+		{
+			name:  "SolutionLine2_Indent",
+			input: []string{"def f(a):\n  b, c = a, 2  # SOLUTION\n  b += c # SOLUTION\n  return b * c  # SOLUTION"},
+			want:  []string{"def f(a):\n  b, c = ...\n  b += ...\n  return ..."},
+		},
+		{
+			name:  "SolutionLine3_Statement",
+			input: []string{"for x in y:\n    print(x == 1)  # SOLUTION"},
+			want:  []string{"for x in y:\n    ..."},
+		},
+		{
+			name:  "SolutionLine4_NoPrompt",
+			input: []string{"import os  # SOLUTION NO PROMPT\nx = 1"},
+			want:  []string{"x = 1"},
+		},
+		{
+			name:  "SolutionLine5_Hidden",
+			input: []string{"x = 1\n  # BEGIN HIDDEN\n  y = 2\n  # END HIDDEN\nz = 3"},
+			want:  []string{"x = 1\nz = 3"},
+		},
+		{
+			name:  "SolutionLine6_SolutionCell",
+			input: []string{"%%solution\nself.x = 1 # SOLUTION\n# BEGIN SOLUTION\ny = 2\n# END SOLUTION"},
+			want:  []string{"self.x = ...\n..."},
+		},
+		{
+			name:  "SolutionLine7_CommentOnly",
+			input: []string{"# SOLUTION\nx = 1"},
+			want:  []string{"# SOLUTION\nx = 1"},
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			n := createNotebook(tt.input)
-			got, err := n.ToStudent(AnyLanguage)
+			got, err := n.ToStudent(AnyLanguage, Options{})
 			if err != nil {
 				t.Errorf("ToStudent([%s]) returned error %s, want success",
 					strings.Join(tt.input, "]["), err)
@@ -263,16 +322,74 @@ class MyTest(unittest.TestCase):
 			input: []string{"context1", "context2", "%%inlinetest A\ninline1\ninline2"},
 			want:  []string{"context1\ncontext2\n", "inline1\ninline2\n"},
 		},
+		{
+			name:  "Inlinetest2",
+			input: []string{"context1", "%%inlinetest A hidden\ninline1"},
+			want:  []string{"context1\n", "# HIDDEN TEST\ninline1\n"},
+		},
+		{
+			name: "HiddenUnittest1",
+			input: []string{`
+# BEGIN UNITTEST hidden
+# import submission
+import unittest
+
+class MyTest(unittest.TestCase):
+	def test1(self):
+		pass
+# END UNITTEST`},
+			want: []string{`# HIDDEN TEST
+import submission
+# import submission
+import unittest
+
+class MyTest(unittest.TestCase):
+	def test1(self):
+		pass
+`},
+		},
 		{
 			name:  "Studenttest1",
 			input: []string{"context1", "context2", "%%studenttest A\ninline1\ninline2"},
 			want:  []string{},
 		},
+		{
+			name:  "MasterSolution1",
+			input: []string{"%%solution\ndef f():\n  # BEGIN SOLUTION\n  return 1\n  # END SOLUTION"},
+			want: []string{
+				"source = \"\"\"def f():\n  ...\"\"\"",
+				"def f():\n  ...",
+				"def f():\n  # BEGIN SOLUTION\n  return 1\n  # END SOLUTION",
+			},
+		},
+		{
+			name:  "IOTest1",
+			input: []string{"%%iotest Sum\n1 2\n# OUTPUT\n3\n"},
+			want:  []string{"1 2\n", "3\n"},
+		},
+		{
+			name:  "GoTemplate1",
+			input: []string{"%%template HelloTest_template go\n{{badge .results.HelloTest}}\n"},
+			want:  []string{"{{badge .results.HelloTest}}\n"},
+		},
+		{
+			name: "Limits1",
+			input: []string{
+				"## Exercise\n```\n# EXERCISE METADATA\nexercise_id: A\ntime_limit: 2m\nmemory_limit: 1G\ncpu_limit: 2\n```",
+				"%%solution\npass",
+			},
+			want: []string{
+				"{\n  \"cpu_limit\": 2,\n  \"memory_limit\": 1073741824,\n  \"time_limit\": 120\n}\n",
+				`source = """..."""`,
+				"...",
+				"pass",
+			},
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			n := createNotebook(tt.input)
-			got, err := n.ToAutograder()
+			got, err := n.ToAutograder(Options{})
 			if err != nil {
 				t.Errorf("ToAutograder([%s]) returned error %s, want success",
 					strings.Join(tt.input, "]["), err)
@@ -303,3 +420,267 @@ class MyTest(unittest.TestCase):
 		})
 	}
 }
+
+func TestMarshalRoundTrip(t *testing.T) {
+	tests := []string{
+		"testdata/outputs.ipynb",
+	}
+	for _, filename := range tests {
+		t.Run(filename, func(t *testing.T) {
+			b, err := ioutil.ReadFile(filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			n, err := Parse(b)
+			if err != nil {
+				t.Fatalf("Parse(%s) returned error %s, want success", filename, err)
+			}
+			got, err := n.Marshal()
+			if err != nil {
+				t.Fatalf("Marshal() returned error %s, want success", err)
+			}
+			if string(got) != string(b) {
+				dmp := diffmatchpatch.New()
+				diffs := dmp.DiffMain(string(b), string(got), true)
+				t.Errorf("Marshal(Parse(%s)) is different from the input. Diffs:\n%s",
+					filename, dmp.DiffPrettyText(diffs))
+			}
+		})
+	}
+}
+
+func TestParseOutputs(t *testing.T) {
+	n, err := ParseFile("testdata/outputs.ipynb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(n.Cells) != 5 {
+		t.Fatalf("got %d cells, want 5", len(n.Cells))
+	}
+	if _, ok := n.Cells[0].Attachments["plot.png"]["image/png"]; !ok {
+		t.Errorf("markdown cell attachments = %v, want plot.png with image/png", n.Cells[0].Attachments)
+	}
+	cell := n.Cells[1]
+	if cell.ExecutionCount == nil || *cell.ExecutionCount != 1 {
+		t.Errorf("execution_count = %v, want 1", cell.ExecutionCount)
+	}
+	if len(cell.Outputs) != 2 {
+		t.Fatalf("got %d outputs, want 2", len(cell.Outputs))
+	}
+	stream := cell.Outputs[0]
+	if stream.Type != StreamOutput || stream.Name != "stdout" ||
+		stream.Text != "Hello, <world> & friends\nsecond line\n" {
+		t.Errorf("stream output = %#v, want stdout with two lines", stream)
+	}
+	result := cell.Outputs[1]
+	if text, ok := result.Data.Text("text/plain"); result.Type != ExecuteResultOutput || !ok || text != "42" {
+		t.Errorf("execute_result output = %#v, want text/plain 42", result)
+	}
+	cell = n.Cells[2]
+	if len(cell.Outputs) != 2 {
+		t.Fatalf("got %d outputs, want 2", len(cell.Outputs))
+	}
+	display := cell.Outputs[0]
+	if text, ok := display.Data.Text("text/html"); display.Type != DisplayDataOutput || !ok || text != "<b>bold</b>" {
+		t.Errorf("display_data output = %#v, want text/html <b>bold</b>", display)
+	}
+	if _, ok := display.Data.Text("application/json"); ok {
+		t.Errorf("Text(application/json) returned ok, want not textual")
+	}
+	errorOutput := cell.Outputs[1]
+	if errorOutput.Type != ErrorOutput || errorOutput.EName != "ZeroDivisionError" ||
+		errorOutput.EValue != "division by zero" || len(errorOutput.Traceback) != 2 {
+		t.Errorf("error output = %#v, want ZeroDivisionError with 2 traceback lines", errorOutput)
+	}
+	if n.Cells[3].ExecutionCount != nil {
+		t.Errorf("execution_count = %v, want nil", *n.Cells[3].ExecutionCount)
+	}
+}
+
+func TestToStudentKeepsOutputs(t *testing.T) {
+	count := 1
+	n := &Notebook{
+		Cells: []*Cell{
+			&Cell{
+				Type:           "code",
+				Source:         "print(1)",
+				ExecutionCount: &count,
+				Outputs:        []*Output{&Output{Type: StreamOutput, Name: "stdout", Text: "1\n"}},
+			},
+			&Cell{
+				Type:           "code",
+				Source:         "%%solution\nprint(2)",
+				ExecutionCount: &count,
+				Outputs:        []*Output{&Output{Type: StreamOutput, Name: "stdout", Text: "2\n"}},
+			},
+		},
+	}
+	got, err := n.ToStudent(AnyLanguage, Options{})
+	if err != nil {
+		t.Fatalf("ToStudent returned error %s, want success", err)
+	}
+	if len(got.Cells) != 2 {
+		t.Fatalf("got %d cells, want 2", len(got.Cells))
+	}
+	if len(got.Cells[0].Outputs) != 1 || got.Cells[0].Outputs[0].Text != "1\n" {
+		t.Errorf("unchanged cell outputs = %v, want the example output", got.Cells[0].Outputs)
+	}
+	if len(got.Cells[1].Outputs) != 0 {
+		t.Errorf("solution cell outputs = %v, want none", got.Cells[1].Outputs)
+	}
+}
+
+func TestParseV3(t *testing.T) {
+	n, err := ParseFile("testdata/v3.ipynb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n.NBFormat != 4 || n.NBFormatMinor != 0 {
+		t.Errorf("got nbformat %d.%d, want 4.0", n.NBFormat, n.NBFormatMinor)
+	}
+	if _, ok := n.Metadata["name"]; ok {
+		t.Errorf("metadata.name was not removed: %v", n.Metadata)
+	}
+	if len(n.Cells) != 3 {
+		t.Fatalf("got %d cells, want 3", len(n.Cells))
+	}
+	if n.Cells[0].Type != "markdown" || n.Cells[0].Source != "## Title" {
+		t.Errorf("heading cell = %s %q, want markdown %q", n.Cells[0].Type, n.Cells[0].Source, "## Title")
+	}
+	code := n.Cells[2]
+	if code.Type != "code" || code.Source != "print('hi')\n1+1" {
+		t.Errorf("code cell = %s %q, want code cell with the v3 input", code.Type, code.Source)
+	}
+	if code.ExecutionCount == nil || *code.ExecutionCount != 3 {
+		t.Errorf("execution_count = %v, want 3", code.ExecutionCount)
+	}
+	if len(code.Outputs) != 3 {
+		t.Fatalf("got %d outputs, want 3", len(code.Outputs))
+	}
+	if o := code.Outputs[0]; o.Type != StreamOutput || o.Name != "stdout" || o.Text != "hi\n" {
+		t.Errorf("stream output = %#v, want stdout hi", o)
+	}
+	if text, ok := code.Outputs[1].Data.Text("text/html"); code.Outputs[1].Type != ExecuteResultOutput || !ok || text != "<b>2</b>" {
+		t.Errorf("pyout output = %#v, want execute_result with text/html", code.Outputs[1])
+	}
+	if o := code.Outputs[2]; o.Type != ErrorOutput || o.EName != "ValueError" {
+		t.Errorf("pyerr output = %#v, want error ValueError", o)
+	}
+}
+
+func TestParseUnsupportedVersion(t *testing.T) {
+	_, err := Parse([]byte(`{"nbformat": 2, "nbformat_minor": 0, "metadata": {}, "cells": []}`))
+	if err == nil {
+		t.Errorf("Parse of nbformat 2 returned success, want error")
+	}
+}
+
+func TestCellIDs(t *testing.T) {
+	input := `{"cells": [
+{"cell_type": "markdown", "id": "intro", "metadata": {}, "source": ["## Intro"]},
+{"cell_type": "code", "execution_count": null, "metadata": {}, "outputs": [], "source": ["%%solution\nx = 1"]},
+{"cell_type": "code", "execution_count": null, "metadata": {}, "outputs": [], "source": ["print(x)"]}
+], "metadata": {}, "nbformat": 4, "nbformat_minor": 5}`
+	n, err := Parse([]byte(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n.Cells[0].ID != "intro" {
+		t.Errorf("cell id = %q, want %q", n.Cells[0].ID, "intro")
+	}
+	b, err := n.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	n2, err := Parse(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, cell := range n2.Cells {
+		if cell.ID == "" {
+			t.Errorf("cell %d has no ID after Marshal", i)
+		}
+	}
+	if n2.Cells[0].ID != "intro" {
+		t.Errorf("cell id = %q after Marshal, want %q", n2.Cells[0].ID, "intro")
+	}
+	// Generated IDs must be stable.
+	b2, err := n.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != string(b2) {
+		t.Errorf("Marshal is not stable:\n%s\n--\n%s", b, b2)
+	}
+	student, err := n2.ToStudent(AnyLanguage, Options{DeriveCellIDs: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(student.Cells) != 3 {
+		t.Fatalf("got %d student cells, want 3", len(student.Cells))
+	}
+	for i, cell := range student.Cells {
+		if cell.ID != n2.Cells[i].ID {
+			t.Errorf("student cell %d has ID %q, want master ID %q", i, cell.ID, n2.Cells[i].ID)
+		}
+	}
+	n3 := createNotebook([]string{"context", "%%inlinetest A\nassert True"})
+	n3.Cells[1].ID = "inline"
+	autograder, err := n3.ToAutograder(Options{DeriveCellIDs: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var gotIDs []string
+	for _, cell := range autograder.Cells {
+		gotIDs = append(gotIDs, cell.ID)
+	}
+	if strings.Join(gotIDs, ",") != "inline,inline-1" {
+		t.Errorf("autograder cell IDs = %v, want [inline inline-1]", gotIDs)
+	}
+}
+
+func TestDeriveCellIDsUpgrade(t *testing.T) {
+	// The cell IDs are not valid in nbformat 4.1.
+	input := `{"cells": [
+{"cell_type": "markdown", "metadata": {}, "source": ["## Intro"]},
+{"cell_type": "code", "execution_count": null, "metadata": {}, "outputs": [], "source": ["%%solution\nx = 1"]},
+{"cell_type": "code", "execution_count": null, "metadata": {}, "outputs": [], "source": ["%%inlinetest A\nassert x == 1"]}
+], "metadata": {}, "nbformat": 4, "nbformat_minor": 1}`
+	n, err := Parse([]byte(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	student, err := n.ToStudent(AnyLanguage, Options{DeriveCellIDs: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	autograder, err := n.ToAutograder(Options{DeriveCellIDs: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, converted := range map[string]*Notebook{"student": student, "autograder": autograder} {
+		if converted.NBFormat != 4 || converted.NBFormatMinor != 5 {
+			t.Errorf("%s notebook has nbformat %d.%d, want 4.5", name, converted.NBFormat, converted.NBFormatMinor)
+		}
+		b, err := converted.Marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+		problems, err := Validate(b)
+		if err != nil || len(problems) > 0 {
+			t.Errorf("Validate(%s notebook) returned %v, %v, want no problems", name, problems, err)
+		}
+		parsed, err := Parse(b)
+		if err != nil {
+			t.Fatalf("Parse(%s notebook) returned error %s, want success", name, err)
+		}
+		for i, cell := range parsed.Cells {
+			if cell.ID != converted.Cells[i].ID {
+				t.Errorf("%s cell %d has ID %q after Parse, want %q", name, i, cell.ID, converted.Cells[i].ID)
+			}
+		}
+	}
+	if n.NBFormatMinor != 1 {
+		t.Errorf("master notebook nbformat_minor = %d, want it unchanged", n.NBFormatMinor)
+	}
+}
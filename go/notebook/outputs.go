@@ -0,0 +1,89 @@
+package notebook
+
+import (
+	"encoding/json"
+)
+
+// SubmissionOutputsFilename is the name of the file in the scratch directory
+// that contains the outputs recorded in the submitted solution cell, so that
+// the tests can check what the student code printed or displayed.
+// The format is described in SubmissionOutputs.
+const SubmissionOutputsFilename = "submission_outputs.json"
+
+// MasterOutputsFilename is the name of the file in the exercise master
+// directory that contains the outputs recorded in the master solution cell,
+// in the same format as SubmissionOutputsFilename. It is only written
+// if the master solution cell has outputs.
+const MasterOutputsFilename = "master_outputs.json"
+
+// SubmissionOutputs summarizes the outputs of a code cell into a JSON-like map
+// with the following fields:
+// * stdout, stderr: the concatenated text of the stream outputs.
+// * execute_result: the MIME bundle of the execute_result output, or nil.
+// * display_data: the list of the MIME bundles of the display_data outputs.
+// * errors: the list of the error outputs as objects with ename and evalue.
+// The textual content of the MIME bundles is joined into strings, e.g.
+// execute_result["text/plain"] is "42", and the other content is kept as is.
+func SubmissionOutputs(outputs []*Output) map[string]interface{} {
+	var stdout, stderr string
+	var executeResult map[string]interface{}
+	displayData := []interface{}{}
+	errors := []interface{}{}
+	for _, output := range outputs {
+		switch output.Type {
+		case StreamOutput:
+			if output.Name == "stderr" {
+				stderr += output.Text
+			} else {
+				stdout += output.Text
+			}
+		case ExecuteResultOutput:
+			executeResult = flattenBundle(output.Data)
+		case DisplayDataOutput:
+			displayData = append(displayData, flattenBundle(output.Data))
+		case ErrorOutput:
+			errors = append(errors, map[string]interface{}{
+				"ename":  output.EName,
+				"evalue": output.EValue,
+			})
+		}
+	}
+	return map[string]interface{}{
+		"stdout":         stdout,
+		"stderr":         stderr,
+		"execute_result": executeResult,
+		"display_data":   displayData,
+		"errors":         errors,
+	}
+}
+
+// flattenBundle returns the copy of the MIME bundle with the textual content
+// joined into strings.
+func flattenBundle(b MIMEBundle) map[string]interface{} {
+	ret := make(map[string]interface{})
+	for mimeType, v := range b {
+		if text, ok := b.Text(mimeType); ok {
+			ret[mimeType] = text
+		} else {
+			ret[mimeType] = v
+		}
+	}
+	return ret
+}
+
+// masterOutputsCell returns the autograder cell with the summarized outputs
+// of the master solution cell, or nil if the cell has no outputs.
+func masterOutputsCell(cell *Cell, exerciseMetadata map[string]interface{}, assignmentID string) (*Cell, error) {
+	if len(cell.Outputs) == 0 {
+		return nil, nil
+	}
+	b, err := json.MarshalIndent(SubmissionOutputs(cell.Outputs), "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return &Cell{
+		Type:     "code",
+		Metadata: cloneMetadata(exerciseMetadata, "filename", MasterOutputsFilename, "assignment_id", assignmentID),
+		Source:   string(b) + "\n",
+	}, nil
+}
@@ -0,0 +1,93 @@
+package notebook
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestSubmissionOutputs(t *testing.T) {
+	n, err := ParseFile("testdata/outputs.ipynb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		outputs []*Output
+		want    string
+	}{
+		{
+			name: "Empty",
+			want: `{"stdout": "", "stderr": "", "execute_result": null, "display_data": [], "errors": []}`,
+		},
+		{
+			name:    "StreamAndResult",
+			outputs: n.Cells[1].Outputs,
+			want: `{"stdout": "Hello, <world> & friends\nsecond line\n", "stderr": "",
+				"execute_result": {"text/plain": "42"}, "display_data": [], "errors": []}`,
+		},
+		{
+			name:    "DisplayAndError",
+			outputs: n.Cells[2].Outputs,
+			want: `{"stdout": "", "stderr": "", "execute_result": null,
+				"display_data": [{
+					"application/json": {"a": 1, "b": [1, 2]},
+					"image/png": "iVBORw0KGgo=\n",
+					"text/html": "<b>bold</b>",
+					"text/plain": "<IPython.core.display.HTML object>"
+				}],
+				"errors": [{"ename": "ZeroDivisionError", "evalue": "division by zero"}]}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Compare the JSON forms, as the outputs are written to a JSON file.
+			b, err := json.Marshal(SubmissionOutputs(tt.outputs))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got, want interface{}
+			if err := json.Unmarshal(b, &got); err != nil {
+				t.Fatal(err)
+			}
+			if err := json.Unmarshal([]byte(tt.want), &want); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("SubmissionOutputs() = %s, want %s", b, tt.want)
+			}
+		})
+	}
+}
+
+func TestToAutograderMasterOutputs(t *testing.T) {
+	n := createNotebook([]string{
+		"## Exercise\n```\n# EXERCISE METADATA\nexercise_id: A\n```",
+		"%%solution\nprint('hi')",
+	})
+	n.Cells[1].Outputs = []*Output{{Type: StreamOutput, Name: "stdout", Text: "hi\n"}}
+	got, err := n.ToAutograder(Options{})
+	if err != nil {
+		t.Fatalf("ToAutograder returned error %s, want success", err)
+	}
+	var outputs *Cell
+	for _, cell := range got.Cells {
+		if cell.Metadata["filename"] == MasterOutputsFilename {
+			outputs = cell
+		}
+	}
+	if outputs == nil {
+		t.Fatalf("ToAutograder did not emit %s", MasterOutputsFilename)
+	}
+	if !IsMasterFile(MasterOutputsFilename) {
+		t.Errorf("IsMasterFile(%s) = false, want it kept out of the autograder directory", MasterOutputsFilename)
+	}
+	var data map[string]interface{}
+	err = json.Unmarshal([]byte(outputs.Source), &data)
+	if err != nil {
+		t.Fatalf("%s is not valid JSON: %s", MasterOutputsFilename, err)
+	}
+	if data["stdout"] != "hi\n" {
+		t.Errorf("stdout = %q, want %q", data["stdout"], "hi\n")
+	}
+}
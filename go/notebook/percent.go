@@ -0,0 +1,324 @@
+package notebook
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// This file implements the percent format of notebooks (as used by Jupytext
+// and many editors), where a notebook is a plain Python script and each cell
+// starts with a "# %%" marker line:
+//
+//   # ---
+//   # jupyter:
+//   #   kernelspec:
+//   #     name: python3
+//   # ---
+//
+//   # %% [markdown]
+//   # # Title
+//   #
+//   # ```
+//   # # EXERCISE METADATA
+//   # exercise_id: hello
+//   # ```
+//
+//   # %%
+//   %%solution
+//   x = 1
+//
+// Markdown and raw cells are commented out line by line. Code cells are written
+// as is, in particular cell magics such as %%solution are not commented out.
+// Cell metadata is written on the marker line as key=value pairs with JSON
+// values. Trailing empty lines of cells are not preserved.
+
+var (
+	// percentMarkerRegex matches the cell marker line, capturing the rest of the line.
+	percentMarkerRegex = regexp.MustCompile(`^# %%(?:[ \t]+(.*))?$`)
+	// percentTypeRegex matches the cell type in the cell marker options.
+	percentTypeRegex = regexp.MustCompile(`^\[(markdown|md|raw)\][ \t]*`)
+	// percentKeyRegex matches the key of key=value cell metadata pair.
+	percentKeyRegex = regexp.MustCompile(`^([a-zA-Z_][a-zA-Z0-9_.-]*)=`)
+)
+
+const percentHeaderDelimiter = "# ---"
+
+// ParsePercentFile loads a notebook in the percent format from the specified
+// file and parses it into a Notebook structure.
+func ParsePercentFile(filename string) (*Notebook, error) {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %q: %s", filename, err)
+	}
+	n, err := ParsePercent(b)
+	if err != nil {
+		return nil, fmt.Errorf("error reading notebook from %q: %s", filename, err)
+	}
+	return n, nil
+}
+
+// uncommentLine removes the leading "# " or "#" from the line.
+func uncommentLine(line string) string {
+	if strings.HasPrefix(line, "# ") {
+		return line[2:]
+	}
+	if strings.HasPrefix(line, "#") {
+		return line[1:]
+	}
+	return line
+}
+
+// commentLine prepends "# " to the line, or "#" if the line is empty.
+func commentLine(line string) string {
+	if line == "" {
+		return "#"
+	}
+	return "# " + line
+}
+
+// fromYAML converts the values parsed by YAML library into the values
+// compatible with JSON encoder, i.e. converts the nested maps
+// to map[string]interface{}.
+func fromYAML(v interface{}) interface{} {
+	switch x := v.(type) {
+	case map[interface{}]interface{}:
+		ret := make(map[string]interface{})
+		for k, val := range x {
+			ret[fmt.Sprint(k)] = fromYAML(val)
+		}
+		return ret
+	case []interface{}:
+		ret := make([]interface{}, len(x))
+		for i, val := range x {
+			ret[i] = fromYAML(val)
+		}
+		return ret
+	default:
+		return v
+	}
+}
+
+// toYAML converts the values parsed by JSON decoder into the values that YAML
+// library writes in the natural form, i.e. converts json.Number to numbers.
+func toYAML(v interface{}) interface{} {
+	switch x := v.(type) {
+	case map[string]interface{}:
+		ret := make(map[string]interface{})
+		for k, val := range x {
+			ret[k] = toYAML(val)
+		}
+		return ret
+	case []interface{}:
+		ret := make([]interface{}, len(x))
+		for i, val := range x {
+			ret[i] = toYAML(val)
+		}
+		return ret
+	case json.Number:
+		if i, err := x.Int64(); err == nil {
+			return i
+		}
+		if f, err := x.Float64(); err == nil {
+			return f
+		}
+		return string(x)
+	default:
+		return v
+	}
+}
+
+// parsePercentOptions parses the part of the cell marker line after "# %%",
+// e.g. "[markdown] tags=["a", "b"]", into the cell type and metadata.
+func parsePercentOptions(options string) (cellType string, metadata map[string]interface{}, err error) {
+	cellType = "code"
+	options = strings.TrimSpace(options)
+	if m := percentTypeRegex.FindStringSubmatch(options); m != nil {
+		cellType = m[1]
+		if cellType == "md" {
+			cellType = "markdown"
+		}
+		options = options[len(m[0]):]
+	}
+	for options != "" {
+		m := percentKeyRegex.FindStringSubmatch(options)
+		if m == nil {
+			return "", nil, fmt.Errorf("cannot parse cell options %q", options)
+		}
+		options = options[len(m[0]):]
+		dec := json.NewDecoder(strings.NewReader(options))
+		dec.UseNumber()
+		var value interface{}
+		err = dec.Decode(&value)
+		if err != nil {
+			return "", nil, fmt.Errorf("cannot parse value of %s: %s", m[1], err)
+		}
+		if metadata == nil {
+			metadata = make(map[string]interface{})
+		}
+		metadata[m[1]] = value
+		options = strings.TrimLeft(options[dec.InputOffset():], " \t")
+	}
+	return
+}
+
+// ParsePercent parses a notebook in the percent format.
+func ParsePercent(b []byte) (*Notebook, error) {
+	text := strings.TrimSuffix(string(b), "\n")
+	lines := strings.Split(text, "\n")
+	n := &Notebook{
+		NBFormat:      4,
+		NBFormatMinor: 2,
+		Metadata:      make(map[string]interface{}),
+	}
+	if len(lines) > 0 && lines[0] == percentHeaderDelimiter {
+		var header []string
+		end := -1
+		for i := 1; i < len(lines); i++ {
+			if lines[i] == percentHeaderDelimiter {
+				end = i
+				break
+			}
+			header = append(header, uncommentLine(lines[i]))
+		}
+		if end < 0 {
+			return nil, fmt.Errorf("header has no closing %q", percentHeaderDelimiter)
+		}
+		lines = lines[end+1:]
+		var data map[string]interface{}
+		err := yaml.Unmarshal([]byte(strings.Join(header, "\n")), &data)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing header: %s", err)
+		}
+		if v, ok := data["jupyter"]; ok {
+			jupyter, ok := fromYAML(v).(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("header jupyter is not a map but %s", reflect.TypeOf(v))
+			}
+			if v, ok := jupyter["nbformat"].(int); ok {
+				n.NBFormat = v
+			}
+			if v, ok := jupyter["nbformat_minor"].(int); ok {
+				n.NBFormatMinor = v
+			}
+			delete(jupyter, "nbformat")
+			delete(jupyter, "nbformat_minor")
+			n.Metadata = jupyter
+		}
+	}
+	var cell *Cell
+	var body []string
+	flush := func() {
+		// Trailing empty lines separate cells and are not part of the cell.
+		for len(body) > 0 && strings.TrimSpace(body[len(body)-1]) == "" {
+			body = body[:len(body)-1]
+		}
+		if cell == nil {
+			// The code before the first cell marker.
+			if len(body) == 0 {
+				return
+			}
+			cell = &Cell{Type: "code"}
+		}
+		if cell.Type != "code" {
+			for i, line := range body {
+				body[i] = uncommentLine(line)
+			}
+		}
+		cell.Source = strings.Join(body, "\n")
+		n.Cells = append(n.Cells, cell)
+	}
+	for i, line := range lines {
+		m := percentMarkerRegex.FindStringSubmatch(line)
+		if m == nil {
+			if cell == nil && len(body) == 0 && strings.TrimSpace(line) == "" {
+				// Skip empty lines after the header.
+				continue
+			}
+			body = append(body, line)
+			continue
+		}
+		flush()
+		cellType, metadata, err := parsePercentOptions(m[1])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", i+1, err)
+		}
+		cell = &Cell{
+			Type:     cellType,
+			Metadata: metadata,
+		}
+		if cellType == "code" {
+			cell.Outputs = []*Output{}
+		}
+		body = nil
+	}
+	flush()
+	return n, nil
+}
+
+// MarshalPercent produces the notebook content in the percent format.
+// Outputs and execution counts of code cells are not written.
+func (n *Notebook) MarshalPercent() ([]byte, error) {
+	var buf bytes.Buffer
+	if len(n.Metadata) > 0 || n.NBFormat != 4 || n.NBFormatMinor != 2 {
+		jupyter := toYAML(n.Metadata).(map[string]interface{})
+		if n.NBFormat != 4 || n.NBFormatMinor != 2 {
+			jupyter["nbformat"] = n.NBFormat
+			jupyter["nbformat_minor"] = n.NBFormatMinor
+		}
+		header, err := yaml.Marshal(map[string]interface{}{"jupyter": jupyter})
+		if err != nil {
+			return nil, fmt.Errorf("error serializing header: %s", err)
+		}
+		buf.WriteString(percentHeaderDelimiter + "\n")
+		for _, line := range strings.Split(strings.TrimSuffix(string(header), "\n"), "\n") {
+			buf.WriteString(commentLine(line) + "\n")
+		}
+		buf.WriteString(percentHeaderDelimiter + "\n\n")
+	}
+	for i, cell := range n.Cells {
+		if i > 0 {
+			buf.WriteString("\n")
+		}
+		buf.WriteString("# %%")
+		if cell.Type != "code" {
+			buf.WriteString(" [" + cell.Type + "]")
+		}
+		var keys []string
+		for k := range cell.Metadata {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if !percentKeyRegex.MatchString(k + "=") {
+				return nil, fmt.Errorf("cell metadata key %q cannot be written in percent format", k)
+			}
+			value, err := json.Marshal(cell.Metadata[k])
+			if err != nil {
+				return nil, fmt.Errorf("error serializing cell metadata %s: %s", k, err)
+			}
+			buf.WriteString(" " + k + "=" + string(value))
+		}
+		buf.WriteString("\n")
+		source := strings.TrimRight(cell.Source, "\n")
+		if source == "" {
+			continue
+		}
+		for _, line := range strings.Split(source, "\n") {
+			if cell.Type != "code" {
+				line = commentLine(line)
+			} else if percentMarkerRegex.MatchString(line) {
+				return nil, fmt.Errorf("code cell line %q looks like a cell marker", line)
+			}
+			buf.WriteString(line + "\n")
+		}
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,138 @@
+package notebook
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const percentInput = `# ---
+# jupyter:
+#   kernelspec:
+#     display_name: Python 3
+#     name: python3
+#   nbformat: 4
+#   nbformat_minor: 5
+# ---
+
+# %% [markdown]
+# # Title
+#
+# ` + "```" + `
+# # ASSIGNMENT METADATA
+# assignment_id: "Hello"
+# ` + "```" + `
+
+# %% tags=["a", "b"] collapsed=true
+%%solution
+def hello():
+  # BEGIN SOLUTION
+  print("hello")
+  # END SOLUTION
+
+# %% [raw]
+# raw text
+`
+
+func TestParsePercent(t *testing.T) {
+	n, err := ParsePercent([]byte(percentInput))
+	if err != nil {
+		t.Fatalf("ParsePercent returned error %s, want success", err)
+	}
+	if n.NBFormat != 4 || n.NBFormatMinor != 5 {
+		t.Errorf("got nbformat %d.%d, want 4.5", n.NBFormat, n.NBFormatMinor)
+	}
+	kernelspec, ok := n.Metadata["kernelspec"].(map[string]interface{})
+	if !ok || kernelspec["name"] != "python3" {
+		t.Errorf("metadata = %v, want kernelspec with name python3", n.Metadata)
+	}
+	wantTypes := []string{"markdown", "code", "raw"}
+	wantSources := []string{
+		"# Title\n\n```\n# ASSIGNMENT METADATA\nassignment_id: \"Hello\"\n```",
+		"%%solution\ndef hello():\n  # BEGIN SOLUTION\n  print(\"hello\")\n  # END SOLUTION",
+		"raw text",
+	}
+	if len(n.Cells) != len(wantTypes) {
+		t.Fatalf("got %d cells, want %d", len(n.Cells), len(wantTypes))
+	}
+	for i, cell := range n.Cells {
+		if cell.Type != wantTypes[i] || cell.Source != wantSources[i] {
+			t.Errorf("cell %d = %s %q, want %s %q", i, cell.Type, cell.Source, wantTypes[i], wantSources[i])
+		}
+	}
+	if tags, ok := n.Cells[1].Metadata["tags"].([]interface{}); !ok || len(tags) != 2 {
+		t.Errorf("cell metadata = %v, want tags [a b]", n.Cells[1].Metadata)
+	}
+	if n.Cells[1].Metadata["collapsed"] != true {
+		t.Errorf("cell metadata = %v, want collapsed true", n.Cells[1].Metadata)
+	}
+}
+
+func TestMarshalPercent(t *testing.T) {
+	n, err := ParsePercent([]byte(percentInput))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := n.MarshalPercent()
+	if err != nil {
+		t.Fatalf("MarshalPercent returned error %s, want success", err)
+	}
+	want := strings.Replace(percentInput, "tags=[\"a\", \"b\"] collapsed=true", "collapsed=true tags=[\"a\",\"b\"]", 1)
+	if string(b) != want {
+		t.Errorf("MarshalPercent returned\n%s\n--\nwant\n%s\n--", b, want)
+	}
+}
+
+func TestPercentRoundTrip(t *testing.T) {
+	tests := []cellRewriteTest{
+		{
+			name:  "Empty",
+			input: []string{},
+		},
+		{
+			name:  "Code",
+			input: []string{"x = 1\ny = 2", "print(x)"},
+		},
+		{
+			name:  "Markdown",
+			input: []string{"## Header\n\ntext", "#comment\n\n  indented"},
+		},
+		{
+			name:  "Magics",
+			input: []string{"%%inlinetest A\nassert x == 1", "# %%studenttest B\nprint(x)"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n := createNotebook(tt.input)
+			n.NBFormat = 4
+			n.NBFormatMinor = 2
+			b, err := n.MarshalPercent()
+			if err != nil {
+				t.Fatalf("MarshalPercent returned error %s, want success", err)
+			}
+			got, err := ParsePercent(b)
+			if err != nil {
+				t.Fatalf("ParsePercent returned error %s, want success\n%s", err, b)
+			}
+			var gotSources []string
+			for _, cell := range got.Cells {
+				gotSources = append(gotSources, cell.Source)
+			}
+			var wantSources []string
+			if len(tt.input) > 0 {
+				wantSources = tt.input
+			}
+			if !reflect.DeepEqual(gotSources, wantSources) {
+				t.Errorf("round trip returned %q, want %q\n%s", gotSources, wantSources, b)
+			}
+		})
+	}
+}
+
+func TestMarshalPercentCellMarkerInCode(t *testing.T) {
+	n := createNotebook([]string{"x = 1\n# %% not a marker"})
+	if _, err := n.MarshalPercent(); err == nil {
+		t.Errorf("MarshalPercent returned success, want error for a cell marker line in code")
+	}
+}
@@ -0,0 +1,428 @@
+package notebook
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ValidationError describes one problem found by Validate.
+type ValidationError struct {
+	// Path is the JSON path of the offending value, e.g. ".cells[2].source".
+	Path string
+	// Message is a human-readable description of the problem.
+	Message string
+	// keyword is the JSON schema keyword that failed.
+	keyword string
+}
+
+func (e *ValidationError) Error() string {
+	return e.Path + ": " + e.Message
+}
+
+var (
+	schemaOnce sync.Once
+	// schemas maps the notebook format version (4.4 or 4.5) to the parsed schema.
+	schemas map[string]map[string]interface{}
+	// schemaRegexps caches the compiled patterns of the schema.
+	schemaRegexps = make(map[string]*regexp.Regexp)
+	schemaMu      sync.Mutex
+)
+
+// decodeJSON decodes the JSON document keeping the numbers as json.Number.
+func decodeJSON(b []byte) (interface{}, error) {
+	var v interface{}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	err := dec.Decode(&v)
+	if err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// loadSchemas parses the embedded nbformat schema and derives the schema
+// for notebooks before nbformat 4.5, which do not have cell IDs.
+func loadSchemas() {
+	schemas = make(map[string]map[string]interface{})
+	v, err := decodeJSON([]byte(nbformatSchema))
+	if err != nil {
+		panic(fmt.Sprintf("error parsing nbformat schema: %s", err))
+	}
+	schemas["4.5"] = v.(map[string]interface{})
+	v, _ = decodeJSON([]byte(nbformatSchema))
+	schema := v.(map[string]interface{})
+	properties := schema["properties"].(map[string]interface{})
+	properties["nbformat_minor"].(map[string]interface{})["minimum"] = json.Number("0")
+	definitions := schema["definitions"].(map[string]interface{})
+	for _, name := range []string{"raw_cell", "markdown_cell", "code_cell"} {
+		cell := definitions[name].(map[string]interface{})
+		delete(cell["properties"].(map[string]interface{}), "id")
+		var required []interface{}
+		for _, x := range cell["required"].([]interface{}) {
+			if x != "id" {
+				required = append(required, x)
+			}
+		}
+		cell["required"] = required
+	}
+	schemas["4.4"] = schema
+}
+
+// schemaRegexp returns the compiled pattern.
+func schemaRegexp(pattern string) (*regexp.Regexp, error) {
+	schemaMu.Lock()
+	defer schemaMu.Unlock()
+	if re, ok := schemaRegexps[pattern]; ok {
+		return re, nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	schemaRegexps[pattern] = re
+	return re, nil
+}
+
+// Validate checks the JSON document against the nbformat v4 JSON schema
+// and returns the list of problems found. Notebooks with nbformat_minor 5 or
+// later are checked against the nbformat 4.5 schema, earlier minor versions
+// against the same schema without cell IDs. Notebooks in nbformat v3 are
+// upgraded to v4 first, as Parse does. It returns an error only if the
+// document is not a valid JSON.
+func Validate(b []byte) ([]*ValidationError, error) {
+	v, err := decodeJSON(b)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing JSON: %s", err)
+	}
+	if data, ok := v.(map[string]interface{}); ok {
+		if version, err := parseInt(data["nbformat"]); err == nil && version == 3 {
+			err = upgradeV3(data)
+			if err != nil {
+				return []*ValidationError{{Path: ".", Message: "cannot upgrade nbformat v3 notebook: " + err.Error()}}, nil
+			}
+		}
+	}
+	return validateData(v), nil
+}
+
+// validateData validates the parsed JSON document.
+func validateData(v interface{}) []*ValidationError {
+	schemaOnce.Do(loadSchemas)
+	version := "4.5"
+	if data, ok := v.(map[string]interface{}); ok {
+		if minor, err := parseInt(data["nbformat_minor"]); err == nil && minor < 5 {
+			version = "4.4"
+		}
+	}
+	root := schemas[version]
+	errs := validateValue(root, root, v, "")
+	for _, e := range errs {
+		if e.Path == "" {
+			e.Path = "."
+		}
+	}
+	return errs
+}
+
+// validationErrorsString formats the list of validation errors into one message.
+func validationErrorsString(errs []*ValidationError) string {
+	var parts []string
+	for _, e := range errs {
+		parts = append(parts, e.Error())
+	}
+	return strings.Join(parts, "; ")
+}
+
+var identifierRegex = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// childPath returns the JSON path of the object property.
+func childPath(path, key string) string {
+	if identifierRegex.MatchString(key) {
+		return path + "." + key
+	}
+	return fmt.Sprintf("%s[%q]", path, key)
+}
+
+// resolveRef resolves a local JSON schema reference like "#/definitions/cell".
+func resolveRef(root map[string]interface{}, ref string) (map[string]interface{}, error) {
+	if !strings.HasPrefix(ref, "#/") {
+		return nil, fmt.Errorf("unsupported schema reference %q", ref)
+	}
+	var current interface{} = root
+	for _, part := range strings.Split(ref[2:], "/") {
+		m, ok := current.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("cannot resolve schema reference %q", ref)
+		}
+		current, ok = m[part]
+		if !ok {
+			return nil, fmt.Errorf("cannot resolve schema reference %q", ref)
+		}
+	}
+	schema, ok := current.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("schema reference %q is not a schema", ref)
+	}
+	return schema, nil
+}
+
+// jsonType returns the JSON schema type name of the value.
+func jsonType(v interface{}) string {
+	switch x := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case json.Number:
+		if _, err := x.Int64(); err == nil {
+			return "integer"
+		}
+		return "number"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	default:
+		return reflect.TypeOf(v).String()
+	}
+}
+
+// hasType checks whether the value has one of the types listed in the schema
+// "type" keyword.
+func hasType(v interface{}, types interface{}) bool {
+	actual := jsonType(v)
+	var list []interface{}
+	switch x := types.(type) {
+	case string:
+		list = []interface{}{x}
+	case []interface{}:
+		list = x
+	}
+	for _, t := range list {
+		if t == actual || (t == "number" && actual == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+// validateValue validates the value against the schema, and returns all problems found.
+// It implements the subset of JSON schema (draft 4) used by the nbformat schema.
+func validateValue(root, schema map[string]interface{}, v interface{}, path string) []*ValidationError {
+	var errs []*ValidationError
+	report := func(keyword, format string, args ...interface{}) {
+		errs = append(errs, &ValidationError{
+			Path:    path,
+			Message: fmt.Sprintf(format, args...),
+			keyword: keyword,
+		})
+	}
+	if ref, ok := schema["$ref"].(string); ok {
+		resolved, err := resolveRef(root, ref)
+		if err != nil {
+			report("$ref", "%s", err)
+			return errs
+		}
+		// Other keywords are ignored next to $ref.
+		return validateValue(root, resolved, v, path)
+	}
+	if types, ok := schema["type"]; ok && !hasType(v, types) {
+		report("type", "expected %s, got %s", types, jsonType(v))
+		return errs
+	}
+	if enum, ok := schema["enum"].([]interface{}); ok {
+		found := false
+		for _, x := range enum {
+			if reflect.DeepEqual(x, v) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			report("enum", "value %v is not one of %v", v, enum)
+		}
+	}
+	if oneOf, ok := schema["oneOf"].([]interface{}); ok {
+		errs = append(errs, validateOneOf(root, oneOf, v, path)...)
+	}
+	switch x := v.(type) {
+	case string:
+		if pattern, ok := schema["pattern"].(string); ok {
+			re, err := schemaRegexp(pattern)
+			if err != nil {
+				report("pattern", "invalid schema pattern %q: %s", pattern, err)
+			} else if !re.MatchString(x) {
+				report("pattern", "value %q does not match %q", x, pattern)
+			}
+		}
+		length := len([]rune(x))
+		if n, err := parseInt(schema["minLength"]); err == nil && length < n {
+			report("minLength", "value %q is shorter than %d", x, n)
+		}
+		if n, err := parseInt(schema["maxLength"]); err == nil && length > n {
+			report("maxLength", "value %q is longer than %d", x, n)
+		}
+	case json.Number:
+		val, _ := x.Float64()
+		if min, ok := schema["minimum"].(json.Number); ok {
+			if m, _ := min.Float64(); val < m {
+				report("minimum", "value %s is less than %s", x, min)
+			}
+		}
+		if max, ok := schema["maximum"].(json.Number); ok {
+			if m, _ := max.Float64(); val > m {
+				report("maximum", "value %s is greater than %s", x, max)
+			}
+		}
+	case []interface{}:
+		if items, ok := schema["items"].(map[string]interface{}); ok {
+			for i, item := range x {
+				errs = append(errs, validateValue(root, items, item, fmt.Sprintf("%s[%d]", path, i))...)
+			}
+		}
+		if unique, _ := schema["uniqueItems"].(bool); unique {
+			seen := make(map[string]bool)
+			for i, item := range x {
+				key := fmt.Sprintf("%#v", item)
+				if seen[key] {
+					report("uniqueItems", "item %d is a duplicate", i)
+				}
+				seen[key] = true
+			}
+		}
+	case map[string]interface{}:
+		errs = append(errs, validateObject(root, schema, x, path)...)
+	}
+	return errs
+}
+
+// validateObject validates the object-specific keywords of the schema.
+func validateObject(root, schema map[string]interface{}, obj map[string]interface{}, path string) []*ValidationError {
+	var errs []*ValidationError
+	if required, ok := schema["required"].([]interface{}); ok {
+		for _, x := range required {
+			name, _ := x.(string)
+			if _, ok := obj[name]; !ok {
+				errs = append(errs, &ValidationError{
+					Path:    path,
+					Message: fmt.Sprintf("missing required property %q", name),
+					keyword: "required",
+				})
+			}
+		}
+	}
+	properties, _ := schema["properties"].(map[string]interface{})
+	patternProperties, _ := schema["patternProperties"].(map[string]interface{})
+	var keys []string
+	for k := range obj {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		value := obj[k]
+		matched := false
+		if s, ok := properties[k].(map[string]interface{}); ok {
+			matched = true
+			errs = append(errs, validateValue(root, s, value, childPath(path, k))...)
+		}
+		for pattern, x := range patternProperties {
+			re, err := schemaRegexp(pattern)
+			if err != nil || !re.MatchString(k) {
+				continue
+			}
+			matched = true
+			if s, ok := x.(map[string]interface{}); ok {
+				errs = append(errs, validateValue(root, s, value, childPath(path, k))...)
+			}
+		}
+		if matched {
+			continue
+		}
+		switch additional := schema["additionalProperties"].(type) {
+		case bool:
+			if !additional {
+				errs = append(errs, &ValidationError{
+					Path:    path,
+					Message: fmt.Sprintf("unexpected property %q", k),
+					keyword: "additionalProperties",
+				})
+			}
+		case map[string]interface{}:
+			errs = append(errs, validateValue(root, additional, value, childPath(path, k))...)
+		}
+	}
+	return errs
+}
+
+// validateOneOf checks that the value matches exactly one of the schemas.
+// If it matches none, it reports the problems of the closest schema, i.e.
+// the one where the discriminator properties (such as cell_type or output_type)
+// match, so that the message refers to the actual problem.
+func validateOneOf(root map[string]interface{}, oneOf []interface{}, v interface{}, path string) []*ValidationError {
+	var best []*ValidationError
+	bestDiscriminated := false
+	matches := 0
+	// types lists the expected types while all schemas fail only on the value type.
+	var types []string
+	onlyTypes := true
+	for _, x := range oneOf {
+		s, ok := x.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		var err error
+		errs := validateValue(root, s, v, path)
+		if len(errs) == 0 {
+			matches++
+			continue
+		}
+		if len(errs) == 1 && errs[0].keyword == "type" && errs[0].Path == path {
+			for s["$ref"] != nil {
+				if s, err = resolveRef(root, s["$ref"].(string)); err != nil {
+					break
+				}
+			}
+			types = append(types, fmt.Sprint(s["type"]))
+		} else {
+			onlyTypes = false
+		}
+		// A schema is discriminated if none of the direct properties
+		// failed the enum check.
+		discriminated := true
+		for _, e := range errs {
+			if e.keyword == "enum" && strings.HasPrefix(e.Path, path+".") &&
+				!strings.ContainsAny(e.Path[len(path)+1:], ".[") {
+				discriminated = false
+				break
+			}
+		}
+		if best == nil || (discriminated && !bestDiscriminated) ||
+			(discriminated == bestDiscriminated && len(errs) < len(best)) {
+			best = errs
+			bestDiscriminated = discriminated
+		}
+	}
+	switch {
+	case matches == 1:
+		return nil
+	case matches == 0 && onlyTypes:
+		return []*ValidationError{{
+			Path:    path,
+			Message: fmt.Sprintf("expected %s, got %s", strings.Join(types, " or "), jsonType(v)),
+			keyword: "type",
+		}}
+	case matches > 1:
+		return []*ValidationError{{Path: path, Message: "value matches more than one allowed schema", keyword: "oneOf"}}
+	case !bestDiscriminated:
+		return []*ValidationError{{Path: path, Message: "value does not match any allowed schema", keyword: "oneOf"}}
+	}
+	return best
+}
@@ -0,0 +1,136 @@
+package notebook
+
+import (
+	"io/ioutil"
+	"reflect"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []string
+	}{
+		{
+			name:  "Empty",
+			input: `{"nbformat": 4, "nbformat_minor": 2, "metadata": {}, "cells": []}`,
+		},
+		{
+			name: "Cells",
+			input: `{"nbformat": 4, "nbformat_minor": 2, "metadata": {}, "cells": [
+				{"cell_type": "markdown", "metadata": {}, "source": ["# Title\n", "text"]},
+				{"cell_type": "code", "metadata": {}, "source": "x = 1", "execution_count": 1,
+				 "outputs": [{"output_type": "stream", "name": "stdout", "text": "1\n"}]}]}`,
+		},
+		{
+			name:  "CellID",
+			input: `{"nbformat": 4, "nbformat_minor": 5, "metadata": {}, "cells": [{"id": "a", "cell_type": "raw", "metadata": {}, "source": ""}]}`,
+		},
+		{
+			name:  "MissingCells",
+			input: `{"nbformat": 4, "nbformat_minor": 2, "metadata": {}}`,
+			want:  []string{`.: missing required property "cells"`},
+		},
+		{
+			name:  "BadSource",
+			input: `{"nbformat": 4, "nbformat_minor": 2, "metadata": {}, "cells": [{"cell_type": "markdown", "metadata": {}, "source": 5}]}`,
+			want:  []string{`.cells[0].source: expected string or array, got integer`},
+		},
+		{
+			name: "BadOutput",
+			input: `{"nbformat": 4, "nbformat_minor": 2, "metadata": {}, "cells": [
+				{"cell_type": "code", "metadata": {}, "source": "", "execution_count": null,
+				 "outputs": [{"output_type": "stream", "name": "stdout"}]}]}`,
+			want: []string{`.cells[0].outputs[0]: missing required property "text"`},
+		},
+		{
+			name:  "UnknownCellType",
+			input: `{"nbformat": 4, "nbformat_minor": 2, "metadata": {}, "cells": [{"cell_type": "foo", "metadata": {}, "source": ""}]}`,
+			want:  []string{`.cells[0]: value does not match any allowed schema`},
+		},
+		{
+			name:  "MissingCellID",
+			input: `{"nbformat": 4, "nbformat_minor": 5, "metadata": {}, "cells": [{"cell_type": "raw", "metadata": {}, "source": ""}]}`,
+			want:  []string{`.cells[0]: missing required property "id"`},
+		},
+		{
+			name:  "CellIDBeforeV45",
+			input: `{"nbformat": 4, "nbformat_minor": 4, "metadata": {}, "cells": [{"id": "a", "cell_type": "raw", "metadata": {}, "source": ""}]}`,
+			want:  []string{`.cells[0]: unexpected property "id"`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			errs, err := Validate([]byte(tt.input))
+			if err != nil {
+				t.Fatalf("Validate returned error %s, want success", err)
+			}
+			var got []string
+			for _, e := range errs {
+				got = append(got, e.Error())
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Validate returned %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateTestdata(t *testing.T) {
+	// The nbformat v3 notebook is upgraded before validation.
+	for _, filename := range []string{"testdata/outputs.ipynb", "testdata/v3.ipynb"} {
+		b, err := ioutil.ReadFile(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		errs, err := Validate(b)
+		if err != nil || len(errs) > 0 {
+			t.Errorf("Validate(%s) returned %v, %v, want no problems", filename, errs, err)
+		}
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	inputs := []string{
+		`{"nbformat": 4, "nbformat_minor": 2, "metadata": {}}`,
+		`{"nbformat": 4, "nbformat_minor": 2, "metadata": {}, "cells": [{"cell_type": "code", "metadata": {}, "source": 5}]}`,
+	}
+	for _, input := range inputs {
+		if _, err := ParseStrict([]byte(input)); err == nil {
+			t.Errorf("ParseStrict(%s) returned success, want error", input)
+		}
+	}
+	// Parse only reports the schema problems that do not prevent parsing.
+	n, err := Parse([]byte(inputs[0]))
+	if err != nil {
+		t.Fatalf("Parse(%s) returned error %s, want success", inputs[0], err)
+	}
+	if len(n.Warnings) != 1 || n.Warnings[0].Error() != `.: missing required property "cells"` {
+		t.Errorf("Parse(%s) returned warnings %q, want the missing cells", inputs[0], n.Warnings)
+	}
+	if _, err := Parse([]byte(inputs[1])); err == nil {
+		t.Errorf("Parse(%s) returned success, want error", inputs[1])
+	}
+}
+
+func TestParseColab(t *testing.T) {
+	// The notebook saved by Colab has extra metadata in the notebook,
+	// the cells and the outputs.
+	n, err := ParseStrict(mustReadFile(t, "testdata/colab.ipynb"))
+	if err != nil {
+		t.Fatalf("ParseStrict returned error %s, want success", err)
+	}
+	if len(n.Cells) != 3 || n.Cells[1].Metadata["exercise_id"] != "hello" {
+		t.Errorf("parsed cells %v, want 3 cells with the exercise hello", n.Cells)
+	}
+}
+
+func mustReadFile(t *testing.T, filename string) []byte {
+	t.Helper()
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
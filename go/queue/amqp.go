@@ -0,0 +1,490 @@
+package queue
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/streadway/amqp"
+)
+
+// Channel is the Queue backed by a connection to an AMQP broker.
+// The queues are durable and the messages are persistent, so that they
+// survive the restart of the broker. The messages that were delivered but
+// not acknowledged when the receiver disconnected are delivered again,
+// which counts as a retry.
+//
+// When the connection is lost, e.g. because the broker restarted, Channel
+// reconnects with exponential backoff. Post waits for the reconnection,
+// and the channels returned by Receive resume delivering the messages.
+// The messages delivered before the reconnection cannot be acknowledged
+// any more, the broker delivers them again.
+type Channel struct {
+	// MaxRetries is the number of times a message can be requeued by Nack.
+	MaxRetries int
+
+	spec string
+	opts Options
+
+	// mu protects the fields below.
+	mu sync.Mutex
+	// conn is the current connection, or nil while reconnecting.
+	conn *amqpConn
+	// connected is closed when the connection is established.
+	connected chan struct{}
+	closed    bool
+	// done is closed by Close.
+	done chan struct{}
+}
+
+// amqpConn is a connection to the broker. It is replaced on reconnect.
+type amqpConn struct {
+	conn *amqp.Connection
+	ch   *amqp.Channel
+	// mu protects queues.
+	mu     sync.Mutex
+	queues map[string]amqp.Queue
+	// lost is closed when the connection is lost.
+	lost chan struct{}
+}
+
+var (
+	// ReconnectDelay is the initial delay between the attempts to reconnect
+	// to the broker. It is doubled after each failed attempt.
+	ReconnectDelay = 500 * time.Millisecond
+	// MaxReconnectDelay limits the delay between the attempts to reconnect.
+	MaxReconnectDelay = 30 * time.Second
+)
+
+// retriesHeader is the message header with the number of times
+// the message has been requeued by Nack.
+const retriesHeader = "x-retries"
+
+// OpenAMQP connects to the AMQP broker.
+// Example of connection spec: "amqp://localhost:5672/".
+func OpenAMQP(spec string, opts Options) (*Channel, error) {
+	c, err := dialAMQP(spec, opts)
+	if err != nil {
+		return nil, err
+	}
+	connected := make(chan struct{})
+	close(connected)
+	ch := &Channel{
+		MaxRetries: opts.MaxRetries,
+		spec:       spec,
+		opts:       opts,
+		conn:       c,
+		connected:  connected,
+		done:       make(chan struct{}),
+	}
+	go ch.watch(c)
+	return ch, nil
+}
+
+// dialAMQP connects to the broker and opens the channel.
+func dialAMQP(spec string, opts Options) (*amqpConn, error) {
+	conn, err := amqp.Dial(spec)
+	if err != nil {
+		return nil, err
+	}
+	ch, err := conn.Channel()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	prefetch := opts.Prefetch
+	if prefetch < 1 {
+		prefetch = 1
+	}
+	err = ch.Qos(
+		prefetch, // prefetch count
+		0,        // prefetch size
+		false,    // global
+	)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &amqpConn{
+		conn:   conn,
+		ch:     ch,
+		queues: make(map[string]amqp.Queue),
+		lost:   make(chan struct{}),
+	}, nil
+}
+
+// watch waits until the connection is lost and reconnects.
+func (ch *Channel) watch(c *amqpConn) {
+	connClosed := c.conn.NotifyClose(make(chan *amqp.Error, 1))
+	chClosed := c.ch.NotifyClose(make(chan *amqp.Error, 1))
+	var reason *amqp.Error
+	select {
+	case reason = <-connClosed:
+	case reason = <-chClosed:
+		// The broker closes the channel on errors, start over
+		// with a new connection.
+		c.conn.Close()
+	case <-ch.done:
+	}
+	ch.mu.Lock()
+	ch.conn = nil
+	ch.connected = make(chan struct{})
+	closed := ch.closed
+	ch.mu.Unlock()
+	close(c.lost)
+	if closed {
+		return
+	}
+	glog.Errorf("lost connection to the queue: %v, reconnecting", reason)
+	delay := ReconnectDelay
+	for {
+		select {
+		case <-time.After(delay):
+		case <-ch.done:
+			return
+		}
+		c, err := dialAMQP(ch.spec, ch.opts)
+		if err != nil {
+			delay *= 2
+			if delay > MaxReconnectDelay {
+				delay = MaxReconnectDelay
+			}
+			glog.V(1).Infof("error reconnecting to the queue: %s, retrying in %s", err, delay)
+			continue
+		}
+		ch.mu.Lock()
+		if ch.closed {
+			ch.mu.Unlock()
+			c.conn.Close()
+			return
+		}
+		ch.conn = c
+		close(ch.connected)
+		ch.mu.Unlock()
+		glog.Infof("reconnected to the queue")
+		go ch.watch(c)
+		return
+	}
+}
+
+// current returns the current connection, waiting for the reconnection
+// if the connection has been lost.
+func (ch *Channel) current() (*amqpConn, error) {
+	for {
+		ch.mu.Lock()
+		c, connected, closed := ch.conn, ch.connected, ch.closed
+		ch.mu.Unlock()
+		if closed {
+			return nil, fmt.Errorf("queue is closed")
+		}
+		if c != nil {
+			return c, nil
+		}
+		select {
+		case <-connected:
+		case <-ch.done:
+		}
+	}
+}
+
+// wait waits before retrying an operation that failed on the connection:
+// until the connection is lost, the channel is closed or ReconnectDelay passes.
+func (ch *Channel) wait(c *amqpConn) {
+	select {
+	case <-c.lost:
+	case <-ch.done:
+	case <-time.After(ReconnectDelay):
+	}
+}
+
+func (ch *Channel) Close() error {
+	ch.mu.Lock()
+	if ch.closed {
+		ch.mu.Unlock()
+		return nil
+	}
+	ch.closed = true
+	close(ch.done)
+	c := ch.conn
+	ch.mu.Unlock()
+	if c == nil {
+		// The connection was lost and not reestablished.
+		return nil
+	}
+	err1 := c.ch.Close()
+	err2 := c.conn.Close()
+	if err1 != nil {
+		return err1
+	}
+	return err2
+}
+
+// getQueue declares the named queue on the connection.
+func (c *amqpConn) getQueue(queueName string) (amqp.Queue, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if q, ok := c.queues[queueName]; ok {
+		return q, nil
+	}
+	var err error
+	q, err := c.ch.QueueDeclare(
+		queueName,
+		true,  // durable
+		false, // delete when unused
+		false, // exclusive
+		false, // no-wait
+		nil,   // extra arguments
+	)
+	if err != nil {
+		return amqp.Queue{}, declareError(queueName, err)
+	}
+	c.queues[queueName] = q
+	return q, nil
+}
+
+// declareError explains the error of declaring the named queue.
+func declareError(queueName string, err error) error {
+	if e, ok := err.(*amqp.Error); ok && e.Code == amqp.PreconditionFailed {
+		// The queues declared as non-durable by the older versions cannot
+		// be redeclared as durable.
+		return fmt.Errorf("queue %q exists with different settings, e.g. it is not durable; "+
+			"delete it with \"rabbitmqctl delete_queue %s\" to redeclare it: %s", queueName, queueName, err)
+	}
+	return err
+}
+
+// Post sends the specified byte slice content to the named queue.
+// If the connection is lost, it waits for the reconnection and tries again.
+func (ch *Channel) Post(queueName string, content []byte) error {
+	return ch.publish(queueName, content, 0)
+}
+
+// publish sends the message with the given number of retries to the named queue.
+func (ch *Channel) publish(queueName string, content []byte, retries int) error {
+	for {
+		c, err := ch.current()
+		if err != nil {
+			return err
+		}
+		q, err := c.getQueue(queueName)
+		if err == nil {
+			err = c.ch.Publish(
+				"",     // exchange
+				q.Name, // routing key
+				false,  // mandatory
+				false,  // immediate
+				amqp.Publishing{
+					ContentType:  "application/octet-stream",
+					DeliveryMode: amqp.Persistent,
+					Headers:      amqp.Table{retriesHeader: int32(retries)},
+					Body:         content,
+				})
+		}
+		if err != amqp.ErrClosed {
+			return err
+		}
+		glog.V(1).Infof("connection closed while posting to queue %q, retrying", queueName)
+		ch.wait(c)
+	}
+}
+
+// Receive returns a (Go) channel that will deliver messages received on the
+// queue specified by a name. When the connection is lost, the delivery
+// resumes after the reconnection.
+func (ch *Channel) Receive(queueName string) (<-chan *Delivery, error) {
+	c, err := ch.current()
+	if err != nil {
+		return nil, err
+	}
+	deliveries, err := c.consume(queueName)
+	if err != nil {
+		return nil, err
+	}
+	outputCh := make(chan *Delivery)
+	go func() {
+		defer close(outputCh)
+		for {
+			// The deliveries are closed when the connection is lost.
+			ch.forward(queueName, deliveries, outputCh)
+			for {
+				c, err := ch.current()
+				if err != nil {
+					// The channel is closed.
+					return
+				}
+				deliveries, err = c.consume(queueName)
+				if err == nil {
+					glog.Infof("resumed receiving from queue %q", queueName)
+					break
+				}
+				glog.Errorf("error receiving from queue %q: %s", queueName, err)
+				ch.wait(c)
+			}
+		}
+	}()
+	return outputCh, nil
+}
+
+// consume starts the delivery of the messages from the named queue.
+func (c *amqpConn) consume(queueName string) (<-chan amqp.Delivery, error) {
+	q, err := c.getQueue(queueName)
+	if err != nil {
+		return nil, err
+	}
+	return c.ch.Consume(
+		q.Name,
+		"",    // consumer
+		false, // auto-ack
+		false, // exclusive
+		false, // no-local
+		false, // no-wait
+		nil,   // extra args
+	)
+}
+
+// forward sends the deliveries to outputCh until the deliveries are closed
+// or the channel is closed.
+func (ch *Channel) forward(queueName string, deliveries <-chan amqp.Delivery, outputCh chan<- *Delivery) {
+	for d := range deliveries {
+		glog.V(5).Infof("received %d bytes from queue %q", len(d.Body), queueName)
+		delivery := &Delivery{Body: d.Body, Retries: headerRetries(d.Headers), tag: d}
+		if d.Redelivered {
+			// The previous receiver did not acknowledge the message, e.g. because
+			// the message crashed it. The broker does not count the redeliveries,
+			// so requeue it as a retry.
+			err := ch.Nack(delivery, true, "the receiver stopped without acknowledging the message")
+			if err != nil {
+				glog.Errorf("error requeueing redelivered message: %s", err)
+			}
+			continue
+		}
+		select {
+		case outputCh <- delivery:
+		case <-ch.done:
+			return
+		}
+	}
+}
+
+// headerRetries returns the number of retries from the message headers.
+func headerRetries(headers amqp.Table) int {
+	switch v := headers[retriesHeader].(type) {
+	case int32:
+		return int(v)
+	case int64:
+		return int(v)
+	case int16:
+		return int(v)
+	case int8:
+		return int(v)
+	}
+	return 0
+}
+
+// delivery returns the AMQP delivery, checking that it was received
+// on the current connection.
+func (ch *Channel) delivery(d *Delivery) (amqp.Delivery, error) {
+	ad, ok := d.tag.(amqp.Delivery)
+	if !ok {
+		return amqp.Delivery{}, fmt.Errorf("delivery was not received from this channel")
+	}
+	ch.mu.Lock()
+	c := ch.conn
+	ch.mu.Unlock()
+	if c == nil || ad.Acknowledger != c.ch {
+		return amqp.Delivery{}, fmt.Errorf("delivery was received before the connection was lost, it will be delivered again")
+	}
+	return ad, nil
+}
+
+// Ack acknowledges the delivered message, so that the broker removes it.
+func (ch *Channel) Ack(d *Delivery) error {
+	ad, err := ch.delivery(d)
+	if err != nil {
+		return err
+	}
+	return ad.Ack(false)
+}
+
+// Get returns the next message from the named queue, or nil if it is empty.
+func (ch *Channel) Get(queueName string) (*Delivery, error) {
+	c, err := ch.current()
+	if err != nil {
+		return nil, err
+	}
+	q, err := c.getQueue(queueName)
+	if err != nil {
+		return nil, err
+	}
+	d, ok, err := c.ch.Get(q.Name, false /* auto-ack */)
+	if err != nil || !ok {
+		return nil, err
+	}
+	return &Delivery{Body: d.Body, Retries: headerRetries(d.Headers), tag: d}, nil
+}
+
+// Browse gets all messages from the named queue and then rejects them back
+// to the broker, which returns them to their places in the queue without
+// counting a retry. The messages are not delivered to the other consumers
+// while they are being browsed.
+func (ch *Channel) Browse(queueName string) ([][]byte, error) {
+	c, err := ch.current()
+	if err != nil {
+		return nil, err
+	}
+	q, err := c.getQueue(queueName)
+	if err != nil {
+		return nil, err
+	}
+	var deliveries []amqp.Delivery
+	for {
+		d, ok, getErr := c.ch.Get(q.Name, false /* auto-ack */)
+		if getErr != nil || !ok {
+			err = getErr
+			break
+		}
+		deliveries = append(deliveries, d)
+	}
+	// Each delivery is rejected by itself rather than with multiple, which
+	// would also reject the messages delivered to the consumers of the channel.
+	var ret [][]byte
+	for _, d := range deliveries {
+		ret = append(ret, d.Body)
+		if nackErr := d.Nack(false, true /* requeue */); err == nil {
+			err = nackErr
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// Nack rejects the delivered message. If requeue is true and the message has
+// been retried less than MaxRetries times, the message is posted again
+// to the end of the queue with the incremented number of retries, as
+// the broker does not count the retries of the requeued messages.
+// Otherwise it is posted to the dead-letter queue.
+func (ch *Channel) Nack(d *Delivery, requeue bool, reason string) error {
+	ad, err := ch.delivery(d)
+	if err != nil {
+		return err
+	}
+	if !requeue || d.Retries >= ch.MaxRetries {
+		err = postDeadLetter(ch, ad.RoutingKey, d, reason)
+		if err != nil {
+			// Keep the message in the queue rather than lose it.
+			glog.Errorf("error posting dead letter: %s", err)
+			return ad.Nack(false, true)
+		}
+		return ad.Ack(false)
+	}
+	err = ch.publish(ad.RoutingKey, d.Body, d.Retries+1)
+	if err != nil {
+		// Let the broker requeue it without counting.
+		glog.Errorf("error reposting message: %s", err)
+		return ad.Nack(false, true)
+	}
+	return ad.Ack(false)
+}
@@ -0,0 +1,23 @@
+package queue
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/streadway/amqp"
+)
+
+func TestDeclareError(t *testing.T) {
+	err := declareError("autograde", &amqp.Error{
+		Code:   amqp.PreconditionFailed,
+		Reason: "PRECONDITION_FAILED - inequivalent arg 'durable' for queue 'autograde'",
+	})
+	if !strings.Contains(err.Error(), "rabbitmqctl delete_queue autograde") {
+		t.Errorf("declareError returned %q, want the migration hint", err)
+	}
+	other := errors.New("connection refused")
+	if err := declareError("autograde", other); err != other {
+		t.Errorf("declareError returned %q, want %q", err, other)
+	}
+}
@@ -0,0 +1,226 @@
+package queue
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Memory is the Queue that keeps the messages in the memory of the process.
+// It is intended for the tests and for running the upload server and
+// the worker in a single binary. The messages are lost when the process exits.
+type Memory struct {
+	// MaxRetries is the number of times a message can be requeued by Nack.
+	MaxRetries int
+
+	mu     sync.Mutex
+	queues map[string]*memoryQueue
+	// done is closed by Close.
+	done   chan struct{}
+	closed bool
+}
+
+// memoryQueue is a named queue of the Memory.
+type memoryQueue struct {
+	messages []*Delivery
+	// ready has a value when messages may have been added.
+	ready chan struct{}
+}
+
+// memoryTag identifies the delivery of the Memory.
+type memoryTag struct {
+	m         *Memory
+	queueName string
+}
+
+var (
+	memoryMu sync.Mutex
+	memories = make(map[string]*Memory)
+)
+
+// OpenMemory returns the in-process queue with the given name, creating it
+// if it does not exist or has been closed. All calls with the same name
+// share the queues.
+func OpenMemory(name string) *Memory {
+	memoryMu.Lock()
+	defer memoryMu.Unlock()
+	m, ok := memories[name]
+	if ok && !m.isClosed() {
+		return m
+	}
+	m = NewMemory()
+	memories[name] = m
+	return m
+}
+
+// NewMemory creates a new in-process queue, not shared with OpenMemory.
+func NewMemory() *Memory {
+	return &Memory{
+		MaxRetries: DefaultOptions.MaxRetries,
+		queues:     make(map[string]*memoryQueue),
+		done:       make(chan struct{}),
+	}
+}
+
+func (m *Memory) isClosed() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.closed
+}
+
+// getQueue returns the named queue, creating it on first use.
+// It must be called with mu held.
+func (m *Memory) getQueue(queueName string) *memoryQueue {
+	q, ok := m.queues[queueName]
+	if !ok {
+		q = &memoryQueue{ready: make(chan struct{}, 1)}
+		m.queues[queueName] = q
+	}
+	return q
+}
+
+// push adds the message to the queue, to the front if front is true.
+func (m *Memory) push(queueName string, d *Delivery, front bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return fmt.Errorf("queue is closed")
+	}
+	q := m.getQueue(queueName)
+	if front {
+		q.messages = append([]*Delivery{d}, q.messages...)
+	} else {
+		q.messages = append(q.messages, d)
+	}
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Post sends the specified byte slice content to the named queue.
+func (m *Memory) Post(queueName string, content []byte) error {
+	return m.push(queueName, &Delivery{
+		Body: content,
+		tag:  memoryTag{m, queueName},
+	}, false)
+}
+
+// pop removes the first message from the queue. If the queue is empty,
+// it returns false and the channel to wait on for the new messages.
+func (m *Memory) pop(queueName string) (*Delivery, bool, <-chan struct{}) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	q := m.getQueue(queueName)
+	if len(q.messages) == 0 {
+		return nil, false, q.ready
+	}
+	d := q.messages[0]
+	q.messages = q.messages[1:]
+	if len(q.messages) > 0 {
+		// Let the other receivers of the queue know.
+		select {
+		case q.ready <- struct{}{}:
+		default:
+		}
+	}
+	return d, true, nil
+}
+
+// Receive returns a (Go) channel that will deliver messages received on the
+// queue specified by a name. Several receivers of the same queue get
+// different messages.
+func (m *Memory) Receive(queueName string) (<-chan *Delivery, error) {
+	if m.isClosed() {
+		return nil, fmt.Errorf("queue is closed")
+	}
+	outputCh := make(chan *Delivery)
+	go func() {
+		defer close(outputCh)
+		for {
+			d, ok, ready := m.pop(queueName)
+			if !ok {
+				select {
+				case <-ready:
+					continue
+				case <-m.done:
+					return
+				}
+			}
+			select {
+			case outputCh <- d:
+			case <-m.done:
+				return
+			}
+		}
+	}()
+	return outputCh, nil
+}
+
+// Get returns the next message from the named queue, or nil if it is empty.
+func (m *Memory) Get(queueName string) (*Delivery, error) {
+	if m.isClosed() {
+		return nil, fmt.Errorf("queue is closed")
+	}
+	d, _, _ := m.pop(queueName)
+	return d, nil
+}
+
+// Browse returns the contents of the messages in the named queue.
+func (m *Memory) Browse(queueName string) ([][]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return nil, fmt.Errorf("queue is closed")
+	}
+	var ret [][]byte
+	for _, d := range m.getQueue(queueName).messages {
+		ret = append(ret, d.Body)
+	}
+	return ret, nil
+}
+
+func (m *Memory) tag(d *Delivery) (memoryTag, error) {
+	tag, ok := d.tag.(memoryTag)
+	if !ok || tag.m != m {
+		return memoryTag{}, fmt.Errorf("delivery was not received from this queue")
+	}
+	return tag, nil
+}
+
+// Ack acknowledges the delivered message, which has already been removed
+// from the queue.
+func (m *Memory) Ack(d *Delivery) error {
+	_, err := m.tag(d)
+	return err
+}
+
+// Nack returns the delivered message to the front of the queue if requeue
+// is true and it has been retried less than MaxRetries times, and moves it
+// to the dead-letter queue otherwise.
+func (m *Memory) Nack(d *Delivery, requeue bool, reason string) error {
+	tag, err := m.tag(d)
+	if err != nil {
+		return err
+	}
+	if !requeue || d.Retries >= m.MaxRetries {
+		return postDeadLetter(m, tag.queueName, d, reason)
+	}
+	return m.push(tag.queueName, &Delivery{
+		Body:    d.Body,
+		Retries: d.Retries + 1,
+		tag:     tag,
+	}, true)
+}
+
+// Close closes the queue, discarding the messages, and closes the channels
+// returned by Receive.
+func (m *Memory) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.closed {
+		m.closed = true
+		close(m.done)
+	}
+	return nil
+}
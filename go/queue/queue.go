@@ -2,111 +2,158 @@
 package queue
 
 import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"time"
+
 	"github.com/golang/glog"
-	"github.com/streadway/amqp"
 )
 
-// Channel represents a connection to the queue service.
-// It is not thread-safe.
-type Channel struct {
-	*amqp.Connection
-	*amqp.Channel
-	queues          map[string]amqp.Queue
-	receiveChannels map[string]<-chan amqp.Delivery
+// Queue is the interface to the message queue service. The messages are posted
+// to and received from the queues identified by name, which are created
+// on first use. The implementations are safe for concurrent use.
+type Queue interface {
+	// Post sends the specified byte slice content to the named queue.
+	Post(queueName string, content []byte) error
+	// Receive returns a (Go) channel that will deliver messages received on the
+	// queue specified by a name. The channel is closed when the queue is closed.
+	// The caller should read all entries from the returned channel. Otherwise,
+	// the channel and an internal goroutine leak.
+	Receive(queueName string) (<-chan *Delivery, error)
+	// Get returns the next message from the named queue without waiting,
+	// or nil if the queue is empty. The message should be acknowledged
+	// or rejected like the ones delivered by Receive.
+	Get(queueName string) (*Delivery, error)
+	// Ack acknowledges that the delivered message has been processed,
+	// so that it is removed from the queue.
+	Ack(d *Delivery) error
+	// Nack reports that the delivered message could not be processed
+	// for the given reason. If requeue is true, the message is returned to
+	// the queue to be delivered again, unless it has already been retried
+	// MaxRetries times. Otherwise it is moved to the dead-letter queue,
+	// see DeadLetter.
+	Nack(d *Delivery, requeue bool, reason string) error
+	// Close closes the connection to the queue service.
+	Close() error
 }
 
-// Open takes a string spec and opens a connection to the queue.
-// Example of connection spec: "amqp://localhost:5672/".
-func Open(spec string) (*Channel, error) {
-	conn, err := amqp.Dial(spec)
-	if err != nil {
-		return nil, err
-	}
-	ch, err := conn.Channel()
-	if err != nil {
-		conn.Close()
-		return nil, err
-	}
-	return &Channel{
-		Connection: conn,
-		Channel:    ch,
-		queues:     make(map[string]amqp.Queue),
-	}, nil
+// Browser is implemented by the queues that can list the messages without
+// consuming them, e.g. to inspect the dead letters.
+type Browser interface {
+	// Browse returns the contents of the messages in the named queue,
+	// in the order of delivery, leaving the messages in the queue.
+	Browse(queueName string) ([][]byte, error)
 }
 
-func (ch *Channel) Close() error {
-	err1 := ch.Channel.Close()
-	err2 := ch.Connection.Close()
-	if err1 != nil {
-		return err1
-	}
-	return err2
+// Delivery is a message received from a queue. It should be passed to Ack
+// or Nack of the queue that delivered it once processed.
+type Delivery struct {
+	// Body is the content of the message.
+	Body []byte
+	// Retries is the number of times the message has been requeued by Nack.
+	Retries int
+	// tag identifies the delivery in the implementation of the queue.
+	tag interface{}
 }
 
-func (ch *Channel) getQueue(queueName string) (amqp.Queue, error) {
-	if q, ok := ch.queues[queueName]; ok {
-		return q, nil
-	}
-	var err error
-	q, err := ch.Channel.QueueDeclare(
-		queueName,
-		false, // durable
-		false, // delete when unused
-		false, // exclusive
-		false, // no-wait
-		nil,   // extra arguments
-	)
+// DeadLetterSuffix is appended to the name of the queue to get the name
+// of its dead-letter queue.
+const DeadLetterSuffix = ".dead"
+
+// DeadLetter is the message in the dead-letter queue, serialized as JSON.
+// It holds the message that could not be processed and the reason.
+type DeadLetter struct {
+	// Queue is the name of the queue the message was received from.
+	Queue string `json:"queue"`
+	// Reason describes the last failure of processing the message.
+	Reason string `json:"reason"`
+	// Retries is the number of times the message had been retried.
+	Retries int `json:"retries"`
+	// Time is when the message was moved to the dead-letter queue.
+	Time time.Time `json:"time"`
+	// Body is the content of the message.
+	Body []byte `json:"body"`
+}
+
+// ParseDeadLetter parses the message from the dead-letter queue.
+func ParseDeadLetter(b []byte) (*DeadLetter, error) {
+	dl := new(DeadLetter)
+	err := json.Unmarshal(b, dl)
 	if err != nil {
-		return amqp.Queue{}, err
+		return nil, fmt.Errorf("error parsing dead letter: %s", err)
 	}
-	ch.queues[queueName] = q
-	return q, nil
+	return dl, nil
 }
 
-// Post sends the specified byte slice content to the named queue.
-func (ch *Channel) Post(queueName string, content []byte) error {
-	q, err := ch.getQueue(queueName)
+// postDeadLetter posts the message received from the named queue
+// to its dead-letter queue.
+func postDeadLetter(q Queue, queueName string, d *Delivery, reason string) error {
+	b, err := json.Marshal(&DeadLetter{
+		Queue:   queueName,
+		Reason:  reason,
+		Retries: d.Retries,
+		Time:    time.Now(),
+		Body:    d.Body,
+	})
 	if err != nil {
 		return err
 	}
-	return ch.Channel.Publish(
-		"",     // exchange
-		q.Name, // routing key
-		false,  // mandatory
-		false,  // immediate
-		amqp.Publishing{
-			ContentType: "application/octet-stream",
-			Body:        content,
-		})
+	glog.Warningf("moving message to %s: %s", queueName+DeadLetterSuffix, reason)
+	return q.Post(queueName+DeadLetterSuffix, b)
 }
 
-// Receive returns a (Go) channel that will deliver messages received on the
-// queue specified by a name. The caller should read all entries from the returned
-// channel. Otherwise, the channel and an internal goroutine leak.
-func (ch *Channel) Receive(queueName string) (<-chan []byte, error) {
-	q, err := ch.getQueue(queueName)
-	if err != nil {
-		return nil, err
-	}
-	deliveries, err := ch.Channel.Consume(
-		q.Name,
-		"",    // consumer
-		true,  // auto-ack
-		false, // exclusive
-		false, // no-local
-		false, // no-wait
-		nil,   // extra args
-	)
+// Options configures the queue opened by OpenWithOptions.
+type Options struct {
+	// Prefetch is the maximum number of the unacknowledged messages delivered
+	// to the receiver of a queue. It is used by AMQP only, the other
+	// implementations deliver one message at a time. Values less than 1 mean 1.
+	Prefetch int
+	// MaxRetries is the number of times a message can be requeued by Nack.
+	// After that, Nack moves it to the dead-letter queue.
+	MaxRetries int
+}
+
+// DefaultOptions are the options used by Open.
+var DefaultOptions = Options{Prefetch: 1, MaxRetries: 3}
+
+// Open takes a string spec and opens a connection to the queue
+// with DefaultOptions, see OpenWithOptions.
+func Open(spec string) (Queue, error) {
+	return OpenWithOptions(spec, DefaultOptions)
+}
+
+// OpenWithOptions takes a string spec and opens a connection to the queue.
+// The scheme of the spec selects the implementation:
+// * amqp://localhost:5672/ connects to the AMQP broker, e.g. RabbitMQ.
+// * mem://name opens the in-process queue, shared by all Open calls
+//   with the same name in the process, see Memory.
+// * dir:///var/spool/autograder opens the spool directory, see Spool.
+func OpenWithOptions(spec string, opts Options) (Queue, error) {
+	u, err := url.Parse(spec)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("bad queue spec %q: %s", spec, err)
 	}
-	outputCh := make(chan []byte)
-	go func() {
-		for d := range deliveries {
-			glog.V(5).Infof("received %d bytes from queue %q", len(d.Body), q.Name)
-			outputCh <- d.Body
+	switch u.Scheme {
+	case "amqp", "amqps":
+		return OpenAMQP(spec, opts)
+	case "mem":
+		m := OpenMemory(u.Host + u.Path)
+		m.MaxRetries = opts.MaxRetries
+		return m, nil
+	case "dir":
+		dir := u.Path
+		if u.Host != "" {
+			// dir://relative/path
+			dir = filepath.Join(u.Host, u.Path)
 		}
-		close(outputCh)
-	}()
-	return outputCh, nil
+		s, err := OpenSpool(dir)
+		if err != nil {
+			return nil, err
+		}
+		s.MaxRetries = opts.MaxRetries
+		return s, nil
+	}
+	return nil, fmt.Errorf("unsupported queue spec %q, want amqp://, mem:// or dir://", spec)
 }
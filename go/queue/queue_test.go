@@ -0,0 +1,239 @@
+package queue
+
+import (
+	"testing"
+	"time"
+)
+
+// receive reads one delivery from the channel with a timeout.
+func receive(t *testing.T, ch <-chan *Delivery) *Delivery {
+	t.Helper()
+	select {
+	case d, ok := <-ch:
+		if !ok {
+			t.Fatalf("receive channel closed, want a message")
+		}
+		return d
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for a message")
+	}
+	return nil
+}
+
+// testQueue checks the behavior common to all implementations of Queue.
+func testQueue(t *testing.T, q Queue) {
+	for _, msg := range []string{"first", "second", ""} {
+		err := q.Post("work", []byte(msg))
+		if err != nil {
+			t.Fatalf("Post(%q) returned error %s, want success", msg, err)
+		}
+	}
+	ch, err := q.Receive("work")
+	if err != nil {
+		t.Fatalf("Receive returned error %s, want success", err)
+	}
+	d := receive(t, ch)
+	if string(d.Body) != "first" {
+		t.Errorf("received %q, want %q", string(d.Body), "first")
+	}
+	// The requeued message is delivered again. The next message may have
+	// already been taken by the receiver, so the order is not checked.
+	err = q.Nack(d, true, "retry")
+	if err != nil {
+		t.Fatalf("Nack returned error %s, want success", err)
+	}
+	got := make(map[string]bool)
+	for i := 0; i < 3; i++ {
+		d := receive(t, ch)
+		got[string(d.Body)] = true
+		err := q.Ack(d)
+		if err != nil {
+			t.Errorf("Ack returned error %s, want success", err)
+		}
+	}
+	for _, want := range []string{"first", "second", ""} {
+		if !got[want] {
+			t.Errorf("did not receive %q, got %v", want, got)
+		}
+	}
+	if err := q.Ack(&Delivery{Body: []byte("forged")}); err == nil {
+		t.Errorf("Ack of a foreign delivery returned success, want error")
+	}
+	// The queues are independent.
+	err = q.Post("report", []byte("report"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case d := <-ch:
+		t.Errorf("received %q from the work queue, want nothing", string(d.Body))
+	case <-time.After(50 * time.Millisecond):
+	}
+	err = q.Close()
+	if err != nil {
+		t.Fatalf("Close returned error %s, want success", err)
+	}
+	select {
+	case _, ok := <-ch:
+		if ok {
+			t.Errorf("received a message after Close, want the channel closed")
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("the receive channel was not closed by Close")
+	}
+}
+
+// testRetries checks that Nack requeues a message at most maxRetries times,
+// and then moves it to the dead-letter queue.
+func testRetries(t *testing.T, q Queue, maxRetries int) {
+	defer q.Close()
+	err := q.Post("work", []byte("poison"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ch, err := q.Receive("work")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i <= maxRetries; i++ {
+		d := receive(t, ch)
+		if d.Retries != i {
+			t.Errorf("delivery %d: Retries = %d, want %d", i, d.Retries, i)
+		}
+		err := q.Nack(d, true, "crashed")
+		if err != nil {
+			t.Fatalf("Nack returned error %s, want success", err)
+		}
+	}
+	select {
+	case d := <-ch:
+		t.Errorf("received %q after %d retries, want it dead-lettered", string(d.Body), maxRetries)
+	case <-time.After(100 * time.Millisecond):
+	}
+	d, err := q.Get("work" + DeadLetterSuffix)
+	if err != nil || d == nil {
+		t.Fatalf("Get of the dead letter returned %v, %v, want the dead letter", d, err)
+	}
+	dl, err := ParseDeadLetter(d.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := DeadLetter{Queue: "work", Reason: "crashed", Retries: maxRetries, Body: []byte("poison")}
+	if dl.Queue != want.Queue || dl.Reason != want.Reason || dl.Retries != want.Retries ||
+		string(dl.Body) != string(want.Body) || dl.Time.IsZero() {
+		t.Errorf("dead letter = %+v, want %+v", dl, want)
+	}
+	err = q.Ack(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d, err := q.Get("work" + DeadLetterSuffix); d != nil || err != nil {
+		t.Errorf("Get of the empty dead-letter queue = %v, %v, want nil", d, err)
+	}
+}
+
+// testReject checks that Nack without requeue moves the message
+// to the dead-letter queue right away.
+func testReject(t *testing.T, q Queue) {
+	defer q.Close()
+	err := q.Post("work", []byte("unparseable"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := q.Get("work")
+	if err != nil || d == nil {
+		t.Fatalf("Get returned %v, %v, want the message", d, err)
+	}
+	err = q.Nack(d, false, "bad format")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err = q.Get("work" + DeadLetterSuffix)
+	if err != nil || d == nil {
+		t.Fatalf("Get of the dead letter returned %v, %v, want the dead letter", d, err)
+	}
+	if dl, err := ParseDeadLetter(d.Body); err != nil || dl.Reason != "bad format" || dl.Retries != 0 {
+		t.Errorf("dead letter = %+v (err %v), want reason %q", dl, err, "bad format")
+	}
+}
+
+// testBrowse checks that browsing the queue lists the messages in order
+// and leaves them in the queue.
+func testBrowse(t *testing.T, q Queue) {
+	b, ok := q.(Browser)
+	if !ok {
+		t.Fatalf("%T does not implement Browser", q)
+	}
+	for _, msg := range []string{"first", "second"} {
+		err := q.Post("work", []byte(msg))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		msgs, err := b.Browse("work")
+		if err != nil {
+			t.Fatalf("Browse returned error %s, want success", err)
+		}
+		if len(msgs) != 2 || string(msgs[0]) != "first" || string(msgs[1]) != "second" {
+			t.Errorf("Browse returned %q, want [first second]", msgs)
+		}
+	}
+	d, err := q.Get("work")
+	if err != nil || d == nil || string(d.Body) != "first" {
+		t.Fatalf("Get after Browse returned %v, %v, want the first message", d, err)
+	}
+	if d.Retries != 0 {
+		t.Errorf("Retries = %d after Browse, want 0", d.Retries)
+	}
+}
+
+func TestMemory(t *testing.T) {
+	testQueue(t, NewMemory())
+}
+
+func TestMemoryRetries(t *testing.T) {
+	m := NewMemory()
+	m.MaxRetries = 2
+	testRetries(t, m, 2)
+}
+
+func TestMemoryReject(t *testing.T) {
+	testReject(t, NewMemory())
+}
+
+func TestMemoryBrowse(t *testing.T) {
+	testBrowse(t, NewMemory())
+}
+
+func TestOpenMemoryShared(t *testing.T) {
+	q1, err := Open("mem://shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q1.Close()
+	q2, err := Open("mem://shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = q1.Post("work", []byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ch, err := q2.Receive("work")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := receive(t, ch); string(d.Body) != "hello" {
+		t.Errorf("received %q, want %q", string(d.Body), "hello")
+	}
+}
+
+func TestOpenBadSpec(t *testing.T) {
+	for _, spec := range []string{"", "http://localhost/", "redis://localhost:6379"} {
+		if q, err := Open(spec); err == nil {
+			q.Close()
+			t.Errorf("Open(%q) returned success, want error", spec)
+		}
+	}
+}
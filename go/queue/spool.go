@@ -0,0 +1,361 @@
+package queue
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// Spool is the durable Queue that keeps the messages as files in a directory,
+// for small deployments that do not run a message broker. Each named queue is
+// a subdirectory with the following subdirectories:
+// * new: the messages waiting for delivery, in the order of the file names.
+// * cur: the delivered messages that have not been acknowledged yet.
+// * tmp: the messages being written.
+// The number of retries is kept in the file name of the requeued message.
+// The messages are moved between the subdirectories by renaming, so several
+// processes can post to the same spool, but each queue should be received
+// by one process only: the unacknowledged messages of a queue are requeued
+// when the queue is received, to recover from a crash of the receiver.
+type Spool struct {
+	// Dir is the spool directory.
+	Dir string
+	// PollInterval is how often Receive checks for the messages posted
+	// by other processes.
+	PollInterval time.Duration
+	// MaxRetries is the number of times a message can be requeued by Nack.
+	MaxRetries int
+
+	mu sync.Mutex
+	// ready has a channel per queue with a value when a message has been
+	// posted or requeued by this process.
+	ready  map[string]chan struct{}
+	done   chan struct{}
+	closed bool
+}
+
+// retriesRegex matches the number of retries in the message file name.
+var retriesRegex = regexp.MustCompile(`\.r([0-9]+)\.msg$`)
+
+// messageRetries returns the number of retries of the message and
+// the name of the message file without it.
+func messageRetries(name string) (int, string) {
+	m := retriesRegex.FindStringSubmatch(name)
+	if m == nil {
+		return 0, strings.TrimSuffix(name, ".msg")
+	}
+	n, _ := strconv.Atoi(m[1])
+	return n, strings.TrimSuffix(name, m[0])
+}
+
+// spoolSeq makes the names of the messages posted in the same nanosecond unique.
+var spoolSeq uint64
+
+// OpenSpool opens the spool directory, creating it if it does not exist.
+func OpenSpool(dir string) (*Spool, error) {
+	dir = filepath.Clean(dir)
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("error creating spool dir %q: %s", dir, err)
+	}
+	return &Spool{
+		Dir:          dir,
+		PollInterval: time.Second,
+		MaxRetries:   DefaultOptions.MaxRetries,
+		ready:        make(map[string]chan struct{}),
+		done:         make(chan struct{}),
+	}, nil
+}
+
+// queueDir creates the subdirectories of the named queue and returns its directory.
+func (s *Spool) queueDir(queueName string) (string, error) {
+	if queueName == "" || strings.ContainsAny(queueName, `/\`) || queueName[0] == '.' {
+		return "", fmt.Errorf("bad queue name %q", queueName)
+	}
+	dir := filepath.Join(s.Dir, queueName)
+	for _, sub := range []string{"new", "cur", "tmp"} {
+		err := os.MkdirAll(filepath.Join(dir, sub), 0755)
+		if err != nil {
+			return "", fmt.Errorf("error creating queue dir: %s", err)
+		}
+	}
+	return dir, nil
+}
+
+// readyChan returns the channel signalling the messages in the named queue.
+func (s *Spool) readyChan(queueName string) chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ch, ok := s.ready[queueName]
+	if !ok {
+		ch = make(chan struct{}, 1)
+		s.ready[queueName] = ch
+	}
+	return ch
+}
+
+// notify wakes up the receivers of the named queue in this process.
+func (s *Spool) notify(queueName string) {
+	select {
+	case s.readyChan(queueName) <- struct{}{}:
+	default:
+	}
+}
+
+// Post writes the content into a new message file of the named queue.
+func (s *Spool) Post(queueName string, content []byte) error {
+	dir, err := s.queueDir(queueName)
+	if err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%020d-%06d-%d.msg", time.Now().UnixNano(),
+		atomic.AddUint64(&spoolSeq, 1)%1000000, os.Getpid())
+	tmpFilename := filepath.Join(dir, "tmp", name)
+	f, err := os.Create(tmpFilename)
+	if err != nil {
+		return fmt.Errorf("error creating message: %s", err)
+	}
+	_, err = f.Write(content)
+	if err == nil {
+		// Make the message durable before it becomes visible.
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpFilename, filepath.Join(dir, "new", name))
+	}
+	if err != nil {
+		os.Remove(tmpFilename)
+		return fmt.Errorf("error writing message to %q: %s", dir, err)
+	}
+	s.notify(queueName)
+	return nil
+}
+
+// requeue requeues the unacknowledged messages of the queue, counting
+// it as a retry, so that the messages that crash the receiver end up
+// in the dead-letter queue.
+func (s *Spool) requeue(dir string) error {
+	names, err := listMessages(filepath.Join(dir, "cur"))
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		filename := filepath.Join(dir, "cur", name)
+		b, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return fmt.Errorf("error reading %q: %s", filename, err)
+		}
+		retries, _ := messageRetries(name)
+		err = s.Nack(&Delivery{Body: b, Retries: retries, tag: filename}, true,
+			"the receiver stopped without acknowledging the message")
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// listMessages returns the sorted names of the message files in the directory.
+func listMessages(dir string) ([]string, error) {
+	f, err := os.Open(dir)
+	if err != nil {
+		return nil, fmt.Errorf("error listing %q: %s", dir, err)
+	}
+	names, err := f.Readdirnames(-1)
+	f.Close()
+	if err != nil {
+		return nil, fmt.Errorf("error listing %q: %s", dir, err)
+	}
+	var ret []string
+	for _, name := range names {
+		if strings.HasSuffix(name, ".msg") {
+			ret = append(ret, name)
+		}
+	}
+	sort.Strings(ret)
+	return ret, nil
+}
+
+// claim moves the first message in new into cur and reads it. It returns
+// nil if there are no messages.
+func (s *Spool) claim(dir string) (*Delivery, error) {
+	names, err := listMessages(filepath.Join(dir, "new"))
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		filename := filepath.Join(dir, "cur", name)
+		err := os.Rename(filepath.Join(dir, "new", name), filename)
+		if os.IsNotExist(err) {
+			// Claimed by another receiver.
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error claiming %q: %s", name, err)
+		}
+		b, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return nil, fmt.Errorf("error reading %q: %s", filename, err)
+		}
+		retries, _ := messageRetries(name)
+		return &Delivery{Body: b, Retries: retries, tag: filename}, nil
+	}
+	return nil, nil
+}
+
+// Get returns the next message from the named queue, or nil if it is empty.
+func (s *Spool) Get(queueName string) (*Delivery, error) {
+	dir, err := s.queueDir(queueName)
+	if err != nil {
+		return nil, err
+	}
+	return s.claim(dir)
+}
+
+// Browse reads the messages waiting in the named queue without claiming them.
+func (s *Spool) Browse(queueName string) ([][]byte, error) {
+	dir, err := s.queueDir(queueName)
+	if err != nil {
+		return nil, err
+	}
+	names, err := listMessages(filepath.Join(dir, "new"))
+	if err != nil {
+		return nil, err
+	}
+	var ret [][]byte
+	for _, name := range names {
+		filename := filepath.Join(dir, "new", name)
+		b, err := ioutil.ReadFile(filename)
+		if os.IsNotExist(err) {
+			// Claimed by a receiver.
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading %q: %s", filename, err)
+		}
+		ret = append(ret, b)
+	}
+	return ret, nil
+}
+
+// Receive returns a (Go) channel that will deliver messages received on the
+// queue specified by a name. The messages that were delivered, but not
+// acknowledged before, are delivered again as retries.
+func (s *Spool) Receive(queueName string) (<-chan *Delivery, error) {
+	dir, err := s.queueDir(queueName)
+	if err != nil {
+		return nil, err
+	}
+	err = s.requeue(dir)
+	if err != nil {
+		return nil, err
+	}
+	ready := s.readyChan(queueName)
+	outputCh := make(chan *Delivery)
+	go func() {
+		defer close(outputCh)
+		ticker := time.NewTicker(s.PollInterval)
+		defer ticker.Stop()
+		for {
+			d, err := s.claim(dir)
+			if err != nil {
+				glog.Errorf("error receiving from spool queue %q: %s", queueName, err)
+			}
+			if d == nil {
+				select {
+				case <-ready:
+				case <-ticker.C:
+				case <-s.done:
+					return
+				}
+				continue
+			}
+			glog.V(5).Infof("received %d bytes from queue %q", len(d.Body), queueName)
+			select {
+			case outputCh <- d:
+			case <-s.done:
+				// Leave the message in cur to be requeued.
+				return
+			}
+		}
+	}()
+	return outputCh, nil
+}
+
+// filename returns the file of the delivered message in cur.
+func (s *Spool) filename(d *Delivery) (string, error) {
+	filename, ok := d.tag.(string)
+	if !ok || !strings.HasPrefix(filename, s.Dir+string(filepath.Separator)) {
+		return "", fmt.Errorf("delivery was not received from this spool")
+	}
+	return filename, nil
+}
+
+// Ack removes the delivered message from the spool.
+func (s *Spool) Ack(d *Delivery) error {
+	filename, err := s.filename(d)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(filename)
+	if err != nil {
+		return fmt.Errorf("error acknowledging message: %s", err)
+	}
+	return nil
+}
+
+// Nack moves the delivered message back to new if requeue is true and
+// it has been retried less than MaxRetries times, and moves it to
+// the dead-letter queue otherwise.
+func (s *Spool) Nack(d *Delivery, requeue bool, reason string) error {
+	filename, err := s.filename(d)
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(filepath.Dir(filename))
+	retries, base := messageRetries(filepath.Base(filename))
+	if !requeue || retries >= s.MaxRetries {
+		err = postDeadLetter(s, filepath.Base(dir), d, reason)
+		if err != nil {
+			return err
+		}
+		err = os.Remove(filename)
+		if err != nil {
+			return fmt.Errorf("error removing dead letter: %s", err)
+		}
+		return nil
+	}
+	// The requeued message keeps its place in the order of the messages.
+	name := fmt.Sprintf("%s.r%d.msg", base, retries+1)
+	err = os.Rename(filename, filepath.Join(dir, "new", name))
+	if err != nil {
+		return fmt.Errorf("error requeueing message: %s", err)
+	}
+	s.notify(filepath.Base(dir))
+	return nil
+}
+
+// Close stops the receivers and closes the channels returned by Receive.
+// The unacknowledged messages stay in the spool.
+func (s *Spool) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.closed {
+		s.closed = true
+		close(s.done)
+	}
+	return nil
+}
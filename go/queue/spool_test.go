@@ -0,0 +1,102 @@
+package queue
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSpool(t *testing.T) {
+	q, err := OpenSpool(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	q.PollInterval = 10 * time.Millisecond
+	testQueue(t, q)
+}
+
+func TestSpoolRetries(t *testing.T) {
+	q, err := OpenWithOptions("dir://"+t.TempDir(), Options{MaxRetries: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	q.(*Spool).PollInterval = 10 * time.Millisecond
+	testRetries(t, q, 2)
+}
+
+func TestSpoolReject(t *testing.T) {
+	q, err := OpenSpool(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	testReject(t, q)
+}
+
+func TestSpoolBrowse(t *testing.T) {
+	q, err := OpenSpool(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	testBrowse(t, q)
+}
+
+func TestSpoolDurable(t *testing.T) {
+	dir := t.TempDir()
+	q, err := Open("dir://" + dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, msg := range []string{"first", "second"} {
+		err := q.Post("work", []byte(msg))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	ch, err := q.Receive("work")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The first message is received, but not acknowledged before the crash.
+	receive(t, ch)
+	q.Close()
+	// Another process receives both messages.
+	q2, err := OpenSpool(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q2.Close()
+	ch, err = q2.Receive("work")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"first", "second"} {
+		d := receive(t, ch)
+		if string(d.Body) != want {
+			t.Errorf("received %q, want %q", string(d.Body), want)
+		}
+		// The redelivery of the unacknowledged message counts as a retry.
+		// The second message may have been taken by the receiver too.
+		if want == "first" && d.Retries != 1 {
+			t.Errorf("%s: Retries = %d, want 1", want, d.Retries)
+		}
+		err := q2.Ack(d)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if names, err := listMessages(dir + "/work/cur"); err != nil || len(names) != 0 {
+		t.Errorf("unacknowledged messages %q (err %v), want none", names, err)
+	}
+}
+
+func TestSpoolBadQueueName(t *testing.T) {
+	q, err := OpenSpool(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	for _, name := range []string{"", "..", "a/b", ".hidden"} {
+		if err := q.Post(name, nil); err == nil {
+			t.Errorf("Post(%q) returned success, want error", name)
+		}
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"html/template"
 	"io/ioutil"
 	"net/http"
 	"os"
@@ -17,9 +18,12 @@ import (
 	"path/filepath"
 	"reflect"
 	"sort"
+	"strings"
 	"time"
 
 	"github.com/golang/glog"
+	"github.com/google/prog-edu-assistant/envelope"
+	"github.com/google/prog-edu-assistant/notebook"
 	"github.com/google/prog-edu-assistant/queue"
 	"github.com/google/uuid"
 	"github.com/gorilla/sessions"
@@ -33,12 +37,21 @@ type Options struct {
 	// UploadDir specifies the directory to write uploaded files to
 	// and to serve on /uploads.
 	UploadDir string
+	// ReportDir specifies the directory to write the full reports to.
+	// Unlike the reports in UploadDir, they keep the details of the hidden
+	// tests for the instructors, so ReportDir must not be served.
+	// If empty, the full reports are not kept.
+	ReportDir string
+	// StrictValidation rejects the uploaded notebooks that do not match
+	// the nbformat schema. Otherwise the schema problems are only logged,
+	// and only the notebooks that cannot be parsed are rejected.
+	StrictValidation bool
 	// AllowCORS specifies whether cross-origin requests are allowed.
 	AllowCORS bool
 	// QueueName is the name of the queue to post uploads.
 	QueueName string
-	// Channel is the interface to the message queue.
-	*queue.Channel
+	// Queue is the interface to the message queue.
+	Queue queue.Queue
 	// UseOpenID enables authentication using OpenID Connect.
 	UseOpenID bool
 	// AllowedUsers lists the users that are authorized to use this service.
@@ -143,6 +156,17 @@ func (e httpError) Error() string {
 	return http.StatusText(int(e))
 }
 
+// statusError is an error with an HTTP status code and a message
+// that is shown to the user.
+type statusError struct {
+	status  int
+	message string
+}
+
+func (e *statusError) Error() string {
+	return e.message
+}
+
 // handleError is a convenience wrapper that converts Go convention of returning
 // an error into an HTTP error. This kind of reporting is not possible if the
 // handler function has already written HTTP headers, but this rarely happens
@@ -154,9 +178,10 @@ func handleError(fn func(http.ResponseWriter, *http.Request) error) http.Handler
 		err := fn(w, req)
 		if err != nil {
 			glog.Errorf("%s  %s", req.URL, err.Error())
-			status, ok := err.(httpError)
-			if ok {
+			if status, ok := err.(httpError); ok {
 				http.Error(w, err.Error(), int(status))
+			} else if e, ok := err.(*statusError); ok {
+				http.Error(w, e.message, e.status)
 			} else {
 				http.Error(w, err.Error(), http.StatusInternalServerError)
 			}
@@ -234,6 +259,13 @@ function refresh(t) {
 	}
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 	fmt.Fprintf(w, `<title>Report for %s</title>`, basename)
+	if msg, ok := data["error"].(string); ok {
+		// The submission could not be graded.
+		return errorTmpl.Execute(w, msg)
+	}
+	if points := formatPoints(data); points != "" {
+		fmt.Fprintf(w, "<p>Total: %s</p>", points)
+	}
 	var exerciseIDs []string
 	var reports = make(map[string]string)
 	// Extract reports
@@ -254,12 +286,32 @@ function refresh(t) {
 		// Just concatenate all reports in order.
 		for _, exerciseID := range exerciseIDs {
 			fmt.Fprintf(w, "<h2>%s</h2>", exerciseID)
+			if m, ok := data[exerciseID].(map[string]interface{}); ok {
+				if points := formatPoints(m); points != "" {
+					fmt.Fprintf(w, "<p>%s</p>", points)
+				}
+			}
 			fmt.Fprint(w, reports[exerciseID])
 		}
 	}
 	return nil
 }
 
+var errorTmpl = template.Must(template.New("errortemplate").Parse(`
+<h2 style='color: red'>Checker Error</h2>
+<pre>{{.}}</pre>`))
+
+// formatPoints formats the points_earned and points_possible fields of the report
+// JSON object, or returns an empty string if there are no points.
+func formatPoints(m map[string]interface{}) string {
+	earned, ok1 := m["points_earned"].(float64)
+	possible, ok2 := m["points_possible"].(float64)
+	if !ok1 || !ok2 || possible == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%g / %g points", earned, possible)
+}
+
 // handleLogin handles Open ID Connect authentication.
 func (s *Server) handleLogin(w http.ResponseWriter, req *http.Request) error {
 	url := s.oauthConfig.AuthCodeURL(s.oauthState)
@@ -424,35 +476,33 @@ func (s *Server) handleUpload(w http.ResponseWriter, req *http.Request) error {
 	if err != nil {
 		return fmt.Errorf("error reading upload: %s", err)
 	}
+	err = validateUpload(b, s.opts.StrictValidation)
+	if err != nil {
+		return err
+	}
 	// TODO(salikh): Add user identifier to the file name.
 	submissionID := uuid.New().String()
-	filename := filepath.Join(s.opts.UploadDir, submissionID+".ipynb")
-	err = ioutil.WriteFile(filename, b, 0700)
-	glog.V(3).Infof("Uploaded %d bytes", len(b))
+	// Wrap the notebook into the submission envelope with the user hash
+	// and the submission ID.
+	sub, err := envelope.FromNotebook(b)
 	if err != nil {
-		return fmt.Errorf("error writing uploaded file: %s", err)
+		return fmt.Errorf("could not parse submission: %s", err)
 	}
-	// Store user hash and submission ID inside the metadata.
-	data := make(map[string]interface{})
-	err = json.Unmarshal(b, &data)
+	sub.SubmissionID = submissionID
+	sub.UserHash = userHash
+	sub.TraceID = uuid.New().String()
+	content, err := sub.Marshal()
 	if err != nil {
-		return fmt.Errorf("could not parse submission as JSON: %s", err)
-	}
-	var metadata map[string]interface{}
-	v, ok := data["metadata"]
-	if ok {
-		metadata, ok = v.(map[string]interface{})
+		return &statusError{http.StatusBadRequest, fmt.Sprintf("The uploaded notebook cannot be graded: %s\n", err)}
 	}
-	if !ok {
-		metadata = make(map[string]interface{})
-		data["metadata"] = metadata
-	}
-	metadata["submission_id"] = submissionID
-	metadata["user_hash"] = userHash
-	b, err = json.Marshal(data)
+	filename := filepath.Join(s.opts.UploadDir, submissionID+".ipynb")
+	err = ioutil.WriteFile(filename, b, 0700)
+	glog.V(3).Infof("Uploaded %d bytes", len(b))
 	if err != nil {
-		return err
+		return fmt.Errorf("error writing uploaded file: %s", err)
 	}
+	b = content
+	glog.Infof("Submission %s (trace %s) of assignment %q", submissionID, sub.TraceID, sub.AssignmentID)
 	glog.V(3).Infof("Checking %d bytes", len(b))
 	err = s.scheduleCheck(b)
 	if err != nil {
@@ -460,44 +510,156 @@ func (s *Server) handleUpload(w http.ResponseWriter, req *http.Request) error {
 	}
 	w.Header().Set("Content-Type", "text/plain")
 	glog.V(5).Infof("Uploaded: %s", string(b))
-	fmt.Fprintf(w, "/report/"+submissionID)
+	fmt.Fprint(w, "/report/"+submissionID)
 	return nil
 }
 
+// validateUpload checks that the uploaded file is a notebook that can be
+// graded, so that malformed uploads are rejected before they reach the queue.
+// If strict is true, it also rejects the notebooks that do not match
+// the nbformat schema.
+func validateUpload(b []byte, strict bool) error {
+	n, err := notebook.Parse(b)
+	if err != nil {
+		return &statusError{http.StatusBadRequest, fmt.Sprintf("The uploaded file is not a notebook: %s\n", err)}
+	}
+	if len(n.Warnings) == 0 {
+		return nil
+	}
+	var lines []string
+	for _, p := range n.Warnings {
+		lines = append(lines, "  "+p.Error())
+	}
+	if !strict {
+		glog.Warningf("The uploaded notebook does not match the nbformat schema:\n%s", strings.Join(lines, "\n"))
+		return nil
+	}
+	return &statusError{http.StatusBadRequest,
+		"The uploaded file is not a valid notebook:\n" + strings.Join(lines, "\n") + "\n"}
+}
+
 func (s *Server) scheduleCheck(content []byte) error {
-	return s.opts.Channel.Post(s.opts.QueueName, content)
+	return s.opts.Queue.Post(s.opts.QueueName, content)
 }
 
-func (s *Server) ListenForReports(ch <-chan []byte) {
+// ListenForReports writes the reports received from the channel into
+// the upload directory.
+func (s *Server) ListenForReports(ch <-chan *queue.Delivery) {
 	glog.Infof("Listening for reports")
-	for b := range ch {
-		glog.V(3).Infof("Received %d byte report", len(b))
-		glog.V(5).Infof("Received: %s", string(b))
-		data := make(map[string]interface{})
-		err := json.Unmarshal(b, &data)
-		if err != nil {
-			glog.Errorf("data: %q, error: %s", string(b), err)
+	for d := range ch {
+		err := s.writeReport(d.Body)
+		if err == nil {
+			err = s.opts.Queue.Ack(d)
+			if err != nil {
+				glog.Errorf("Error acknowledging the report: %s", err)
+			}
 			continue
 		}
-		v, ok := data["submission_id"]
+		glog.Errorf("Error writing the report: %s", err)
+		// The malformed reports are moved to the dead-letter queue right away.
+		_, malformed := err.(*malformedReportError)
+		err = s.opts.Queue.Nack(d, !malformed, err.Error())
+		if err != nil {
+			glog.Errorf("Error rejecting the report: %s", err)
+		}
+	}
+	glog.Infof("Stopped listening for reports, the queue is closed")
+}
+
+// malformedReportError is returned by writeReport for the reports that
+// cannot be written at all.
+type malformedReportError struct {
+	error
+}
+
+// writeReport writes the report into the upload directory, and the full report
+// into the report directory, if it is set.
+func (s *Server) writeReport(b []byte) error {
+	glog.V(3).Infof("Received %d byte report", len(b))
+	glog.V(5).Infof("Received: %s", string(b))
+	r, err := envelope.ParseReport(b)
+	if err != nil {
+		return &malformedReportError{fmt.Errorf("data: %q, error: %s", string(b), err)}
+	}
+	glog.Infof("Report for submission %s (trace %s, attempt %d)", r.SubmissionID, r.TraceID, r.Attempt)
+	if r.Error != "" {
+		// The error report has the shape of a report with the error field.
+		b, err = json.Marshal(map[string]interface{}{
+			"submission_id": r.SubmissionID,
+			"assignment_id": r.AssignmentID,
+			"user_hash":     r.UserHash,
+			"error":         r.Error,
+		})
+	} else {
+		b, err = r.Content()
+	}
+	if err != nil {
+		return &malformedReportError{fmt.Errorf("submission %s: %s", r.SubmissionID, err)}
+	}
+	if s.opts.ReportDir != "" {
+		err = os.MkdirAll(s.opts.ReportDir, 0700)
+		if err != nil {
+			return err
+		}
+		filename := filepath.Join(s.opts.ReportDir, r.SubmissionID+".txt")
+		err = ioutil.WriteFile(filename, b, 0600)
+		if err != nil {
+			return fmt.Errorf("Error writing to %q: %s", filename, err)
+		}
+	}
+	b, err = studentReport(b)
+	if err != nil {
+		return &malformedReportError{fmt.Errorf("submission %s: %s", r.SubmissionID, err)}
+	}
+	// TODO(salikh): Write a pretty report instead.
+	filename := filepath.Join(s.opts.UploadDir, r.SubmissionID+".txt")
+	err = ioutil.WriteFile(filename, b, 0775)
+	if err != nil {
+		return fmt.Errorf("Error writing to %q: %s", filename, err)
+	}
+	return nil
+}
+
+// studentReport removes the results, logs and reports of the hidden tests
+// from the exercise outcomes in the report JSON, so that they are not served
+// to the students. The hidden tests are listed in the hidden_tests field
+// of the exercise outcome, which is removed too.
+func studentReport(b []byte) ([]byte, error) {
+	data := make(map[string]interface{})
+	err := json.Unmarshal(b, &data)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse report as JSON: %s", err)
+	}
+	stripped := false
+	for _, x := range data {
+		outcome, ok := x.(map[string]interface{})
 		if !ok {
-			glog.Errorf("Report did not have submission_id: %#v", data)
 			continue
 		}
-		submissionID, ok := v.(string)
+		hidden, ok := outcome["hidden_tests"].([]interface{})
 		if !ok {
-			glog.Errorf("submission_id was not a string, but %s",
-				reflect.TypeOf(v))
 			continue
 		}
-		// TODO(salikh): Write a pretty report instead.
-		filename := filepath.Join(s.opts.UploadDir, submissionID+".txt")
-		err = ioutil.WriteFile(filename, b, 0775)
-		if err != nil {
-			glog.Errorf("Error writing to %q: %s", filename, err)
-			continue
+		for _, v := range hidden {
+			name, ok := v.(string)
+			if !ok {
+				continue
+			}
+			for _, field := range []string{"results", "logs", "reports"} {
+				if m, ok := outcome[field].(map[string]interface{}); ok {
+					delete(m, name)
+					// The logs of the unit tests are keyed by the file name.
+					delete(m, name+".py")
+				}
+			}
 		}
+		delete(outcome, "hidden_tests")
+		stripped = true
+	}
+	if !stripped {
+		return b, nil
 	}
+	return json.Marshal(data)
 }
 
 // uploadForm provides a simple web form for manual uploads.
@@ -0,0 +1,178 @@
+package uploadserver
+
+import (
+	"bytes"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/prog-edu-assistant/envelope"
+	"github.com/google/prog-edu-assistant/queue"
+)
+
+// upload posts the notebook to the server as the upload form does.
+func upload(t *testing.T, s *Server, notebook string) *httptest.ResponseRecorder {
+	t.Helper()
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	part, err := w.CreateFormFile("notebook", "submission.ipynb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write([]byte(notebook))
+	err = w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest("POST", "/upload", &body)
+	req.Header.Set("Content-Type", w.FormDataContentType())
+	resp := httptest.NewRecorder()
+	s.mux.ServeHTTP(resp, req)
+	return resp
+}
+
+func TestUpload(t *testing.T) {
+	colab, err := ioutil.ReadFile("../notebook/testdata/colab.ipynb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		notebook string
+		strict   bool
+		want     int
+	}{
+		{
+			name:     "V4",
+			notebook: `{"nbformat": 4, "nbformat_minor": 2, "metadata": {"assignment_id": "hello"}, "cells": []}`,
+			want:     http.StatusOK,
+		},
+		{
+			// The nbformat v3 notebooks are upgraded by the autograder.
+			name: "V3",
+			notebook: `{"nbformat": 3, "nbformat_minor": 0, "metadata": {"assignment_id": "hello"},
+"worksheets": [{"cells": [{"cell_type": "code", "collapsed": false, "input": ["x = 1"],
+"language": "python", "metadata": {}, "outputs": [], "prompt_number": 1}], "metadata": {}}]}`,
+			want: http.StatusOK,
+		},
+		{
+			// The notebook saved by Colab has extra metadata.
+			name:     "Colab",
+			notebook: string(colab),
+			strict:   true,
+			want:     http.StatusOK,
+		},
+		{
+			name:     "NoCells",
+			notebook: `{"nbformat": 4, "nbformat_minor": 2, "metadata": {"assignment_id": "hello"}}`,
+			strict:   true,
+			want:     http.StatusBadRequest,
+		},
+		{
+			// The schema problems are only logged without StrictValidation.
+			name:     "NoCellsLenient",
+			notebook: `{"nbformat": 4, "nbformat_minor": 2, "metadata": {"assignment_id": "hello"}}`,
+			want:     http.StatusOK,
+		},
+		{
+			name:     "NotNotebook",
+			notebook: `{"nbformat": 4, "nbformat_minor": 2, "metadata": {"assignment_id": "hello"}, "cells": [{"cell_type": "code", "metadata": {}, "source": 5}]}`,
+			want:     http.StatusBadRequest,
+		},
+		{
+			name:     "NoAssignmentID",
+			notebook: `{"nbformat": 4, "nbformat_minor": 2, "metadata": {}, "cells": []}`,
+			want:     http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := queue.NewMemory()
+			defer q.Close()
+			s := New(Options{
+				UploadDir:        t.TempDir(),
+				Queue:            q,
+				QueueName:        "autograde",
+				StrictValidation: tt.strict,
+			})
+			resp := upload(t, s, tt.notebook)
+			if resp.Code != tt.want {
+				t.Fatalf("upload returned status %d, want %d: %s", resp.Code, tt.want, resp.Body.String())
+			}
+			d, err := q.Get("autograde")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.want != http.StatusOK {
+				if d != nil {
+					t.Errorf("rejected upload was posted: %s", string(d.Body))
+				}
+				return
+			}
+			if d == nil {
+				t.Fatalf("upload was not posted to the queue")
+			}
+			sub, err := envelope.ParseSubmission(d.Body)
+			if err != nil {
+				t.Fatalf("ParseSubmission returned error %s, want success", err)
+			}
+			if !strings.HasSuffix(resp.Body.String(), "/report/"+sub.SubmissionID) {
+				t.Errorf("upload returned %q, want the report URL of submission %s", resp.Body.String(), sub.SubmissionID)
+			}
+		})
+	}
+}
+
+func TestWriteReportHidden(t *testing.T) {
+	s := New(Options{
+		UploadDir: t.TempDir(),
+		ReportDir: filepath.Join(t.TempDir(), "reports"),
+	})
+	r := envelope.NewReport(envelope.NewSubmission("s1"))
+	err := r.SetContent([]byte(`{
+  "submission_id": "s1",
+  "exercise": {
+    "report": "Hidden test 1: failed",
+    "results": {"SecretTest": {"passed": false}, "PublicTest": {"passed": true}},
+    "logs": {"SecretTest.py": "secret log", "PublicTest.py": "public log"},
+    "reports": {"SecretTest": "secret report"},
+    "hidden_tests": ["SecretTest"]
+  }
+}`), envelope.EncodingJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := r.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.writeReport(b)
+	if err != nil {
+		t.Fatalf("writeReport returned error %s, want success", err)
+	}
+	served, err := ioutil.ReadFile(filepath.Join(s.opts.UploadDir, "s1.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(served), "Secret") || strings.Contains(string(served), "secret") {
+		t.Errorf("served report = %s, want no hidden test details", string(served))
+	}
+	for _, want := range []string{"PublicTest", "public log", "Hidden test 1"} {
+		if !strings.Contains(string(served), want) {
+			t.Errorf("served report = %s, want %q", string(served), want)
+		}
+	}
+	full, err := ioutil.ReadFile(filepath.Join(s.opts.ReportDir, "s1.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"SecretTest", "secret log", "hidden_tests"} {
+		if !strings.Contains(string(full), want) {
+			t.Errorf("full report = %s, want %q", string(full), want)
+		}
+	}
+}
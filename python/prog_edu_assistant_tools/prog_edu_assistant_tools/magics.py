@@ -5,6 +5,7 @@ of master assignment notebooks more convenient. The functionality it provides:
 
 * Capture canonical solution with %%solution magic
 * Anticipate incorrect submissions and test them with %%submission magic
+* Test the programs that read input and print output with %%iotest magic
 * Create report templates with %%template magic
 * Use autotest() and report() functions to run the tests and render
   reports right in the notebook.
@@ -12,6 +13,7 @@ of master assignment notebooks more convenient. The functionality it provides:
 import ast
 import io
 import re
+import subprocess
 import types
 import unittest
 import sys
@@ -151,6 +153,8 @@ Something is wrong.
         template_source += result_template
         actual_template = jinja2.Template(template_source)
         html = actual_template.render(**kwargs)
+    elif isinstance(template, types.SimpleNamespace) and template.type == 'gotemplate':
+        raise Exception("Go templates are only rendered by the autograder")
     else:
         raise Exception("Unrecognized template argument of class %s" %
                 (test_case.__class__))
@@ -309,13 +313,15 @@ class MyMagics(magic.Magics):
 
         An inline test is a piece of code that can be executed either directly
         in the Jupyter notebook, or extracted into an automated test that 
-        takes the notebook context into account.
+        takes the notebook context into account. The test can be marked
+        hidden, e.g. %%inlinetest Name hidden, to hide it from the students.
         """
 
-        name = line
-        if not re.fullmatch(r'[a-zA-Z][a-zA-Z0-9_]*', name):
+        m = re.fullmatch(r'([a-zA-Z][a-zA-Z0-9_]*)(?:\s+hidden)?\s*', line)
+        if not m:
             raise Exception("%%inlinetest must use an identifier as a name, "
-                            "got %s" % name)
+                            "got %s" % line)
+        name = m.group(1)
 
         # Copy the source into the variable 
         self.shell.user_ns[name] = types.SimpleNamespace(
@@ -361,6 +367,48 @@ class MyMagics(magic.Magics):
         for k in env:
             self.shell.user_ns[k] = env[k]
 
+    @magic.cell_magic
+    def iotest(self, line, cell):
+        """Registers an input/output test and runs it on the current submission.
+
+        Syntax:
+          %%iotest Name
+          input lines
+          # OUTPUT
+          expected output lines
+
+        The submission is run as a program with the input on stdin, and its
+        output must match the expected output, ignoring the trailing whitespace
+        of the lines and the trailing empty lines. The autograder applies
+        the comparison options of the exercise in addition.
+        """
+        name = line.strip()
+        if not re.fullmatch(r'[a-zA-Z][a-zA-Z0-9_]*', name):
+            raise Exception("%%iotest must use an identifier as a name, "
+                            "got %s" % name)
+        m = re.search(r'^[ \t]*# OUTPUT[ \t]*(?:\n|$)', cell, re.MULTILINE)
+        if not m:
+            raise Exception("%%iotest cell has no # OUTPUT marker")
+        stdin = cell[:m.start()]
+        expected = cell[m.end():]
+        self.shell.user_ns[name] = types.SimpleNamespace(
+            input=stdin, output=expected, type='iotest', name=name)
+        source = self.shell.user_ns['submission_source'].source
+        result = subprocess.run([sys.executable, '-c', source], input=stdin,
+                                stdout=subprocess.PIPE, stderr=subprocess.PIPE,
+                                universal_newlines=True, timeout=30)
+
+        def normalize(text):
+            return [line.rstrip() for line in text.rstrip().split('\n')]
+
+        if result.returncode != 0:
+            raise AssertionError('%%iotest %s: the submission failed:\n%s' %
+                                 (name, result.stderr))
+        if normalize(result.stdout) != normalize(expected):
+            raise AssertionError(
+                '%%iotest %s: got output\n%s\nwant\n%s' %
+                (name, result.stdout, expected))
+
     @magic.cell_magic
     def template(self, line, cell):
         """Registers a template for report generation.
@@ -379,11 +427,18 @@ class MyMagics(magic.Magics):
         `results=result.results` where `result` is an instance of
         `SummaryTestResult` returned by `autotest()`.
 
+        With `%%template NAME go` the cell is a Go html/template template,
+        which is rendered only by the autograder and cannot be previewed
+        with report().
+
         Warning: %%template must not use triple-quotes inside.
         """
-        name = line
-        if line == '':
-            name = 'report_template'
+        args = line.split()
+        name = args[0] if args else 'report_template'
+        if len(args) > 1 and args[1] == 'go':
+            self.shell.user_ns[name] = types.SimpleNamespace(
+                type='gotemplate', source=cell)
+            return
         if re.search('"""', cell):
             raise Exception("%%template must not use triple-quotes")
         # Define a Jinja2 template based on cell contents.